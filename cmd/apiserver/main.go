@@ -1,13 +1,22 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/fs"
 	"log"
+	"net/http"
 	"os"
 
 	"github.com/10xdev4u-alt/aura/pkg/api/handlers"
 	"github.com/10xdev4u-alt/aura/pkg/api/middleware"
 	"github.com/10xdev4u-alt/aura/pkg/config"
 	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/delta"
+	"github.com/10xdev4u-alt/aura/pkg/ota"
+	"github.com/10xdev4u-alt/aura/pkg/pki"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
 	"github.com/10xdev4u-alt/aura/pkg/storage"
 	"github.com/gin-gonic/gin"
 )
@@ -18,9 +27,12 @@ func main() {
 	configPath := os.Getenv("CONFIG_PATH")
 	if configPath != "" {
 		loadedCfg, err := config.LoadConfig(configPath)
-		if err != nil {
-			log.Printf("Warning: Failed to load config from %s, using defaults: %v", configPath, err)
-		} else {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			log.Printf("Warning: Config file %s not found, using defaults", configPath)
+		case err != nil:
+			log.Fatalf("Failed to load config from %s: %v", configPath, err)
+		default:
 			cfg = loadedCfg
 		}
 	}
@@ -44,15 +56,19 @@ func main() {
 		log.Fatalf("Failed to initialize database schema: %v", err)
 	}
 
-	storagePath := os.Getenv("STORAGE_PATH")
-	if storagePath == "" {
-		storagePath = "./data/firmware"
-	}
-	localStorage, err := storage.NewLocalStorage(storagePath)
+	firmwareStorage, err := newStorage(cfg.Storage)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 
+	buildKeys, err := pki.LoadFirmwareVerifier(cfg.Signing.BuildKeyFiles)
+	if err != nil {
+		log.Fatalf("Failed to load build keys: %v", err)
+	}
+	if !buildKeys.Enabled() {
+		log.Println("Warning: no trusted build keys configured, firmware uploads are not verified")
+	}
+
 	router := gin.Default()
 
 	router.Use(middleware.Logger())
@@ -63,8 +79,29 @@ func main() {
 	router.GET("/ready", healthHandler.Ready)
 
 	deviceHandler := handlers.NewDeviceHandler(db)
-	firmwareHandler := handlers.NewFirmwareHandler(db, localStorage)
-	releaseHandler := handlers.NewReleaseHandler(db)
+	firmwareHandler := handlers.NewFirmwareHandler(db, firmwareStorage, buildKeys, cfg.Storage.UploadDir,
+		cfg.Storage.MaxFirmwareSize, cfg.Storage.UploadSessionTTL)
+	go firmwareHandler.CollectExpiredUploads()
+	if cfg.Storage.FirmwareRetention > 0 {
+		go firmwareHandler.CollectFirmware(cfg.Storage.FirmwareRetention)
+	}
+	go func() {
+		if err := firmwareHandler.MigrateBlobs(); err != nil {
+			log.Printf("Failed to migrate firmware storage: %v", err)
+		}
+	}()
+	var deltaBuilder *delta.Builder
+	if cfg.OTA.Delta.Enabled {
+		deltaBuilder = delta.NewBuilder(db, firmwareStorage, cfg.Storage.UploadDir, cfg.OTA.Delta.MaxImageSize,
+			cfg.OTA.Delta.MinSavings)
+	}
+	releaseHandler := handlers.NewReleaseHandler(db, ota.CanaryPolicy{
+		Size:            cfg.OTA.Canary.Size,
+		DogfoodDevices:  cfg.OTA.Canary.DogfoodDevices,
+		MinBatteryLevel: cfg.OTA.Canary.MinBatteryLevel,
+		OfflineAfter:    cfg.OTA.Canary.OfflineAfter,
+	}, cfg.OTA.Conflicts, deltaBuilder)
+	maintenanceHandler := handlers.NewMaintenanceHandler(db)
 
 	v1 := router.Group("/api/v1")
 	{
@@ -78,8 +115,22 @@ func main() {
 		firmware := v1.Group("/firmware")
 		{
 			firmware.GET("", firmwareHandler.ListFirmware)
+			firmware.GET("/search", firmwareHandler.SearchComponents)
 			firmware.GET("/:id", firmwareHandler.GetFirmware)
+			firmware.GET("/:id/deltas", firmwareHandler.ListDeltas)
+			firmware.GET("/:id/sbom", firmwareHandler.GetSBOM)
+			firmware.PUT("/:id/metadata", firmwareHandler.SetMetadata)
+			firmware.POST("/:id/deprecate", firmwareHandler.FirmwareAction(database.FirmwareActionDeprecate))
+			firmware.POST("/:id/yank", firmwareHandler.FirmwareAction(database.FirmwareActionYank))
+			firmware.POST("/:id/restore", firmwareHandler.FirmwareAction(database.FirmwareActionRestore))
 			firmware.POST("", firmwareHandler.UploadFirmware)
+			firmware.POST("/bundles", firmwareHandler.UploadBundle)
+			firmware.POST("/uploads", firmwareHandler.CreateUpload)
+			firmware.GET("/uploads/:id", firmwareHandler.GetUpload)
+			firmware.HEAD("/uploads/:id", firmwareHandler.GetUpload)
+			firmware.PUT("/uploads/:id", firmwareHandler.UploadChunk)
+			firmware.POST("/uploads/:id/finalize", firmwareHandler.FinalizeUpload)
+			firmware.DELETE("/uploads/:id", firmwareHandler.CancelUpload)
 		}
 
 		releases := v1.Group("/releases")
@@ -87,10 +138,33 @@ func main() {
 			releases.GET("", releaseHandler.ListReleases)
 			releases.GET("/:id", releaseHandler.GetRelease)
 			releases.POST("", releaseHandler.CreateRelease)
-			releases.PUT("/:id/status", releaseHandler.UpdateReleaseStatus)
+			releases.POST("/dry-run", releaseHandler.DryRunRelease)
+			releases.GET("/:id/devices", releaseHandler.ListReleaseDevices)
+			releases.GET("/:id/events", releaseHandler.ListReleaseEvents)
+			releases.GET("/:id/schedule", releaseHandler.GetReleaseSchedule)
+			releases.POST("/:id/pause", releaseHandler.ReleaseAction(rollout.ActionPause))
+			releases.POST("/:id/resume", releaseHandler.ReleaseAction(rollout.ActionResume))
+			releases.POST("/:id/abort", releaseHandler.ReleaseAction(rollout.ActionAbort))
+			releases.POST("/:id/promote", releaseHandler.ReleaseAction(rollout.ActionPromote))
+			releases.POST("/:id/retry-failed", releaseHandler.ReleaseAction(rollout.ActionRetryFailed))
+			releases.POST("/:id/rollback", releaseHandler.ReleaseAction(rollout.ActionRollback))
+		}
+
+		windows := v1.Group("/maintenance-windows")
+		{
+			windows.GET("", maintenanceHandler.ListWindows)
+			windows.POST("", maintenanceHandler.CreateWindow)
+			windows.DELETE("/:id", maintenanceHandler.DeleteWindow)
 		}
 	}
 
+	urlSigner, err := loadURLSigner(cfg.Download)
+	if err != nil {
+		log.Fatalf("Failed to load download URL keys: %v", err)
+	}
+	downloadHandler := handlers.NewDownloadHandler(db, firmwareStorage, urlSigner)
+	go serveDownloads(cfg.Download, downloadHandler)
+
 	port := os.Getenv("API_PORT")
 	if port == "" {
 		port = "8080"
@@ -100,4 +174,90 @@ func main() {
 	if err := router.Run(":" + port); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+}
+
+// newStorage opens the configured firmware storage backend. STORAGE_PATH
+// overrides the local storage directory.
+func newStorage(cfg config.StorageConfig) (storage.Storage, error) {
+	switch cfg.Backend {
+	case "s3":
+		log.Printf("Storing firmware in S3 bucket %s at %s", cfg.S3.Bucket, cfg.S3.Endpoint)
+		return storage.NewS3Storage(storage.S3Config{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			Prefix:          cfg.S3.Prefix,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			PresignTTL:      cfg.S3.PresignTTL,
+		})
+	default:
+		path := os.Getenv("STORAGE_PATH")
+		if path == "" {
+			path = cfg.Local.Path
+		}
+		log.Printf("Storing firmware in %s", path)
+		return storage.NewLocalStorage(path)
+	}
+}
+
+// loadURLSigner loads the keys that sign download URLs.
+func loadURLSigner(cfg config.DownloadConfig) (*pki.URLSigner, error) {
+	keys := make([]pki.URLKey, 0, len(cfg.URLKeys))
+	for _, key := range cfg.URLKeys {
+		loaded, err := pki.LoadURLKey(key.ID, key.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, loaded)
+	}
+	return pki.NewURLSigner(keys)
+}
+
+// serveDownloads runs the firmware download server, which devices reach over
+// TLS with a signed URL or the client certificate issued at provisioning.
+func serveDownloads(cfg config.DownloadConfig, handler *handlers.DownloadHandler) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		log.Println("Warning: download.cert_file and key_file are not set, firmware downloads are disabled")
+		return
+	}
+
+	var clientCAs *x509.CertPool
+	if cfg.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			log.Fatalf("Failed to read download client CA: %v", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			log.Fatalf("No certificates found in %s", cfg.ClientCAFile)
+		}
+	}
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(middleware.Logger())
+	router.GET("/firmware/:id", handler.DownloadFirmware)
+	router.HEAD("/firmware/:id", handler.DownloadFirmware)
+	router.GET("/firmware/:id/artifacts/:name", handler.DownloadArtifact)
+	router.HEAD("/firmware/:id/artifacts/:name", handler.DownloadArtifact)
+	router.GET("/deltas/:id", handler.DownloadDelta)
+	router.HEAD("/deltas/:id", handler.DownloadDelta)
+
+	server := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: router,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+	if clientCAs != nil {
+		server.TLSConfig.ClientCAs = clientCAs
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	log.Printf("Firmware download server listening on port %s", cfg.Port)
+	if err := server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile); err != nil {
+		log.Fatalf("Failed to start download server: %v", err)
+	}
 }
\ No newline at end of file
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"net"
 	"os"
@@ -27,9 +29,12 @@ func main() {
 	configPath := os.Getenv("CONFIG_PATH")
 	if configPath != "" {
 		loadedCfg, err := config.LoadConfig(configPath)
-		if err != nil {
-			log.Printf("Warning: Failed to load config from %s, using defaults: %v", configPath, err)
-		} else {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			log.Printf("Warning: Config file %s not found, using defaults", configPath)
+		case err != nil:
+			log.Fatalf("Failed to load config from %s: %v", configPath, err)
+		default:
 			cfg = loadedCfg
 		}
 	}
@@ -55,11 +60,24 @@ func main() {
 		}
 	}
 
-	pkiService, err := pki.NewPKIService()
+	updateSigner, err := pki.LoadUpdateSigner(cfg.Signing.UpdateKeyFile)
 	if err != nil {
-		log.Fatalf("Failed to initialize PKI service: %v", err)
+		log.Fatalf("Failed to load update signing key: %v", err)
 	}
-	log.Println("PKI service initialized with new CA")
+	log.Printf("Update signing key %s loaded from %s", updateSigner.KeyID(), cfg.Signing.UpdateKeyFile)
+
+	buildKeys, err := pki.LoadFirmwareVerifier(cfg.Signing.BuildKeyFiles)
+	if err != nil {
+		log.Fatalf("Failed to load build keys: %v", err)
+	}
+
+	ca, err := pki.LoadCertificateAuthority(cfg.Signing.CACertFile, cfg.Signing.CAKeyFile)
+	if err != nil {
+		log.Fatalf("Failed to load CA: %v", err)
+	}
+	log.Printf("CA loaded from %s", cfg.Signing.CACertFile)
+
+	pkiService := pki.NewPKIService(ca, updateSigner, buildKeys)
 
 	port := cfg.Server.Port
 	if port == "" {
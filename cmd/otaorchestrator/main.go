@@ -1,7 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,6 +15,7 @@ import (
 	"github.com/10xdev4u-alt/aura/pkg/database"
 	"github.com/10xdev4u-alt/aura/pkg/mqtt"
 	"github.com/10xdev4u-alt/aura/pkg/ota"
+	"github.com/10xdev4u-alt/aura/pkg/pki"
 )
 
 func main() {
@@ -18,9 +24,12 @@ func main() {
 	configPath := os.Getenv("CONFIG_PATH")
 	if configPath != "" {
 		loadedCfg, err := config.LoadConfig(configPath)
-		if err != nil {
-			log.Printf("Warning: Failed to load config from %s, using defaults: %v", configPath, err)
-		} else {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			log.Printf("Warning: Config file %s not found, using defaults", configPath)
+		case err != nil:
+			log.Fatalf("Failed to load config from %s: %v", configPath, err)
+		default:
 			cfg = loadedCfg
 		}
 	}
@@ -40,17 +49,33 @@ func main() {
 	}
 	defer db.Close()
 
+	replicaID := cfg.OTA.ReplicaID
+	if replicaID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "otaorchestrator"
+		}
+		replicaID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
 	mqttBroker := os.Getenv("MQTT_BROKER")
 	if mqttBroker == "" {
 		mqttBroker = "localhost"
 	}
 
+	updateSigner, err := pki.LoadUpdateSigner(cfg.Signing.UpdateKeyFile)
+	if err != nil {
+		log.Fatalf("Failed to load update signing key: %v", err)
+	}
+	log.Printf("Signing commands with update signing key %s", updateSigner.KeyID())
+
 	mqttCfg := mqtt.Config{
 		Broker:   mqttBroker,
 		Port:     1883,
-		ClientID: "aura-ota-orchestrator",
+		ClientID: "aura-ota-orchestrator-" + replicaID,
 		Username: "",
 		Password: "",
+		Signer:   updateSigner,
 	}
 
 	mqttClient, err := mqtt.NewClient(mqttCfg)
@@ -59,10 +84,75 @@ func main() {
 	}
 	defer mqttClient.Disconnect()
 
-	orchestrator := ota.NewOrchestrator(db, mqttClient)
+	urlSigner, err := loadURLSigner(cfg.Download)
+	if err != nil {
+		log.Fatalf("Failed to load download URL keys: %v", err)
+	}
+
+	otaCfg := ota.Config{
+		PollInterval:        cfg.OTA.PollInterval,
+		ConflictPolicy:      cfg.OTA.Conflicts,
+		CommandTTL:          cfg.OTA.CommandTTL,
+		DownloadBaseURL:     cfg.Download.BaseURL,
+		URLSigner:           urlSigner,
+		ReplicaID:           replicaID,
+		LeaseTTL:            cfg.OTA.LeaseTTL,
+		DeltaUpdates:        cfg.OTA.Delta.Enabled,
+		YankedAlertInterval: cfg.OTA.YankedAlertInterval,
+		Canary: ota.CanaryPolicy{
+			Size:            cfg.OTA.Canary.Size,
+			DogfoodDevices:  cfg.OTA.Canary.DogfoodDevices,
+			MinBatteryLevel: cfg.OTA.Canary.MinBatteryLevel,
+			OfflineAfter:    cfg.OTA.Canary.OfflineAfter,
+		},
+		Dispatch: ota.DispatchLimits{
+			CommandsPerSecond:     cfg.OTA.Dispatch.CommandsPerSecond,
+			Burst:                 cfg.OTA.Dispatch.Burst,
+			MaxInFlightPerRelease: cfg.OTA.Dispatch.MaxInFlightPerRelease,
+			MaxInFlight:           cfg.OTA.Dispatch.MaxInFlight,
+			MaxDownloading:        cfg.OTA.Dispatch.MaxDownloading,
+		},
+		Timeouts: ota.UpdateTimeouts{
+			Acknowledge: cfg.OTA.Timeouts.Acknowledge,
+			Download:    cfg.OTA.Timeouts.Download,
+			Install:     cfg.OTA.Timeouts.Install,
+			Reboot:      cfg.OTA.Timeouts.Reboot,
+		},
+		Retry: ota.RetryPolicy{
+			MaxAttempts:    cfg.OTA.Retry.MaxAttempts,
+			InitialBackoff: cfg.OTA.Retry.InitialBackoff,
+			MaxBackoff:     cfg.OTA.Retry.MaxBackoff,
+		},
+	}
+
+	orchestrator := ota.NewOrchestrator(db, mqttClient, otaCfg)
 
 	go orchestrator.Start()
 
+	healthPort := os.Getenv("HEALTH_PORT")
+	if healthPort == "" {
+		healthPort = "8081"
+	}
+
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		status := orchestrator.Status()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":     "healthy",
+			"service":    "aura-ota-orchestrator",
+			"replica_id": status.ReplicaID,
+			"leader":     status.Leader,
+			"leader_id":  status.LeaderID,
+		})
+	})
+
+	go func() {
+		log.Printf("OTA Orchestrator health endpoint listening on port %s", healthPort)
+		if err := http.ListenAndServe(":"+healthPort, nil); err != nil {
+			log.Printf("Health endpoint stopped: %v", err)
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -70,4 +160,17 @@ func main() {
 	log.Println("Shutting down OTA Orchestrator...")
 	orchestrator.Stop()
 	log.Println("OTA Orchestrator stopped")
+}
+
+// loadURLSigner loads the keys that sign download URLs.
+func loadURLSigner(cfg config.DownloadConfig) (*pki.URLSigner, error) {
+	keys := make([]pki.URLKey, 0, len(cfg.URLKeys))
+	for _, key := range cfg.URLKeys {
+		loaded, err := pki.LoadURLKey(key.ID, key.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, loaded)
+	}
+	return pki.NewURLSigner(keys)
 }
\ No newline at end of file
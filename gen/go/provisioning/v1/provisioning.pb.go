@@ -197,9 +197,18 @@ type ProvisionResponse struct {
 	// The hostname of the MQTT broker to connect to.
 	MqttHost string `protobuf:"bytes,5,opt,name=mqtt_host,json=mqttHost,proto3" json:"mqtt_host,omitempty"`
 	// The port of the MQTT broker.
-	MqttPort      int32 `protobuf:"varint,6,opt,name=mqtt_port,json=mqttPort,proto3" json:"mqtt_port,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	MqttPort int32 `protobuf:"varint,6,opt,name=mqtt_port,json=mqttPort,proto3" json:"mqtt_port,omitempty"`
+	// The PEM-encoded public key that signs update commands. The device must
+	// discard any command envelope whose signature does not verify against it.
+	UpdateSigningKey string `protobuf:"bytes,7,opt,name=update_signing_key,json=updateSigningKey,proto3" json:"update_signing_key,omitempty"`
+	// The identifier of update_signing_key, as carried in the key_id of each
+	// command envelope.
+	UpdateSigningKeyId string `protobuf:"bytes,8,opt,name=update_signing_key_id,json=updateSigningKeyId,proto3" json:"update_signing_key_id,omitempty"`
+	// The PEM-encoded public keys of the trusted build systems. The device must
+	// refuse to install an image whose signature does not verify against one.
+	FirmwareSigningKeys []string `protobuf:"bytes,9,rep,name=firmware_signing_keys,json=firmwareSigningKeys,proto3" json:"firmware_signing_keys,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
 }
 
 func (x *ProvisionResponse) Reset() {
@@ -274,6 +283,27 @@ func (x *ProvisionResponse) GetMqttPort() int32 {
 	return 0
 }
 
+func (x *ProvisionResponse) GetUpdateSigningKey() string {
+	if x != nil {
+		return x.UpdateSigningKey
+	}
+	return ""
+}
+
+func (x *ProvisionResponse) GetUpdateSigningKeyId() string {
+	if x != nil {
+		return x.UpdateSigningKeyId
+	}
+	return ""
+}
+
+func (x *ProvisionResponse) GetFirmwareSigningKeys() []string {
+	if x != nil {
+		return x.FirmwareSigningKeys
+	}
+	return nil
+}
+
 var File_provisioning_proto protoreflect.FileDescriptor
 
 const file_provisioning_proto_rawDesc = "" +
@@ -287,7 +317,7 @@ const file_provisioning_proto_rawDesc = "" +
 	"expires_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\"[\n" +
 	"\x10ProvisionRequest\x12\x1c\n" +
 	"\tchallenge\x18\x01 \x01(\tR\tchallenge\x12)\n" +
-	"\x10signed_challenge\x18\x02 \x01(\fR\x0fsignedChallenge\"\xdf\x01\n" +
+	"\x10signed_challenge\x18\x02 \x01(\fR\x0fsignedChallenge\"\xf4\x02\n" +
 	"\x11ProvisionResponse\x12\x1b\n" +
 	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12-\n" +
 	"\x12client_certificate\x18\x02 \x01(\tR\x11clientCertificate\x12\x1d\n" +
@@ -295,7 +325,10 @@ const file_provisioning_proto_rawDesc = "" +
 	"client_key\x18\x03 \x01(\tR\tclientKey\x12%\n" +
 	"\x0eca_certificate\x18\x04 \x01(\tR\rcaCertificate\x12\x1b\n" +
 	"\tmqtt_host\x18\x05 \x01(\tR\bmqttHost\x12\x1b\n" +
-	"\tmqtt_port\x18\x06 \x01(\x05R\bmqttPort2\xd1\x01\n" +
+	"\tmqtt_port\x18\x06 \x01(\x05R\bmqttPort\x12,\n" +
+	"\x12update_signing_key\x18\a \x01(\tR\x10updateSigningKey\x121\n" +
+	"\x15update_signing_key_id\x18\b \x01(\tR\x12updateSigningKeyId\x122\n" +
+	"\x15firmware_signing_keys\x18\t \x03(\tR\x13firmwareSigningKeys2\xd1\x01\n" +
 	"\x13ProvisioningService\x12\\\n" +
 	"\tBootstrap\x12&.aura.provisioning.v1.BootstrapRequest\x1a'.aura.provisioning.v1.BootstrapResponse\x12\\\n" +
 	"\tProvision\x12&.aura.provisioning.v1.ProvisionRequest\x1a'.aura.provisioning.v1.ProvisionResponseBDZBgithub.com/10xdev4u-alt/aura/gen/go/provisioning/v1;provisioningv1b\x06proto3"
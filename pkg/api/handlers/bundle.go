@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/10xdev4u-alt/aura/pkg/bundle"
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// UploadBundle takes firmware made of several artifacts as one archive with
+// a manifest. The archive is unpacked and every artifact checked against the
+// manifest before anything is saved. The manifest becomes the firmware's
+// image, so an uploaded signature must cover the manifest.
+func (h *FirmwareHandler) UploadBundle(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxSize+maxFormOverhead)
+	form, archive, signatureData, err := h.readUploadForm(c.Request)
+	if err != nil {
+		h.respondUploadError(c, err)
+		return
+	}
+	defer archive.Discard()
+
+	contents, err := bundle.Unpack(archive.ReaderAt(), archive.Size(), h.uploadDir, h.maxSize)
+	if err != nil {
+		h.respondUploadError(c, err)
+		return
+	}
+	defer contents.Discard()
+
+	manifest := contents.Manifest
+	compat := database.FirmwareCompatibility{
+		HardwareModels:     manifest.HardwareModels,
+		MinSourceVersion:   strings.TrimSpace(manifest.MinSourceVersion),
+		RequiredBootloader: strings.TrimSpace(manifest.RequiredBootloader),
+		UpgradePath:        manifest.UpgradePath,
+	}
+	if err := validateCompatibility(compat, manifest.Version); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req := metadataForm(form)
+	metadata, err := req.metadata()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	upload, err := storage.NewUpload(h.uploadDir, bytes.NewReader(contents.ManifestData), h.maxSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stage manifest"})
+		return
+	}
+	defer upload.Discard()
+
+	h.createFirmware(c, manifest.Version, manifest.Description, compat, upload, signatureData, contents.Files, metadata)
+}
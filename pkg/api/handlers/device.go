@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/10xdev4u-alt/aura/pkg/database"
 	"github.com/gin-gonic/gin"
@@ -43,6 +44,10 @@ func (h *DeviceHandler) GetDevice(c *gin.Context) {
 func (h *DeviceHandler) CreateDevice(c *gin.Context) {
 	var req struct {
 		BootstrapToken string `json:"bootstrap_token" binding:"required"`
+		HardwareModel  string `json:"hardware_model"`
+		Region         string `json:"region"`
+		DeviceGroup    string `json:"device_group"`
+		Timezone       string `json:"timezone"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -50,7 +55,19 @@ func (h *DeviceHandler) CreateDevice(c *gin.Context) {
 		return
 	}
 
-	deviceID, err := h.db.CreateDeviceWithToken(req.BootstrapToken)
+	if req.Timezone != "" {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid timezone"})
+			return
+		}
+	}
+
+	deviceID, err := h.db.CreateDeviceWithToken(req.BootstrapToken, database.DeviceAttributes{
+		HardwareModel: req.HardwareModel,
+		Region:        req.Region,
+		DeviceGroup:   req.DeviceGroup,
+		Timezone:      req.Timezone,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
 		return
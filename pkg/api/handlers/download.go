@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/ota"
+	"github.com/10xdev4u-alt/aura/pkg/pki"
+	"github.com/10xdev4u-alt/aura/pkg/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// DownloadHandler serves firmware images to devices. Devices identify
+// themselves with a signed download URL or with the client certificate issued
+// at provisioning, whose common name is the device ID, and may only fetch
+// images they have been sent.
+type DownloadHandler struct {
+	db        *database.DB
+	storage   storage.Storage
+	urlSigner *pki.URLSigner
+}
+
+func NewDownloadHandler(db *database.DB, storage storage.Storage, urlSigner *pki.URLSigner) *DownloadHandler {
+	return &DownloadHandler{
+		db:        db,
+		storage:   storage,
+		urlSigner: urlSigner,
+	}
+}
+
+// DownloadFirmware serves a firmware image. Range and If-Range requests are
+// honoured so interrupted downloads can resume, and the ETag is the image's
+// SHA-256 checksum. Backends that can presign URLs serve the image
+// themselves: the device is redirected there once authorized.
+func (h *DownloadHandler) DownloadFirmware(c *gin.Context) {
+	firmwareID := c.Param("id")
+	deviceID, ok := h.authenticate(c, firmwareID)
+	if !ok {
+		return
+	}
+
+	firmware, err := h.db.GetFirmwareByID(firmwareID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "firmware not found"})
+		return
+	}
+	if firmware.PurgedAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": database.ErrFirmwarePurged.Error()})
+		return
+	}
+
+	allowed, err := h.db.DeviceCanDownload(deviceID, firmware.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check firmware access"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "firmware has not been sent to this device"})
+		return
+	}
+
+	h.serve(c, firmware.BlobName, firmware.Version+".bin", firmware.Checksum)
+}
+
+// DownloadArtifact serves one artifact of a firmware bundle. URLs signed for
+// the bundle's firmware are valid for each of its artifacts.
+func (h *DownloadHandler) DownloadArtifact(c *gin.Context) {
+	firmwareID := c.Param("id")
+	deviceID, ok := h.authenticate(c, firmwareID)
+	if !ok {
+		return
+	}
+
+	firmware, err := h.db.GetFirmwareByID(firmwareID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "firmware not found"})
+		return
+	}
+	if firmware.PurgedAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": database.ErrFirmwarePurged.Error()})
+		return
+	}
+
+	allowed, err := h.db.DeviceCanDownload(deviceID, firmware.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check firmware access"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "firmware has not been sent to this device"})
+		return
+	}
+
+	artifact, err := h.db.GetFirmwareArtifact(firmware.ID, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "artifact not found"})
+		return
+	}
+
+	h.serve(c, artifact.BlobName, firmware.Version+"-"+artifact.Name+".bin", artifact.Checksum)
+}
+
+// DownloadDelta serves a patch to a device that was sent it, like
+// DownloadFirmware.
+func (h *DownloadHandler) DownloadDelta(c *gin.Context) {
+	deltaID := c.Param("id")
+	deviceID, ok := h.authenticate(c, ota.DeltaResource(deltaID))
+	if !ok {
+		return
+	}
+
+	found, err := h.db.GetFirmwareDeltaByID(deltaID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "delta not found"})
+		return
+	}
+
+	allowed, err := h.db.DeviceCanDownloadDelta(deviceID, found.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check delta access"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "delta has not been sent to this device"})
+		return
+	}
+
+	name := found.BaseVersion + "-" + found.TargetVersion + ".patch"
+	h.serve(c, found.BlobName, name, found.Checksum)
+}
+
+// authenticate returns the device making the request, from a URL signed for
+// resource or from its client certificate. It responds with an error and
+// returns false if there is neither or they disagree.
+func (h *DownloadHandler) authenticate(c *gin.Context, resource string) (string, bool) {
+	deviceID, ok := clientDevice(c.Request)
+	if c.Query(pki.URLParamSignature) != "" {
+		urlDevice, err := h.urlSigner.Verify(resource, c.Request.URL.Query(), time.Now())
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return "", false
+		}
+		if ok && urlDevice != deviceID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "download URL was issued to another device"})
+			return "", false
+		}
+		return urlDevice, true
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "signed URL or device client certificate required"})
+		return "", false
+	}
+	return deviceID, true
+}
+
+// serve sends a stored object with its digest headers, or redirects to it.
+func (h *DownloadHandler) serve(c *gin.Context, blobName, fileName, checksum string) {
+	header := c.Writer.Header()
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("ETag", `"`+checksum+`"`)
+	if sum, err := hex.DecodeString(checksum); err == nil {
+		digest := base64.StdEncoding.EncodeToString(sum)
+		header.Set("Repr-Digest", "sha-256=:"+digest+":")
+		header.Set("Digest", "SHA-256="+digest)
+	}
+
+	if presigner, ok := h.storage.(storage.Presigner); ok {
+		location, err := presigner.PresignFirmware(blobName)
+		if err != nil {
+			log.Printf("Error presigning %s: %v", blobName, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to presign firmware"})
+			return
+		}
+		c.Redirect(http.StatusTemporaryRedirect, location)
+		return
+	}
+
+	info, err := h.storage.StatFirmware(blobName)
+	if err != nil {
+		log.Printf("Error opening %s: %v", blobName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open firmware"})
+		return
+	}
+	file := storage.NewReadSeeker(h.storage, blobName, info.Size)
+	defer file.Close()
+
+	http.ServeContent(c.Writer, c.Request, fileName, info.ModTime, file)
+}
+
+// clientDevice returns the device ID from a verified client certificate.
+func clientDevice(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	deviceID := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	return deviceID, deviceID != ""
+}
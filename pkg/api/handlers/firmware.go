@@ -1,87 +1,296 @@
 package handlers
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/10xdev4u-alt/aura/pkg/bundle"
 	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/pki"
+	"github.com/10xdev4u-alt/aura/pkg/sbom"
 	"github.com/10xdev4u-alt/aura/pkg/storage"
+	"github.com/10xdev4u-alt/aura/pkg/version"
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	// maxSignatureSize bounds uploaded signatures and bundles, which are small.
+	maxSignatureSize = 64 << 10
+	maxFieldSize     = 64 << 10
+	// maxFormOverhead allows for the form fields, an SBOM and multipart
+	// framing on top of the largest image.
+	maxFormOverhead = 1<<20 + sbom.MaxSize
+)
+
 type FirmwareHandler struct {
-	db      *database.DB
-	storage *storage.LocalStorage
+	db          *database.DB
+	storage     storage.Storage
+	buildKeys   *pki.FirmwareVerifier
+	uploadDir   string
+	maxSize     int64
+	sessionTTL  time.Duration
+	uploadLocks sync.Map
 }
 
-func NewFirmwareHandler(db *database.DB, storage *storage.LocalStorage) *FirmwareHandler {
+// NewFirmwareHandler creates a handler that stages uploads in uploadDir and
+// rejects images larger than maxSize bytes. Resumable uploads expire once
+// nothing has been received for sessionTTL.
+func NewFirmwareHandler(db *database.DB, storage storage.Storage, buildKeys *pki.FirmwareVerifier, uploadDir string,
+	maxSize int64, sessionTTL time.Duration) *FirmwareHandler {
 	return &FirmwareHandler{
-		db:      db,
-		storage: storage,
+		db:         db,
+		storage:    storage,
+		buildKeys:  buildKeys,
+		uploadDir:  uploadDir,
+		maxSize:    maxSize,
+		sessionTTL: sessionTTL,
 	}
 }
 
+// UploadFirmware streams the image to a temporary file, hashing it as it is
+// written, and only saves it once the version, compatibility and signature
+// have been checked.
 func (h *FirmwareHandler) UploadFirmware(c *gin.Context) {
-	version := c.PostForm("version")
-	description := c.PostForm("description")
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxSize+maxFormOverhead)
+	form, upload, signatureData, err := h.readUploadForm(c.Request)
+	if err != nil {
+		h.respondUploadError(c, err)
+		return
+	}
+	defer upload.Discard()
+
+	firmwareVersion := form.Get("version")
+	description := form.Get("description")
 
-	if version == "" {
+	if firmwareVersion == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "version is required"})
 		return
 	}
 
-	file, err := c.FormFile("file")
+	compat, err := parseCompatibility(form, firmwareVersion)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	src, err := file.Open()
+	req := metadataForm(form)
+	metadata, err := req.metadata()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open file"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer src.Close()
 
-	hash := sha256.New()
-	tempFile, err := file.Open()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
-		return
+	h.createFirmware(c, firmwareVersion, description, compat, upload, signatureData, nil, metadata)
+}
+
+// createFirmware verifies the signature of a received image, saves it and
+// records the firmware. For a bundle, the image is the manifest and files
+// are its artifacts. The version, compatibility and metadata must already be
+// valid. It reports whether the firmware was created.
+func (h *FirmwareHandler) createFirmware(c *gin.Context, firmwareVersion, description string,
+	compat database.FirmwareCompatibility, upload *storage.Upload, signatureData []byte, files []bundle.File,
+	metadata database.FirmwareMetadata) bool {
+	if signatureData == nil && h.buildKeys.Enabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "signature is required"})
+		return false
+	}
+	if signatureData != nil && !h.buildKeys.Enabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "signature cannot be verified: " + pki.ErrNoBuildKeys.Error()})
+		return false
+	}
+
+	var signature database.FirmwareSignature
+	if signatureData != nil {
+		var err error
+		signature, err = h.verifySignature(signatureData, pki.FirmwareDigests{
+			SHA256: upload.SHA256(),
+			SHA512: upload.SHA512(),
+		})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid signature: " + err.Error()})
+			return false
+		}
+	}
+
+	if !h.versionAvailable(c, firmwareVersion) {
+		return false
 	}
-	defer tempFile.Close()
 
-	fileContent := make([]byte, file.Size)
-	_, err = tempFile.Read(fileContent)
+	// An image may already be stored for other firmware that is being
+	// deleted, so the images stay locked until the firmware is recorded.
+	blobName := storage.BlobName(upload.Checksum())
+	blobNames := []string{blobName}
+	for _, file := range files {
+		blobNames = append(blobNames, storage.BlobName(file.Upload.Checksum()))
+	}
+	unlock, err := h.db.LockFirmwareBlobs(blobNames)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file content"})
-		return
+		log.Printf("Error saving firmware %s: %v", firmwareVersion, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save firmware"})
+		return false
+	}
+
+	var saved []string
+	deleteBlobs := func() {
+		unlock()
+		for _, name := range saved {
+			h.deleteUnreferencedBlob(name)
+		}
 	}
-	hash.Write(fileContent)
-	checksum := hex.EncodeToString(hash.Sum(nil))
 
-	firmwareID := ""
-	filePath, fileSize, err := h.storage.SaveFirmware(version, src)
+	filePath, err := h.storage.SaveFirmware(blobName, upload)
 	if err != nil {
+		log.Printf("Error saving firmware %s: %v", firmwareVersion, err)
+		deleteBlobs()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save firmware"})
-		return
+		return false
+	}
+	saved = append(saved, blobName)
+
+	var artifacts []database.FirmwareArtifact
+	for _, file := range files {
+		artifactBlob := storage.BlobName(file.Upload.Checksum())
+		artifactPath, err := h.storage.SaveFirmware(artifactBlob, file.Upload)
+		if err != nil {
+			log.Printf("Error saving artifact %s of firmware %s: %v", file.Name, firmwareVersion, err)
+			deleteBlobs()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save firmware"})
+			return false
+		}
+		saved = append(saved, artifactBlob)
+		artifact := database.FirmwareArtifact{
+			Name:         file.Name,
+			InstallOrder: file.InstallOrder,
+			BlobName:     artifactBlob,
+			FilePath:     artifactPath,
+			FileSize:     file.Upload.Size(),
+			Checksum:     file.Upload.Checksum(),
+		}
+		if file.Type != "" {
+			artifact.Type = &file.Type
+		}
+		artifacts = append(artifacts, artifact)
 	}
 
-	firmwareID, err = h.db.CreateFirmware(version, description, filePath, checksum, fileSize)
+	firmwareID, err := h.db.CreateFirmware(firmwareVersion, description, blobName, filePath, upload.Checksum(),
+		upload.Size(), compat, signature, artifacts, metadata)
 	if err != nil {
-		h.storage.DeleteFirmware(version)
+		deleteBlobs()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create firmware record"})
-		return
+		return false
 	}
+	unlock()
 
 	firmware, err := h.db.GetFirmwareByID(firmwareID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve firmware"})
-		return
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve firmware", "id": firmwareID})
+		return true
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"firmware": firmware})
+	return true
+}
+
+// deleteUnreferencedBlob removes a stored image that no firmware or delta
+// uses. Another upload may have been deduplicated against it, so it is only
+// removed once nothing refers to it, and not while an upload holds it. It
+// reports whether the image was removed.
+func (h *FirmwareHandler) deleteUnreferencedBlob(blobName string) bool {
+	deleted, err := h.db.DeleteFirmwareBlob(blobName, func() error {
+		return h.storage.DeleteFirmware(blobName)
+	})
+	if err != nil {
+		log.Printf("Error deleting firmware blob %s: %v", blobName, err)
+	}
+	return deleted
+}
+
+// MigrateBlobs moves images stored under their version, as older releases
+// did, to their content address. Each image is checked against its recorded
+// checksum on the way. Images that cannot be moved are logged and left where
+// they are.
+func (h *FirmwareHandler) MigrateBlobs() error {
+	firmwares, err := h.db.ListFirmware()
+	if err != nil {
+		return err
+	}
+
+	for _, firmware := range firmwares {
+		blobName := storage.BlobName(firmware.Checksum)
+		if firmware.BlobName == blobName {
+			continue
+		}
+		if err := h.migrateBlob(&firmware, blobName); err != nil {
+			log.Printf("Error migrating firmware %s to content-addressed storage: %v", firmware.Version, err)
+			continue
+		}
+		log.Printf("Migrated firmware %s to %s", firmware.Version, blobName)
+	}
+	return nil
+}
+
+// migrateBlob reads the image through LegacyStorage rather than by storage
+// name, since versions may contain a slash.
+func (h *FirmwareHandler) migrateBlob(firmware *database.Firmware, blobName string) error {
+	legacy, ok := h.storage.(storage.LegacyStorage)
+	if !ok {
+		return errors.New("storage backend cannot read images stored under their version")
+	}
+	data, err := legacy.GetLegacyFirmware(firmware.BlobName)
+	if err != nil {
+		return err
+	}
+	upload, err := storage.NewUpload(h.uploadDir, data, h.maxSize)
+	data.Close()
+	if err != nil {
+		return err
+	}
+	defer upload.Discard()
+
+	if upload.Checksum() != strings.ToLower(firmware.Checksum) {
+		return fmt.Errorf("stored image has checksum %s, expected %s", upload.Checksum(), firmware.Checksum)
+	}
+	filePath, err := h.storage.SaveFirmware(blobName, upload)
+	if err != nil {
+		return err
+	}
+	if err := h.db.SetFirmwareBlob(firmware.ID, blobName, filePath); err != nil {
+		return err
+	}
+
+	referenced, err := h.db.FirmwareBlobReferenced(firmware.BlobName)
+	if err != nil {
+		log.Printf("Error checking firmware blob %s: %v", firmware.BlobName, err)
+		return nil
+	}
+	if !referenced {
+		if err := legacy.DeleteLegacyFirmware(firmware.BlobName); err != nil {
+			log.Printf("Error deleting firmware blob %s: %v", firmware.BlobName, err)
+		}
+	}
+	return nil
+}
+
+// versionAvailable responds with an error and returns false if firmware with
+// the version already exists.
+func (h *FirmwareHandler) versionAvailable(c *gin.Context, firmwareVersion string) bool {
+	_, err := h.db.GetFirmwareByVersion(firmwareVersion)
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "firmware version already exists"})
+		return false
+	}
+	if !errors.Is(err, database.ErrFirmwareNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check firmware version"})
+		return false
+	}
+	return true
 }
 
 func (h *FirmwareHandler) ListFirmware(c *gin.Context) {
@@ -98,13 +307,210 @@ func (h *FirmwareHandler) ListFirmware(c *gin.Context) {
 }
 
 func (h *FirmwareHandler) GetFirmware(c *gin.Context) {
-	firmwareID := c.Param("id")
+	h.respondFirmware(c, c.Param("id"))
+}
 
+// respondFirmware responds with the firmware, the artifacts of a bundle and
+// the components listed in its SBOM.
+func (h *FirmwareHandler) respondFirmware(c *gin.Context, firmwareID string) {
 	firmware, err := h.db.GetFirmwareByID(firmwareID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "firmware not found"})
 		return
 	}
+	response := gin.H{"firmware": firmware}
+
+	if firmware.Bundle {
+		artifacts, err := h.db.ListFirmwareArtifacts(firmware.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list firmware artifacts"})
+			return
+		}
+		response["artifacts"] = artifacts
+	}
 
-	c.JSON(http.StatusOK, gin.H{"firmware": firmware})
+	if firmware.SBOMFormat != nil {
+		components, err := h.db.ListFirmwareComponents(firmware.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list firmware components"})
+			return
+		}
+		response["components"] = components
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ListDeltas returns the patches built to the firmware from older versions.
+func (h *FirmwareHandler) ListDeltas(c *gin.Context) {
+	firmware, err := h.db.GetFirmwareByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "firmware not found"})
+		return
+	}
+
+	deltas, err := h.db.ListFirmwareDeltas(firmware.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list deltas"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deltas": deltas,
+		"total":  len(deltas),
+	})
+}
+
+// readUploadForm reads a multipart upload part by part, so the image is
+// streamed to a temporary file whatever order the fields arrive in. The
+// caller must discard the returned upload.
+func (h *FirmwareHandler) readUploadForm(r *http.Request) (url.Values, *storage.Upload, []byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("expected a multipart/form-data upload: %w", err)
+	}
+
+	form := url.Values{}
+	var upload *storage.Upload
+	var signature []byte
+	fail := func(err error) (url.Values, *storage.Upload, []byte, error) {
+		if upload != nil {
+			upload.Discard()
+		}
+		return nil, nil, nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("failed to read upload: %w", err))
+		}
+
+		switch name := part.FormName(); name {
+		case "":
+		case "file":
+			if upload != nil {
+				err = errors.New("only one file may be uploaded")
+				break
+			}
+			upload, err = storage.NewUpload(h.uploadDir, part, h.maxSize)
+		case "signature":
+			signature, err = readPart(part, name, maxSignatureSize)
+		case "sbom":
+			var value []byte
+			value, err = readPart(part, name, sbom.MaxSize)
+			form.Add(name, string(value))
+		default:
+			var value []byte
+			value, err = readPart(part, name, maxFieldSize)
+			form.Add(name, string(value))
+		}
+		part.Close()
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if upload == nil {
+		return fail(errors.New("file is required"))
+	}
+	return form, upload, signature, nil
+}
+
+// respondUploadError reports a failed upload as too large or malformed.
+func (h *FirmwareHandler) respondUploadError(c *gin.Context, err error) {
+	var maxBytes *http.MaxBytesError
+	if errors.Is(err, storage.ErrTooLarge) || errors.As(err, &maxBytes) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("firmware is larger than %d bytes", h.maxSize)})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+func readPart(part io.Reader, name string, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(part, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, limit)
+	}
+	return data, nil
+}
+
+// verifySignature checks an uploaded signature or cosign bundle against the
+// trusted build keys. A bundle is stored as uploaded so devices and auditors
+// can check its transparency log entry.
+func (h *FirmwareHandler) verifySignature(data []byte, digests pki.FirmwareDigests) (database.FirmwareSignature, error) {
+	raw, bundle, err := pki.ParseSignature(data)
+	if err != nil {
+		return database.FirmwareSignature{}, err
+	}
+	verified, err := h.buildKeys.Verify(raw, digests)
+	if err != nil {
+		return database.FirmwareSignature{}, err
+	}
+	signature := database.FirmwareSignature{
+		Signature: verified.Signature,
+		Algorithm: verified.Algorithm,
+		KeyID:     verified.KeyID,
+	}
+	if bundle {
+		signature.Bundle = string(data)
+	}
+	return signature, nil
+}
+
+func parseCompatibility(form url.Values, firmwareVersion string) (database.FirmwareCompatibility, error) {
+	compat := database.FirmwareCompatibility{
+		HardwareModels:     splitList(form.Get("hardware_models")),
+		MinSourceVersion:   strings.TrimSpace(form.Get("min_source_version")),
+		RequiredBootloader: strings.TrimSpace(form.Get("required_bootloader")),
+		UpgradePath:        splitList(form.Get("upgrade_path")),
+	}
+	return compat, validateCompatibility(compat, firmwareVersion)
+}
+
+func validateCompatibility(compat database.FirmwareCompatibility, firmwareVersion string) error {
+	target, err := version.Parse(firmwareVersion)
+	if err != nil {
+		return err
+	}
+
+	if compat.MinSourceVersion != "" && !version.IsValid(compat.MinSourceVersion) {
+		return fmt.Errorf("invalid min_source_version %q", compat.MinSourceVersion)
+	}
+	if compat.RequiredBootloader != "" && !version.IsValid(compat.RequiredBootloader) {
+		return fmt.Errorf("invalid required_bootloader %q", compat.RequiredBootloader)
+	}
+
+	var previous *version.Version
+	for _, step := range compat.UpgradePath {
+		v, err := version.Parse(step)
+		if err != nil {
+			return fmt.Errorf("invalid upgrade_path entry: %w", err)
+		}
+		if v.Compare(target) >= 0 {
+			return fmt.Errorf("upgrade_path entry %s must be older than %s", step, firmwareVersion)
+		}
+		if previous != nil && v.Compare(*previous) <= 0 {
+			return fmt.Errorf("upgrade_path must be in ascending order")
+		}
+		previous = &v
+	}
+
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+	"github.com/gin-gonic/gin"
+)
+
+// firmwareGCInterval is how often images past their retention are looked for.
+const firmwareGCInterval = time.Hour
+
+// FirmwareAction returns a handler that applies one lifecycle action to the
+// firmware. Yanking reports the open releases that still send the firmware,
+// which are left for an operator to abort.
+func (h *FirmwareHandler) FirmwareAction(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		firmwareID := c.Param("id")
+
+		var req struct {
+			Actor  string `json:"actor" binding:"required"`
+			Reason string `json:"reason"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		firmware, err := h.db.ApplyFirmwareAction(firmwareID, action, req.Actor, req.Reason)
+		if errors.Is(err, database.ErrFirmwareNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "firmware not found"})
+			return
+		}
+		if errors.Is(err, database.ErrIllegalFirmwareTransition) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update firmware"})
+			return
+		}
+		log.Printf("Firmware %s is now %s (%s): %s", firmware.Version, firmware.State, req.Actor, req.Reason)
+
+		if firmware.State != database.FirmwareYanked {
+			c.JSON(http.StatusOK, gin.H{"firmware": firmware})
+			return
+		}
+
+		releases, err := h.db.ListReleasesByStatus(rollout.OpenStatuses)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list releases"})
+			return
+		}
+		openReleases := []string{}
+		for _, release := range releases {
+			if release.FirmwareID == firmware.ID {
+				openReleases = append(openReleases, release.ID)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"firmware": firmware, "open_releases": openReleases})
+	}
+}
+
+// CollectFirmware periodically deletes the images of deprecated and yanked
+// firmware that nothing has used for the retention period.
+func (h *FirmwareHandler) CollectFirmware(retention time.Duration) {
+	ticker := time.NewTicker(firmwareGCInterval)
+	defer ticker.Stop()
+
+	for {
+		h.collectFirmware(retention)
+		<-ticker.C
+	}
+}
+
+func (h *FirmwareHandler) collectFirmware(retention time.Duration) {
+	cutoff := time.Now().Add(-retention)
+	firmwares, err := h.db.ListCollectableFirmware(cutoff)
+	if err != nil {
+		log.Printf("Error listing collectable firmware: %v", err)
+		return
+	}
+
+	for _, firmware := range firmwares {
+		purged, blobNames, err := h.db.PurgeFirmware(firmware.ID, cutoff)
+		if err != nil {
+			log.Printf("Error purging firmware %s: %v", firmware.Version, err)
+			continue
+		}
+		if !purged {
+			continue
+		}
+		// The images may be shared with other firmware or with an upload in
+		// progress, so each is rechecked under its lock before it is deleted.
+		deleted := 0
+		for _, blobName := range append(blobNames, firmware.BlobName) {
+			if h.deleteUnreferencedBlob(blobName) {
+				deleted++
+			}
+		}
+		log.Printf("Purged %s firmware %s, deleted %d unshared images", firmware.State, firmware.Version, deleted)
+	}
+}
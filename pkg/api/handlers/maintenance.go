@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+	"github.com/gin-gonic/gin"
+)
+
+type MaintenanceHandler struct {
+	db *database.DB
+}
+
+func NewMaintenanceHandler(db *database.DB) *MaintenanceHandler {
+	return &MaintenanceHandler{db: db}
+}
+
+func (h *MaintenanceHandler) CreateWindow(c *gin.Context) {
+	var req struct {
+		DeviceGroup     string  `json:"device_group"`
+		Days            []int64 `json:"days"`
+		StartTime       string  `json:"start_time" binding:"required"`
+		DurationMinutes int     `json:"duration_minutes" binding:"required"`
+		Timezone        string  `json:"timezone"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := rollout.ParseWindow(req.DeviceGroup, req.Days, req.StartTime, req.DurationMinutes, req.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	windowID, err := h.db.CreateMaintenanceWindow(req.DeviceGroup, req.Days, req.StartTime, req.DurationMinutes, req.Timezone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create maintenance window"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": windowID})
+}
+
+func (h *MaintenanceHandler) ListWindows(c *gin.Context) {
+	windows, err := h.db.ListMaintenanceWindows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list maintenance windows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"windows": windows,
+		"total":   len(windows),
+	})
+}
+
+func (h *MaintenanceHandler) DeleteWindow(c *gin.Context) {
+	deleted, err := h.db.DeleteMaintenanceWindow(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete maintenance window"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "maintenance window not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/sbom"
+	"github.com/gin-gonic/gin"
+)
+
+// maxBuildClockSkew allows for build hosts whose clocks run ahead of ours.
+const maxBuildClockSkew = time.Hour
+
+var (
+	buildID        = regexp.MustCompile(`^[\x21-\x7e]{1,128}$`)
+	gitCommit      = regexp.MustCompile(`^[0-9a-f]{7,64}$`)
+	targetHardware = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,63}$`)
+)
+
+var sbomContentTypes = map[string]string{
+	sbom.FormatSPDX:      "application/spdx+json",
+	sbom.FormatCycloneDX: "application/vnd.cyclonedx+json",
+}
+
+type metadataRequest struct {
+	BuildID        string          `json:"build_id"`
+	GitCommit      string          `json:"git_commit"`
+	BuildTimestamp string          `json:"build_timestamp"`
+	TargetHardware []string        `json:"target_hardware"`
+	Changelog      string          `json:"changelog"`
+	SBOM           json.RawMessage `json:"sbom"`
+}
+
+func metadataForm(form url.Values) metadataRequest {
+	return metadataRequest{
+		BuildID:        form.Get("build_id"),
+		GitCommit:      form.Get("git_commit"),
+		BuildTimestamp: form.Get("build_timestamp"),
+		TargetHardware: splitList(form.Get("target_hardware")),
+		Changelog:      form.Get("changelog"),
+		SBOM:           json.RawMessage(form.Get("sbom")),
+	}
+}
+
+// metadata validates the request and extracts the components of its SBOM.
+func (r *metadataRequest) metadata() (database.FirmwareMetadata, error) {
+	metadata := database.FirmwareMetadata{
+		BuildID:   strings.TrimSpace(r.BuildID),
+		GitCommit: strings.ToLower(strings.TrimSpace(r.GitCommit)),
+		Changelog: strings.TrimSpace(r.Changelog),
+	}
+
+	if metadata.BuildID != "" && !buildID.MatchString(metadata.BuildID) {
+		return metadata, fmt.Errorf("invalid build_id %q", metadata.BuildID)
+	}
+	if metadata.GitCommit != "" && !gitCommit.MatchString(metadata.GitCommit) {
+		return metadata, fmt.Errorf("invalid git_commit %q", metadata.GitCommit)
+	}
+	if timestamp := strings.TrimSpace(r.BuildTimestamp); timestamp != "" {
+		built, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return metadata, fmt.Errorf("invalid build_timestamp: %w", err)
+		}
+		if built.After(time.Now().Add(maxBuildClockSkew)) {
+			return metadata, fmt.Errorf("build_timestamp %s is in the future", timestamp)
+		}
+		metadata.BuildTimestamp = &built
+	}
+	for _, target := range r.TargetHardware {
+		target = strings.TrimSpace(target)
+		if !targetHardware.MatchString(target) {
+			return metadata, fmt.Errorf("invalid target_hardware entry %q", target)
+		}
+		metadata.TargetHardware = append(metadata.TargetHardware, target)
+	}
+	if len(metadata.Changelog) > maxFieldSize {
+		return metadata, fmt.Errorf("changelog is larger than %d bytes", maxFieldSize)
+	}
+
+	data := bytes.TrimSpace(r.SBOM)
+	if len(data) == 0 || string(data) == "null" {
+		return metadata, nil
+	}
+	doc, err := sbom.Parse(data)
+	if err != nil {
+		return metadata, err
+	}
+	metadata.SBOM = &database.FirmwareSBOM{
+		Format:      doc.Format,
+		SpecVersion: doc.SpecVersion,
+		Document:    data,
+	}
+	for _, component := range doc.Components {
+		metadata.SBOM.Components = append(metadata.SBOM.Components, database.FirmwareComponent{
+			Name:    component.Name,
+			Version: component.Version,
+			PURL:    component.PURL,
+		})
+	}
+	return metadata, nil
+}
+
+// SetMetadata replaces the build metadata and SBOM of firmware, for images
+// uploaded without them or through a resumable upload.
+func (h *FirmwareHandler) SetMetadata(c *gin.Context) {
+	firmwareID := c.Param("id")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxFormOverhead)
+	var req metadataRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	metadata, err := req.metadata()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.db.SetFirmwareMetadata(firmwareID, metadata)
+	if errors.Is(err, database.ErrFirmwareNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "firmware not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update firmware metadata"})
+		return
+	}
+
+	h.respondFirmware(c, firmwareID)
+}
+
+// GetSBOM returns the SBOM document attached to firmware as it was uploaded.
+func (h *FirmwareHandler) GetSBOM(c *gin.Context) {
+	firmware, err := h.db.GetFirmwareByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "firmware not found"})
+		return
+	}
+
+	document, err := h.db.GetFirmwareSBOM(firmware.ID)
+	if errors.Is(err, database.ErrFirmwareSBOMNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "firmware has no SBOM"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get SBOM"})
+		return
+	}
+
+	contentType := "application/json"
+	if firmware.SBOMFormat != nil && sbomContentTypes[*firmware.SBOMFormat] != "" {
+		contentType = sbomContentTypes[*firmware.SBOMFormat]
+	}
+	c.Data(http.StatusOK, contentType, document)
+}
+
+// SearchComponents finds the firmware whose SBOM lists a component, so an
+// advisory against a library can be traced to the versions that ship it.
+func (h *FirmwareHandler) SearchComponents(c *gin.Context) {
+	name := strings.TrimSpace(c.Query("component"))
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "component is required"})
+		return
+	}
+
+	matches, err := h.db.SearchFirmwareComponents(name, strings.TrimSpace(c.Query("version")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search firmware components"})
+		return
+	}
+	if matches == nil {
+		matches = []database.ComponentMatch{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"matches": matches,
+		"total":   len(matches),
+	})
+}
@@ -1,33 +1,131 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/delta"
+	"github.com/10xdev4u-alt/aura/pkg/ota"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
 	"github.com/gin-gonic/gin"
 )
 
 type ReleaseHandler struct {
-	db *database.DB
+	db        *database.DB
+	canary    ota.CanaryPolicy
+	conflicts string
+	deltas    *delta.Builder
 }
 
-func NewReleaseHandler(db *database.DB) *ReleaseHandler {
-	return &ReleaseHandler{db: db}
+// NewReleaseHandler creates a handler that builds deltas for each new
+// release with deltas, or none if it is nil.
+func NewReleaseHandler(db *database.DB, canary ota.CanaryPolicy, conflicts string, deltas *delta.Builder) *ReleaseHandler {
+	return &ReleaseHandler{db: db, canary: canary, conflicts: conflicts, deltas: deltas}
 }
 
-func (h *ReleaseHandler) CreateRelease(c *gin.Context) {
-	var req struct {
-		FirmwareID   string `json:"firmware_id" binding:"required"`
-		TargetFleet  string `json:"target_fleet"`
-		HealthPolicy string `json:"health_policy"`
-	}
+type createReleaseRequest struct {
+	FirmwareID   string                `json:"firmware_id" binding:"required"`
+	TargetFleet  string                `json:"target_fleet"`
+	HealthPolicy string                `json:"health_policy"`
+	HealthGuards *rollout.HealthGuards `json:"health_guards"`
+	ScheduledAt  *time.Time            `json:"scheduled_at"`
+}
 
+func bindCreateRelease(c *gin.Context) (*createReleaseRequest, bool) {
+	var req createReleaseRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	if req.HealthGuards != nil {
+		if err := req.HealthGuards.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid health_guards: " + err.Error()})
+			return nil, false
+		}
+	}
+
+	return &req, true
+}
+
+// releasable responds with an error and returns false if the firmware has
+// been yanked or its image deleted.
+func releasable(c *gin.Context, firmware *database.Firmware) bool {
+	if firmware.State == database.FirmwareYanked {
+		message := "firmware " + firmware.Version + " has been yanked"
+		if firmware.StateReason != nil {
+			message += ": " + *firmware.StateReason
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": message})
+		return false
+	}
+	if firmware.PurgedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": database.ErrFirmwarePurged.Error()})
+		return false
+	}
+	return true
+}
+
+// findConflicts returns the open releases that target some of the same
+// devices as the firmware.
+func (h *ReleaseHandler) findConflicts(target *database.Firmware, firmwares []database.Firmware,
+	devices []database.Device) ([]ota.ReleaseConflict, error) {
+	releases, err := h.db.ListReleasesByStatus(rollout.OpenStatuses)
+	if err != nil {
+		return nil, err
+	}
+	return ota.FindConflicts(target, releases, firmwares, devices), nil
+}
+
+// CreateRelease refuses a release that targets devices of an open release
+// when conflicts are configured to be rejected. Otherwise the release is
+// created and the orchestrator starts it once the releases it overlaps have
+// finished.
+func (h *ReleaseHandler) CreateRelease(c *gin.Context) {
+	req, ok := bindCreateRelease(c)
+	if !ok {
+		return
+	}
+
+	target, err := h.db.GetFirmwareByID(req.FirmwareID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "firmware not found"})
+		return
+	}
+	if !releasable(c, target) {
+		return
+	}
+
+	firmwares, err := h.db.ListFirmware()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list firmware"})
+		return
+	}
+
+	devices, err := h.db.ListDevices()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list devices"})
 		return
 	}
 
-	releaseID, err := h.db.CreateRelease(req.FirmwareID, req.TargetFleet, req.HealthPolicy)
+	conflicts, err := h.findConflicts(target, firmwares, devices)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check for conflicting releases"})
+		return
+	}
+	if len(conflicts) > 0 && h.conflicts == ota.ConflictReject {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "release targets devices of an open release",
+			"conflicts": conflicts,
+		})
+		return
+	}
+
+	releaseID, err := h.db.CreateRelease(req.FirmwareID, req.TargetFleet, req.HealthPolicy, req.HealthGuards,
+		req.ScheduledAt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create release"})
 		return
@@ -39,9 +137,90 @@ func (h *ReleaseHandler) CreateRelease(c *gin.Context) {
 		return
 	}
 
+	if h.deltas != nil {
+		go h.buildDeltas(target, firmwares, devices)
+	}
+
+	if len(conflicts) > 0 {
+		c.JSON(http.StatusCreated, gin.H{"release": release, "queued_behind": conflicts})
+		return
+	}
 	c.JSON(http.StatusCreated, gin.H{"release": release})
 }
 
+// buildDeltas makes patches to the release's images from every version its
+// devices run. Devices are sent whatever has been built by the time they are
+// dispatched, and full images otherwise.
+func (h *ReleaseHandler) buildDeltas(target *database.Firmware, firmwares []database.Firmware,
+	devices []database.Device) {
+	for _, pair := range ota.DeltaPairs(target, ota.Catalog(firmwares), devices) {
+		built, err := h.deltas.Build(pair.Base, pair.Target)
+		switch {
+		case errors.Is(err, delta.ErrImageTooLarge), errors.Is(err, delta.ErrNoSavings):
+			log.Printf("Not using a delta from %s to %s: %v", pair.Base.Version, pair.Target.Version, err)
+		case err != nil:
+			log.Printf("Error building delta from %s to %s: %v", pair.Base.Version, pair.Target.Version, err)
+		default:
+			log.Printf("Delta from %s to %s is %d bytes, full image is %d", pair.Base.Version, pair.Target.Version,
+				built.FileSize, pair.Target.FileSize)
+		}
+	}
+}
+
+// DryRunRelease takes the body of CreateRelease and reports which devices
+// each wave would target, which devices would be excluded and why, and how
+// much firmware would be downloaded. Nothing is stored or sent.
+func (h *ReleaseHandler) DryRunRelease(c *gin.Context) {
+	req, ok := bindCreateRelease(c)
+	if !ok {
+		return
+	}
+
+	target, err := h.db.GetFirmwareByID(req.FirmwareID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "firmware not found"})
+		return
+	}
+	if !releasable(c, target) {
+		return
+	}
+
+	firmwares, err := h.db.ListFirmware()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list firmware"})
+		return
+	}
+
+	devices, err := h.db.ListDevices()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list devices"})
+		return
+	}
+
+	now := time.Now()
+	if req.ScheduledAt != nil && req.ScheduledAt.After(now) {
+		now = *req.ScheduledAt
+	}
+	preview := ota.PreviewRelease("", target, ota.Catalog(firmwares), devices, h.canary, now)
+
+	conflicts, err := h.findConflicts(target, firmwares, devices)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check for conflicting releases"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"firmware_id":    target.ID,
+		"version":        target.Version,
+		"scheduled_at":   req.ScheduledAt,
+		"waves":          preview.Waves,
+		"excluded":       preview.Excluded,
+		"target_devices": preview.TargetDevices,
+		"download_bytes": preview.DownloadBytes,
+		"conflicts":      conflicts,
+	})
+}
+
 func (h *ReleaseHandler) ListReleases(c *gin.Context) {
 	releases, err := h.db.ListReleases()
 	if err != nil {
@@ -67,30 +246,160 @@ func (h *ReleaseHandler) GetRelease(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"release": release})
 }
 
-func (h *ReleaseHandler) UpdateReleaseStatus(c *gin.Context) {
+// ReleaseAction returns a handler that applies one rollout action to the
+// release. The orchestrator picks up the new state on its next cycle.
+func (h *ReleaseHandler) ReleaseAction(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		releaseID := c.Param("id")
+
+		var req struct {
+			Actor  string `json:"actor" binding:"required"`
+			Reason string `json:"reason"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		release, err := h.db.ApplyReleaseAction(releaseID, action, req.Actor, req.Reason)
+		if errors.Is(err, database.ErrReleaseNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "release not found"})
+			return
+		}
+		if errors.Is(err, rollout.ErrIllegalTransition) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update release"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"release": release})
+	}
+}
+
+func (h *ReleaseHandler) ListReleaseDevices(c *gin.Context) {
 	releaseID := c.Param("id")
 
-	var req struct {
-		Status string `json:"status" binding:"required"`
-		Stage  string `json:"stage" binding:"required"`
+	if _, err := h.db.GetReleaseByID(releaseID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "release not found"})
+		return
+	}
+
+	devices, err := h.db.ListReleaseDevices(releaseID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list release devices"})
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	c.JSON(http.StatusOK, gin.H{
+		"devices": devices,
+		"total":   len(devices),
+	})
+}
+
+func (h *ReleaseHandler) ListReleaseEvents(c *gin.Context) {
+	releaseID := c.Param("id")
+
+	if _, err := h.db.GetReleaseByID(releaseID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "release not found"})
 		return
 	}
 
-	err := h.db.UpdateReleaseStatus(releaseID, req.Status, req.Stage)
+	events, err := h.db.ListReleaseEvents(releaseID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update release"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list release events"})
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  len(events),
+	})
+}
+
+type waveSchedule struct {
+	Wave             string     `json:"wave"`
+	State            string     `json:"state"`
+	ExpectedStart    *time.Time `json:"expected_start"`
+	Devices          int        `json:"devices"`
+	WaitingDevices   int        `json:"waiting_devices"`
+	NextDispatchAt   *time.Time `json:"next_dispatch_at"`
+	LastDispatchAt   *time.Time `json:"last_dispatch_at"`
+	AwaitingPrevious bool       `json:"awaiting_previous_wave"`
+}
+
+// GetReleaseSchedule reports when each wave of a release is expected to run.
+// Devices waiting for their maintenance window are counted along with the
+// earliest and latest time one of those windows opens.
+func (h *ReleaseHandler) GetReleaseSchedule(c *gin.Context) {
+	releaseID := c.Param("id")
+
 	release, err := h.db.GetReleaseByID(releaseID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve release"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "release not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"release": release})
+	releaseDevices, err := h.db.ListReleaseDevices(releaseID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list release devices"})
+		return
+	}
+
+	now := time.Now()
+	canary := &waveSchedule{Wave: rollout.StageCanary, State: "pending"}
+	production := &waveSchedule{Wave: rollout.StageProduction, State: "pending", AwaitingPrevious: true}
+	waves := map[string]*waveSchedule{rollout.StageCanary: canary, rollout.StageProduction: production}
+
+	for _, rd := range releaseDevices {
+		if rd.Wave == nil {
+			continue
+		}
+		wave, ok := waves[*rd.Wave]
+		if !ok {
+			continue
+		}
+		wave.Devices++
+		if rd.Status != rollout.DeviceScheduled || rd.NextAttemptAt == nil {
+			continue
+		}
+		wave.WaitingDevices++
+		at := *rd.NextAttemptAt
+		if wave.NextDispatchAt == nil || at.Before(*wave.NextDispatchAt) {
+			wave.NextDispatchAt = &at
+		}
+		if wave.LastDispatchAt == nil || at.After(*wave.LastDispatchAt) {
+			wave.LastDispatchAt = &at
+		}
+	}
+
+	switch release.Status {
+	case rollout.StatusPending:
+		start := now
+		if release.ScheduledAt != nil && release.ScheduledAt.After(now) {
+			start = *release.ScheduledAt
+		}
+		canary.ExpectedStart = &start
+	case rollout.StatusInProgress, rollout.StatusPaused:
+		canary.State = "started"
+		if release.Stage == rollout.StageProduction {
+			canary.State = "done"
+			production.State = "started"
+			production.AwaitingPrevious = false
+		}
+	default:
+		canary.State = "done"
+		production.State = "done"
+		production.AwaitingPrevious = false
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"release_id":   release.ID,
+		"status":       release.Status,
+		"scheduled_at": release.ScheduledAt,
+		"waves":        []*waveSchedule{canary, production},
+	})
 }
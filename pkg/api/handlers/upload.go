@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// uploadOffsetHeader carries the number of bytes received so far.
+	uploadOffsetHeader = "Upload-Offset"
+	uploadGCInterval   = 10 * time.Minute
+)
+
+type createUploadRequest struct {
+	Version            string   `json:"version" binding:"required"`
+	Description        string   `json:"description"`
+	HardwareModels     []string `json:"hardware_models"`
+	MinSourceVersion   string   `json:"min_source_version"`
+	RequiredBootloader string   `json:"required_bootloader"`
+	UpgradePath        []string `json:"upgrade_path"`
+	Size               *int64   `json:"size"`
+}
+
+type finalizeUploadRequest struct {
+	Checksum  string `json:"checksum" binding:"required"`
+	Signature string `json:"signature"`
+}
+
+// CreateUpload starts a resumable upload. The firmware metadata is checked
+// and fixed now, so a long upload is not wasted on a version that would be
+// rejected at the end.
+func (h *FirmwareHandler) CreateUpload(c *gin.Context) {
+	var req createUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	compat := database.FirmwareCompatibility{
+		HardwareModels:     req.HardwareModels,
+		MinSourceVersion:   strings.TrimSpace(req.MinSourceVersion),
+		RequiredBootloader: strings.TrimSpace(req.RequiredBootloader),
+		UpgradePath:        req.UpgradePath,
+	}
+	if err := validateCompatibility(compat, req.Version); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Size != nil && *req.Size < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must not be negative"})
+		return
+	}
+	if req.Size != nil && *req.Size > h.maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("firmware is larger than %d bytes", h.maxSize)})
+		return
+	}
+	if !h.versionAvailable(c, req.Version) {
+		return
+	}
+
+	if err := os.MkdirAll(h.uploadDir, 0700); err != nil {
+		log.Printf("Error creating upload directory: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload"})
+		return
+	}
+
+	sessionID, err := h.db.CreateUploadSession(req.Version, req.Description, compat, req.Size,
+		time.Now().Add(h.sessionTTL))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload"})
+		return
+	}
+
+	file, err := os.OpenFile(h.partPath(sessionID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Error creating upload file for session %s: %v", sessionID, err)
+		h.db.DeleteUploadSession(sessionID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload"})
+		return
+	}
+	file.Close()
+
+	session, err := h.db.GetUploadSession(sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve upload"})
+		return
+	}
+
+	c.Header(uploadOffsetHeader, "0")
+	c.JSON(http.StatusCreated, gin.H{"upload": uploadStatus(session, 0)})
+}
+
+// GetUpload reports how much of an upload has been received, so an
+// interrupted client knows where to resume.
+func (h *FirmwareHandler) GetUpload(c *gin.Context) {
+	session, unlock, ok := h.lockUploadSession(c)
+	if !ok {
+		return
+	}
+	defer unlock()
+	offset, err := h.uploadOffset(session.ID)
+	if err != nil {
+		log.Printf("Error reading upload %s: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read upload"})
+		return
+	}
+
+	c.Header(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+	c.JSON(http.StatusOK, gin.H{"upload": uploadStatus(session, offset)})
+}
+
+// UploadChunk appends the request body to an upload. The Upload-Offset
+// header must match the bytes already received; if it does not, nothing is
+// written and the current offset is returned with 409. Bytes received before
+// a dropped connection are kept.
+func (h *FirmwareHandler) UploadChunk(c *gin.Context) {
+	session, unlock, ok := h.lockUploadSession(c)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	offset, err := strconv.ParseInt(c.GetHeader(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": uploadOffsetHeader + " header is required"})
+		return
+	}
+
+	file, err := os.OpenFile(h.partPath(session.ID), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Error opening upload %s: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open upload"})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Printf("Error reading upload %s: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read upload"})
+		return
+	}
+	if offset != info.Size() {
+		c.Header(uploadOffsetHeader, strconv.FormatInt(info.Size(), 10))
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  fmt.Sprintf("upload is at offset %d", info.Size()),
+			"offset": info.Size(),
+		})
+		return
+	}
+
+	limit := h.maxSize
+	if session.Size != nil {
+		limit = *session.Size
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("Error seeking upload %s: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write upload"})
+		return
+	}
+	written, copyErr := io.Copy(file, io.LimitReader(c.Request.Body, limit-offset+1))
+	if offset+written > limit {
+		file.Truncate(offset)
+		c.Header(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("upload is larger than %d bytes", limit)})
+		return
+	}
+	if err := file.Sync(); err != nil {
+		log.Printf("Error syncing upload %s: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write upload"})
+		return
+	}
+	offset += written
+
+	if err := h.db.ExtendUploadSession(session.ID, time.Now().Add(h.sessionTTL)); err != nil {
+		log.Printf("Error extending upload session %s: %v", session.ID, err)
+	}
+	session.ExpiresAt = time.Now().Add(h.sessionTTL)
+
+	c.Header(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+	if copyErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "failed to read chunk: " + copyErr.Error(),
+			"offset": offset,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"upload": uploadStatus(session, offset)})
+}
+
+// FinalizeUpload checks the assembled image against the checksum the client
+// computed and creates the firmware from it. The firmware is saved from a
+// staged link to the image, so if anything fails the session and everything
+// received for it are kept, and the client can retry or cancel.
+func (h *FirmwareHandler) FinalizeUpload(c *gin.Context) {
+	session, unlock, ok := h.lockUploadSession(c)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	var req finalizeUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stagePath := h.partPath(session.ID) + ".stage"
+	if err := os.Remove(stagePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Error removing staged upload %s: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open upload"})
+		return
+	}
+	if err := os.Link(h.partPath(session.ID), stagePath); err != nil {
+		log.Printf("Error staging upload %s: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open upload"})
+		return
+	}
+	upload, err := storage.OpenUpload(stagePath, h.maxSize)
+	if err != nil {
+		os.Remove(stagePath)
+		if errors.Is(err, storage.ErrTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("firmware is larger than %d bytes", h.maxSize)})
+			return
+		}
+		log.Printf("Error opening upload %s: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open upload"})
+		return
+	}
+	defer upload.Discard()
+
+	if session.Size != nil && upload.Size() != *session.Size {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  fmt.Sprintf("upload is incomplete: received %d of %d bytes", upload.Size(), *session.Size),
+			"offset": upload.Size(),
+		})
+		return
+	}
+	if !strings.EqualFold(strings.TrimSpace(req.Checksum), upload.Checksum()) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":    "checksum does not match the uploaded image",
+			"checksum": upload.Checksum(),
+		})
+		return
+	}
+
+	var signatureData []byte
+	if req.Signature != "" {
+		signatureData = []byte(req.Signature)
+	}
+	description := ""
+	if session.Description != nil {
+		description = *session.Description
+	}
+
+	if !h.createFirmware(c, session.Version, description, session.Compatibility(), upload, signatureData, nil,
+		database.FirmwareMetadata{}) {
+		return
+	}
+	if err := os.Remove(h.partPath(session.ID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Error removing upload %s: %v", session.ID, err)
+	}
+	if err := h.db.DeleteUploadSession(session.ID); err != nil {
+		log.Printf("Error deleting upload session %s: %v", session.ID, err)
+	}
+	h.uploadLocks.Delete(session.ID)
+}
+
+// CancelUpload discards an upload and everything received for it.
+func (h *FirmwareHandler) CancelUpload(c *gin.Context) {
+	session, unlock, ok := h.lockUploadSession(c)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	if err := os.Remove(h.partPath(session.ID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Error removing upload %s: %v", session.ID, err)
+	}
+	if err := h.db.DeleteUploadSession(session.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel upload"})
+		return
+	}
+	h.uploadLocks.Delete(session.ID)
+
+	c.Status(http.StatusNoContent)
+}
+
+// CollectExpiredUploads periodically removes sessions that have received
+// nothing for the session TTL, along with their partial images.
+func (h *FirmwareHandler) CollectExpiredUploads() {
+	ticker := time.NewTicker(uploadGCInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.collectExpiredUploads()
+	}
+}
+
+func (h *FirmwareHandler) collectExpiredUploads() {
+	sessionIDs, err := h.db.ListExpiredUploadSessions(time.Now())
+	if err != nil {
+		log.Printf("Error listing expired uploads: %v", err)
+		return
+	}
+
+	for _, sessionID := range sessionIDs {
+		unlock := h.lockUpload(sessionID)
+		deleted, err := h.db.DeleteExpiredUploadSession(sessionID)
+		if err != nil {
+			log.Printf("Error deleting expired upload %s: %v", sessionID, err)
+		}
+		if deleted {
+			if err := os.Remove(h.partPath(sessionID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Error removing expired upload %s: %v", sessionID, err)
+			}
+			log.Printf("Removed expired upload %s", sessionID)
+		}
+		unlock()
+		if deleted {
+			h.uploadLocks.Delete(sessionID)
+		}
+	}
+}
+
+// lockUploadSession locks the session named in the request and returns it
+// with the function that unlocks it. It responds with an error and returns
+// false if the session does not exist or has expired, dropping the lock so
+// requests for unknown sessions do not accumulate.
+func (h *FirmwareHandler) lockUploadSession(c *gin.Context) (*database.UploadSession, func(), bool) {
+	sessionID := c.Param("id")
+	unlock := h.lockUpload(sessionID)
+	session, err := h.db.GetUploadSession(sessionID)
+	if err != nil {
+		unlock()
+		h.uploadLocks.Delete(sessionID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return nil, nil, false
+	}
+	return session, unlock, true
+}
+
+// lockUpload serializes requests for one session, so chunks cannot
+// interleave and an upload is not finalized while a chunk is written.
+func (h *FirmwareHandler) lockUpload(sessionID string) func() {
+	value, _ := h.uploadLocks.LoadOrStore(sessionID, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// partPath is where a session's image is assembled. Session IDs come from the
+// database, never straight from the request.
+func (h *FirmwareHandler) partPath(sessionID string) string {
+	return filepath.Join(h.uploadDir, "session-"+sessionID+".part")
+}
+
+func (h *FirmwareHandler) uploadOffset(sessionID string) (int64, error) {
+	info, err := os.Stat(h.partPath(sessionID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func uploadStatus(session *database.UploadSession, offset int64) gin.H {
+	return gin.H{
+		"id":         session.ID,
+		"version":    session.Version,
+		"offset":     offset,
+		"size":       session.Size,
+		"expires_at": session.ExpiresAt,
+	}
+}
@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
 
 type Device struct {
 	ID                string     `json:"id"`
@@ -9,12 +13,27 @@ type Device struct {
 	ClaimedAt         *time.Time `json:"claimed_at,omitempty"`
 	ProvisionedAt     *time.Time `json:"provisioned_at,omitempty"`
 	CertificateSerial *string    `json:"certificate_serial,omitempty"`
+	HardwareModel     *string    `json:"hardware_model,omitempty"`
+	Region            *string    `json:"region,omitempty"`
+	DeviceGroup       *string    `json:"device_group,omitempty"`
+	Timezone          *string    `json:"timezone,omitempty"`
+	FirmwareVersion   *string    `json:"firmware_version,omitempty"`
+	Status            *string    `json:"status,omitempty"`
+	BatteryLevel      *float64   `json:"battery_level,omitempty"`
+	Temperature       *float64   `json:"temperature,omitempty"`
+	UptimeSeconds     *int64     `json:"uptime_seconds,omitempty"`
+	LastSeenAt        *time.Time `json:"last_seen_at,omitempty"`
+	UpdateReleaseID   *string    `json:"update_release_id,omitempty"`
 	CreatedAt         time.Time  `json:"created_at"`
 	UpdatedAt         time.Time  `json:"updated_at"`
 }
 
 type CreateDeviceRequest struct {
 	BootstrapToken string `json:"bootstrap_token" binding:"required"`
+	HardwareModel  string `json:"hardware_model"`
+	Region         string `json:"region"`
+	DeviceGroup    string `json:"device_group"`
+	Timezone       string `json:"timezone"`
 }
 
 type CreateDeviceResponse struct {
@@ -43,12 +62,14 @@ type CreateFirmwareRequest struct {
 }
 
 type Release struct {
-	ID           string    `json:"id"`
-	FirmwareID   string    `json:"firmware_id"`
-	Status       string    `json:"status"`
-	Stage        string    `json:"stage"`
-	TargetFleet  string    `json:"target_fleet"`
-	HealthPolicy string    `json:"health_policy"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           string                `json:"id"`
+	FirmwareID   string                `json:"firmware_id"`
+	Status       string                `json:"status"`
+	Stage        string                `json:"stage"`
+	TargetFleet  string                `json:"target_fleet"`
+	HealthPolicy string                `json:"health_policy"`
+	HealthGuards *rollout.HealthGuards `json:"health_guards,omitempty"`
+	ScheduledAt  *time.Time            `json:"scheduled_at,omitempty"`
+	CreatedAt    time.Time             `json:"created_at"`
+	UpdatedAt    time.Time             `json:"updated_at"`
 }
@@ -0,0 +1,228 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/10xdev4u-alt/aura/pkg/storage"
+)
+
+// Contents is an unpacked bundle. Its artifact files wait in temporary files
+// until they are saved, and Discard removes whatever is left.
+type Contents struct {
+	Manifest *Manifest
+	// ManifestData is the manifest exactly as it was in the archive, which
+	// is what a build signature covers.
+	ManifestData []byte
+	// Files are the artifacts in install order.
+	Files []File
+}
+
+type File struct {
+	Artifact
+	Upload *storage.Upload
+}
+
+// Discard removes the temporary files of artifacts that were not saved.
+func (c *Contents) Discard() {
+	for _, file := range c.Files {
+		file.Upload.Discard()
+	}
+}
+
+// Unpack reads a bundle archive of size bytes, writing each artifact to a
+// temporary file in dir, and checks every artifact against the manifest.
+// The artifacts may total at most maxSize bytes.
+func Unpack(archive io.ReaderAt, size int64, dir string, maxSize int64) (*Contents, error) {
+	u := &unpacker{dir: dir, remaining: maxSize, uploads: make(map[string]*storage.Upload)}
+	if err := u.read(archive, size); err != nil {
+		u.discard()
+		return nil, err
+	}
+	contents, err := u.contents()
+	if err != nil {
+		u.discard()
+		return nil, err
+	}
+	return contents, nil
+}
+
+type unpacker struct {
+	dir       string
+	remaining int64
+	manifest  []byte
+	uploads   map[string]*storage.Upload
+}
+
+func (u *unpacker) read(archive io.ReaderAt, size int64) error {
+	header := make([]byte, 512)
+	n, err := archive.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		return u.readZip(archive, size)
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(io.NewSectionReader(archive, 0, size))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+		defer gz.Close()
+		return u.readTar(gz)
+	case len(header) > 262 && string(header[257:262]) == "ustar":
+		return u.readTar(io.NewSectionReader(archive, 0, size))
+	}
+	return fmt.Errorf("%w: not a tar, tar.gz or zip archive", ErrInvalidBundle)
+}
+
+func (u *unpacker) readTar(r io.Reader) error {
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeReg:
+			if err := u.add(header.Name, reader); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: %s is not a regular file", ErrInvalidBundle, header.Name)
+		}
+	}
+}
+
+func (u *unpacker) readZip(archive io.ReaderAt, size int64) error {
+	reader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			return fmt.Errorf("%w: %s is not a regular file", ErrInvalidBundle, file.Name)
+		}
+		data, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+		err = u.add(file.Name, data)
+		data.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add takes one file from the archive: the manifest is kept in memory and
+// anything else is written to a temporary file.
+func (u *unpacker) add(name string, data io.Reader) error {
+	name, err := cleanPath(name)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if u.uploads[name] != nil || (name == ManifestFile && u.manifest != nil) {
+		return fmt.Errorf("%w: %s appears more than once", ErrInvalidBundle, name)
+	}
+
+	if name == ManifestFile {
+		manifest, err := io.ReadAll(io.LimitReader(data, maxManifestSize+1))
+		if err != nil {
+			return fmt.Errorf("%w: failed to read %s: %v", ErrInvalidBundle, name, err)
+		}
+		if len(manifest) > maxManifestSize {
+			return fmt.Errorf("%w: %s is larger than %d bytes", ErrInvalidBundle, name, maxManifestSize)
+		}
+		u.manifest = manifest
+		return nil
+	}
+
+	upload, err := storage.NewUpload(u.dir, data, u.remaining)
+	if err != nil {
+		if errors.Is(err, storage.ErrTooLarge) {
+			return err
+		}
+		return fmt.Errorf("%w: failed to read %s: %v", ErrInvalidBundle, name, err)
+	}
+	u.uploads[name] = upload
+	u.remaining -= upload.Size()
+	return nil
+}
+
+// contents matches the unpacked files to the manifest's artifacts.
+func (u *unpacker) contents() (*Contents, error) {
+	if u.manifest == nil {
+		return nil, fmt.Errorf("%w: %s is missing", ErrInvalidBundle, ManifestFile)
+	}
+	manifest, err := ParseManifest(u.manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := &Contents{Manifest: manifest, ManifestData: u.manifest}
+	for _, artifact := range manifest.Artifacts {
+		upload := u.uploads[artifact.File]
+		if upload == nil {
+			return nil, fmt.Errorf("%w: artifact %s: %s is not in the archive", ErrInvalidBundle, artifact.Name,
+				artifact.File)
+		}
+		if upload.Size() != artifact.Size {
+			return nil, fmt.Errorf("%w: artifact %s is %d bytes, manifest says %d", ErrInvalidBundle, artifact.Name,
+				upload.Size(), artifact.Size)
+		}
+		if upload.Checksum() != strings.ToLower(artifact.SHA256) {
+			return nil, fmt.Errorf("%w: artifact %s does not match its sha256", ErrInvalidBundle, artifact.Name)
+		}
+		contents.Files = append(contents.Files, File{Artifact: artifact, Upload: upload})
+	}
+	if len(contents.Files) != len(u.uploads) {
+		for name := range u.uploads {
+			if !slices.ContainsFunc(manifest.Artifacts, func(a Artifact) bool { return a.File == name }) {
+				return nil, fmt.Errorf("%w: %s is not listed in %s", ErrInvalidBundle, name, ManifestFile)
+			}
+		}
+	}
+
+	slices.SortFunc(contents.Files, func(a, b File) int { return a.InstallOrder - b.InstallOrder })
+	return contents, nil
+}
+
+func (u *unpacker) discard() {
+	for _, upload := range u.uploads {
+		upload.Discard()
+	}
+}
+
+// cleanPath returns the name of an archive entry relative to the archive
+// root, rejecting names that could point outside it.
+func cleanPath(name string) (string, error) {
+	name = strings.TrimPrefix(name, "./")
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsAny(name, "\\\x00") {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	for _, element := range strings.Split(name, "/") {
+		if element == ".." {
+			return "", fmt.Errorf("invalid file name %q", name)
+		}
+	}
+	return path.Clean(name), nil
+}
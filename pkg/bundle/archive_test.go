@@ -0,0 +1,123 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type entry struct {
+	name string
+	data []byte
+}
+
+func tarArchive(t *testing.T, compress bool, entries []entry) []byte {
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	w := tar.NewWriter(&buf)
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		w = tar.NewWriter(gz)
+	}
+	for _, e := range entries {
+		if err := w.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, entries []entry) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entries {
+		f, err := w.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func manifestEntry(t *testing.T, files []entry) entry {
+	manifest := Manifest{FormatVersion: FormatVersion, Version: "2.0.0"}
+	for i, file := range files {
+		sum := sha256.Sum256(file.data)
+		manifest.Artifacts = append(manifest.Artifacts, Artifact{
+			Name:         "artifact" + string(rune('a'+i)),
+			File:         file.name,
+			InstallOrder: len(files) - i,
+			Size:         int64(len(file.data)),
+			SHA256:       hex.EncodeToString(sum[:]),
+		})
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry{ManifestFile, data}
+}
+
+func TestUnpack(t *testing.T) {
+	files := []entry{{"app.bin", []byte("application image")}, {"modem/fw.bin", []byte("modem firmware")}}
+	valid := append([]entry{manifestEntry(t, files)}, files...)
+
+	archives := map[string][]byte{
+		"tar":    tarArchive(t, false, valid),
+		"tar.gz": tarArchive(t, true, valid),
+		"zip":    zipArchive(t, valid),
+	}
+	for format, archive := range archives {
+		t.Run(format, func(t *testing.T) {
+			contents, err := Unpack(bytes.NewReader(archive), int64(len(archive)), t.TempDir(), 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer contents.Discard()
+			if len(contents.Files) != 2 || contents.Files[0].File != "modem/fw.bin" || contents.Files[1].File != "app.bin" {
+				t.Fatalf("unpacked %+v, want artifacts in install order", contents.Files)
+			}
+		})
+	}
+
+	tampered := append([]entry{manifestEntry(t, files)}, entry{"app.bin", []byte("tampered image!!!")}, files[1])
+	invalid := map[string][]entry{
+		"no manifest":      files,
+		"checksum":         tampered,
+		"unlisted file":    append(append([]entry{}, valid...), entry{"extra.bin", []byte("extra")}),
+		"path traversal":   {manifestEntry(t, []entry{{"../app.bin", nil}}), {"../app.bin", []byte("x")}},
+		"missing artifact": valid[:2],
+	}
+	for name, entries := range invalid {
+		t.Run(name, func(t *testing.T) {
+			archive := tarArchive(t, false, entries)
+			_, err := Unpack(bytes.NewReader(archive), int64(len(archive)), t.TempDir(), 1<<20)
+			if !errors.Is(err, ErrInvalidBundle) {
+				t.Errorf("got %v, want ErrInvalidBundle", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Package bundle reads firmware made of several artifacts, such as an
+// application, a bootloader, modem firmware and a filesystem image, uploaded
+// as one archive.
+//
+// A bundle is a tar (optionally gzip-compressed) or zip archive holding a
+// manifest.json at its root and the artifact files it names:
+//
+//	{
+//	  "format_version": 1,
+//	  "version": "2.1.0",
+//	  "description": "Modem and application update",
+//	  "hardware_models": ["aura-s2"],
+//	  "artifacts": [
+//	    {"name": "modem", "type": "modem", "file": "modem/fw.bin", "install_order": 1,
+//	     "size": 1048576, "sha256": "..."},
+//	    {"name": "app", "type": "application", "file": "app.bin", "install_order": 2,
+//	     "size": 524288, "sha256": "..."}
+//	  ]
+//	}
+//
+// The manifest pins every artifact by its SHA-256 checksum, so a signature
+// over the manifest covers the whole bundle. Every other file in the archive
+// must be an artifact.
+package bundle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ManifestFile is the name of the manifest in a bundle archive.
+const ManifestFile = "manifest.json"
+
+// FormatVersion is the manifest format this package reads.
+const FormatVersion = 1
+
+// maxManifestSize bounds the manifest, which only lists artifacts.
+const maxManifestSize = 1 << 20
+
+// ErrInvalidBundle is returned for archives and manifests that are not a
+// valid bundle.
+var ErrInvalidBundle = errors.New("invalid firmware bundle")
+
+var artifactName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+type Manifest struct {
+	FormatVersion      int        `json:"format_version"`
+	Version            string     `json:"version"`
+	Description        string     `json:"description,omitempty"`
+	HardwareModels     []string   `json:"hardware_models,omitempty"`
+	MinSourceVersion   string     `json:"min_source_version,omitempty"`
+	RequiredBootloader string     `json:"required_bootloader,omitempty"`
+	UpgradePath        []string   `json:"upgrade_path,omitempty"`
+	Artifacts          []Artifact `json:"artifacts"`
+}
+
+// Artifact is one image of a bundle. Artifacts are installed in ascending
+// InstallOrder.
+type Artifact struct {
+	Name         string `json:"name"`
+	Type         string `json:"type,omitempty"`
+	File         string `json:"file"`
+	InstallOrder int    `json:"install_order"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+}
+
+// ParseManifest decodes a manifest and checks that it is well formed. It
+// does not check the artifact files.
+func ParseManifest(data []byte) (*Manifest, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var manifest Manifest
+	if err := decoder.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse %s: %v", ErrInvalidBundle, ManifestFile, err)
+	}
+	if err := manifest.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	return &manifest, nil
+}
+
+func (m *Manifest) validate() error {
+	if m.FormatVersion != FormatVersion {
+		return fmt.Errorf("unsupported format_version %d", m.FormatVersion)
+	}
+	if m.Version == "" {
+		return errors.New("version is required")
+	}
+	if len(m.Artifacts) == 0 {
+		return errors.New("at least one artifact is required")
+	}
+
+	names := make(map[string]bool)
+	files := make(map[string]bool)
+	orders := make(map[int]bool)
+	for _, artifact := range m.Artifacts {
+		if !artifactName.MatchString(artifact.Name) {
+			return fmt.Errorf("invalid artifact name %q", artifact.Name)
+		}
+		if names[artifact.Name] {
+			return fmt.Errorf("duplicate artifact %q", artifact.Name)
+		}
+		names[artifact.Name] = true
+
+		file, err := cleanPath(artifact.File)
+		if err != nil {
+			return fmt.Errorf("artifact %s: %v", artifact.Name, err)
+		}
+		if file != artifact.File || file == ManifestFile {
+			return fmt.Errorf("artifact %s: invalid file %q", artifact.Name, artifact.File)
+		}
+		if files[file] {
+			return fmt.Errorf("artifact %s: file %s is used by another artifact", artifact.Name, file)
+		}
+		files[file] = true
+
+		if artifact.InstallOrder < 1 {
+			return fmt.Errorf("artifact %s: install_order must be positive", artifact.Name)
+		}
+		if orders[artifact.InstallOrder] {
+			return fmt.Errorf("artifact %s: install_order %d is used by another artifact", artifact.Name,
+				artifact.InstallOrder)
+		}
+		orders[artifact.InstallOrder] = true
+
+		if artifact.Size <= 0 {
+			return fmt.Errorf("artifact %s: size must be positive", artifact.Name)
+		}
+		if sum, err := hex.DecodeString(artifact.SHA256); err != nil || len(sum) != 32 {
+			return fmt.Errorf("artifact %s: sha256 must be 64 hex digits", artifact.Name)
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,6 +11,10 @@ import (
 type Config struct {
 	Server   ServerConfig   `yaml:"server"`
 	Database DatabaseConfig `yaml:"database"`
+	OTA      OTAConfig      `yaml:"ota"`
+	Signing  SigningConfig  `yaml:"signing"`
+	Download DownloadConfig `yaml:"download"`
+	Storage  StorageConfig  `yaml:"storage"`
 }
 
 type ServerConfig struct {
@@ -25,18 +30,155 @@ type DatabaseConfig struct {
 	SSLMode  string `yaml:"sslmode"`
 }
 
+type SigningConfig struct {
+	// CACertFile and CAKeyFile hold the CA that issues device certificates.
+	// The provisioning server generates them on first start.
+	CACertFile    string `yaml:"ca_cert_file"`
+	CAKeyFile     string `yaml:"ca_key_file"`
+	UpdateKeyFile string `yaml:"update_key_file"`
+	// BuildKeyFiles hold the PEM public keys of trusted build systems.
+	// Firmware uploads must be signed by one of them when any are set.
+	// Ed25519 keys must sign with Ed25519ph; pure Ed25519 signatures over the
+	// whole image are not supported.
+	BuildKeyFiles []string `yaml:"build_key_files"`
+}
+
+// DownloadConfig configures the firmware download server. Devices
+// authenticate with a signed URL or with the client certificate issued at
+// provisioning, in which case ClientCAFile must be the provisioning server's
+// signing.ca_cert_file.
+type DownloadConfig struct {
+	Port         string `yaml:"port"`
+	BaseURL      string `yaml:"base_url"`
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// URLKeys sign download URLs. The first key signs and all of them
+	// verify, so a retired key stays listed until its URLs have expired.
+	URLKeys []URLKeyConfig `yaml:"url_keys"`
+}
+
+type URLKeyConfig struct {
+	ID         string `yaml:"id"`
+	SecretFile string `yaml:"secret_file"`
+}
+
+// StorageConfig selects where firmware images are kept: "local" or "s3".
+// Uploads are staged in UploadDir, which should be on the same filesystem as
+// local storage so that saving an image is a rename. Resumable uploads
+// expire after UploadSessionTTL without a chunk. Images of deprecated and
+// yanked firmware that nothing has used for FirmwareRetention are deleted;
+// 0 keeps them.
+type StorageConfig struct {
+	Backend           string             `yaml:"backend"`
+	UploadDir         string             `yaml:"upload_dir"`
+	MaxFirmwareSize   int64              `yaml:"max_firmware_size"`
+	UploadSessionTTL  time.Duration      `yaml:"upload_session_ttl"`
+	FirmwareRetention time.Duration      `yaml:"firmware_retention"`
+	Local             LocalStorageConfig `yaml:"local"`
+	S3                S3StorageConfig    `yaml:"s3"`
+}
+
+type LocalStorageConfig struct {
+	Path string `yaml:"path"`
+}
+
+type S3StorageConfig struct {
+	Endpoint        string        `yaml:"endpoint"`
+	Region          string        `yaml:"region"`
+	Bucket          string        `yaml:"bucket"`
+	Prefix          string        `yaml:"prefix"`
+	AccessKeyID     string        `yaml:"access_key_id"`
+	SecretAccessKey string        `yaml:"secret_access_key"`
+	PresignTTL      time.Duration `yaml:"presign_ttl"`
+}
+
+type OTAConfig struct {
+	PollInterval        time.Duration  `yaml:"poll_interval"`
+	Canary              CanaryConfig   `yaml:"canary"`
+	Dispatch            DispatchConfig `yaml:"dispatch"`
+	Timeouts            TimeoutConfig  `yaml:"timeouts"`
+	Retry               RetryConfig    `yaml:"retry"`
+	Conflicts           string         `yaml:"conflicts"`
+	CommandTTL          time.Duration  `yaml:"command_ttl"`
+	ReplicaID           string         `yaml:"replica_id"`
+	LeaseTTL            time.Duration  `yaml:"lease_ttl"`
+	Delta               DeltaConfig    `yaml:"delta"`
+	YankedAlertInterval time.Duration  `yaml:"yanked_alert_interval"`
+}
+
+// DeltaConfig controls binary delta updates. When a release is created, a
+// patch is built from each firmware version its devices run, for images up
+// to MaxImageSize bytes, and kept if it is at least MinSavings smaller than
+// the full image.
+type DeltaConfig struct {
+	Enabled      bool    `yaml:"enabled"`
+	MaxImageSize int64   `yaml:"max_image_size"`
+	MinSavings   float64 `yaml:"min_savings"`
+}
+
+type CanaryConfig struct {
+	Size            int           `yaml:"size"`
+	DogfoodDevices  []string      `yaml:"dogfood_devices"`
+	MinBatteryLevel float64       `yaml:"min_battery_level"`
+	OfflineAfter    time.Duration `yaml:"offline_after"`
+}
+
+type DispatchConfig struct {
+	CommandsPerSecond     float64 `yaml:"commands_per_second"`
+	Burst                 int     `yaml:"burst"`
+	MaxInFlightPerRelease int     `yaml:"max_in_flight_per_release"`
+	MaxInFlight           int     `yaml:"max_in_flight"`
+	MaxDownloading        int     `yaml:"max_downloading"`
+}
+
+type TimeoutConfig struct {
+	Acknowledge time.Duration `yaml:"acknowledge"`
+	Download    time.Duration `yaml:"download"`
+	Install     time.Duration `yaml:"install"`
+	Reboot      time.Duration `yaml:"reboot"`
+}
+
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	cfg := DefaultConfig()
+	if err := yaml.Unmarshal(data, cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
-	return &cfg, nil
+	if cfg.OTA.Conflicts != "queue" && cfg.OTA.Conflicts != "reject" {
+		return nil, fmt.Errorf("invalid ota.conflicts %q: must be queue or reject", cfg.OTA.Conflicts)
+	}
+	if cfg.OTA.Delta.MinSavings < 0 || cfg.OTA.Delta.MinSavings >= 1 {
+		return nil, fmt.Errorf("invalid ota.delta.min_savings %v: must be at least 0 and less than 1", cfg.OTA.Delta.MinSavings)
+	}
+	if cfg.Storage.Backend != "local" && cfg.Storage.Backend != "s3" {
+		return nil, fmt.Errorf("invalid storage.backend %q: must be local or s3", cfg.Storage.Backend)
+	}
+	if cfg.Storage.MaxFirmwareSize <= 0 {
+		return nil, fmt.Errorf("invalid storage.max_firmware_size %d: must be positive", cfg.Storage.MaxFirmwareSize)
+	}
+	if cfg.OTA.LeaseTTL <= 0 {
+		return nil, fmt.Errorf("invalid ota.lease_ttl %s: must be positive", cfg.OTA.LeaseTTL)
+	}
+	if cfg.Storage.UploadSessionTTL <= 0 {
+		return nil, fmt.Errorf("invalid storage.upload_session_ttl %s: must be positive", cfg.Storage.UploadSessionTTL)
+	}
+	if cfg.Storage.FirmwareRetention < 0 {
+		return nil, fmt.Errorf("invalid storage.firmware_retention %s: must not be negative", cfg.Storage.FirmwareRetention)
+	}
+
+	return cfg, nil
 }
 
 func DefaultConfig() *Config {
@@ -52,5 +194,67 @@ func DefaultConfig() *Config {
 			DBName:   "aura",
 			SSLMode:  "disable",
 		},
+		OTA: OTAConfig{
+			PollInterval:        30 * time.Second,
+			LeaseTTL:            15 * time.Second,
+			Conflicts:           "queue",
+			CommandTTL:          time.Hour,
+			YankedAlertInterval: time.Hour,
+			Delta: DeltaConfig{
+				Enabled:      true,
+				MaxImageSize: 64 << 20,
+				MinSavings:   0.25,
+			},
+			Canary: CanaryConfig{
+				Size:            5,
+				MinBatteryLevel: 20,
+				OfflineAfter:    15 * time.Minute,
+			},
+			Dispatch: DispatchConfig{
+				CommandsPerSecond:     20,
+				Burst:                 20,
+				MaxInFlightPerRelease: 500,
+				MaxInFlight:           2000,
+				MaxDownloading:        1000,
+			},
+			Timeouts: TimeoutConfig{
+				Acknowledge: 2 * time.Minute,
+				Download:    30 * time.Minute,
+				Install:     15 * time.Minute,
+				Reboot:      10 * time.Minute,
+			},
+			Retry: RetryConfig{
+				MaxAttempts:    3,
+				InitialBackoff: time.Minute,
+				MaxBackoff:     30 * time.Minute,
+			},
+		},
+		Signing: SigningConfig{
+			CACertFile:    "./data/keys/ca.pem",
+			CAKeyFile:     "./data/keys/ca-key.pem",
+			UpdateKeyFile: "./data/keys/update-signing.pem",
+		},
+		Storage: StorageConfig{
+			Backend:           "local",
+			UploadDir:         "./data/firmware/.uploads",
+			MaxFirmwareSize:   512 << 20,
+			UploadSessionTTL:  24 * time.Hour,
+			FirmwareRetention: 30 * 24 * time.Hour,
+			Local: LocalStorageConfig{
+				Path: "./data/firmware",
+			},
+			S3: S3StorageConfig{
+				Region:     "us-east-1",
+				Bucket:     "aura-firmware",
+				PresignTTL: 15 * time.Minute,
+			},
+		},
+		Download: DownloadConfig{
+			Port:    "8443",
+			BaseURL: "https://firmware.aura.example.com",
+			URLKeys: []URLKeyConfig{
+				{ID: "default", SecretFile: "./data/keys/download-url-default.key"},
+			},
+		},
 	}
 }
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrFirmwareArtifactNotFound = errors.New("firmware artifact not found")
+
+// FirmwareArtifact is one image of a firmware bundle.
+type FirmwareArtifact struct {
+	FirmwareID   string
+	Name         string
+	Type         *string
+	InstallOrder int
+	BlobName     string
+	FilePath     string
+	FileSize     int64
+	Checksum     string
+}
+
+const firmwareArtifactColumns = `firmware_id, name, type, install_order, blob_name, file_path, file_size, checksum`
+
+func scanFirmwareArtifact(row rowScanner, artifact *FirmwareArtifact) error {
+	return row.Scan(
+		&artifact.FirmwareID, &artifact.Name, &artifact.Type, &artifact.InstallOrder, &artifact.BlobName,
+		&artifact.FilePath, &artifact.FileSize, &artifact.Checksum,
+	)
+}
+
+func (db *DB) GetFirmwareArtifact(firmwareID, name string) (*FirmwareArtifact, error) {
+	var artifact FirmwareArtifact
+	query := `SELECT ` + firmwareArtifactColumns + ` FROM firmware_artifacts WHERE firmware_id = $1 AND name = $2`
+	err := scanFirmwareArtifact(db.QueryRow(query, firmwareID, name), &artifact)
+	if err == sql.ErrNoRows {
+		return nil, ErrFirmwareArtifactNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get firmware artifact: %w", err)
+	}
+	return &artifact, nil
+}
+
+// ListFirmwareArtifacts returns the artifacts of a bundle in install order.
+func (db *DB) ListFirmwareArtifacts(firmwareID string) ([]FirmwareArtifact, error) {
+	query := `SELECT ` + firmwareArtifactColumns + ` FROM firmware_artifacts WHERE firmware_id = $1
+	          ORDER BY install_order`
+	rows, err := db.Query(query, firmwareID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firmware artifacts: %w", err)
+	}
+	defer rows.Close()
+
+	var artifacts []FirmwareArtifact
+	for rows.Next() {
+		var artifact FirmwareArtifact
+		if err := scanFirmwareArtifact(rows, &artifact); err != nil {
+			return nil, fmt.Errorf("failed to scan firmware artifact: %w", err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating firmware artifacts: %w", err)
+	}
+
+	return artifacts, nil
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -19,6 +20,7 @@ type Config struct {
 
 type DB struct {
 	*sql.DB
+	connStr string
 }
 
 func NewDatabase(cfg Config) (*DB, error) {
@@ -37,7 +39,7 @@ func NewDatabase(cfg Config) (*DB, error) {
 	}
 
 	log.Println("Database connection established")
-	return &DB{db}, nil
+	return &DB{DB: db, connStr: connStr}, nil
 }
 
 func (db *DB) InitSchema() error {
@@ -53,6 +55,19 @@ func (db *DB) InitSchema() error {
 		updated_at TIMESTAMPTZ DEFAULT NOW()
 	);
 
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS hardware_model TEXT;
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS region TEXT;
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS firmware_version TEXT;
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS status TEXT;
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS battery_level DOUBLE PRECISION;
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ;
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS temperature DOUBLE PRECISION;
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS uptime_seconds BIGINT;
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS bootloader_version TEXT;
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS device_group TEXT;
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS timezone TEXT;
+	ALTER TABLE devices ADD COLUMN IF NOT EXISTS update_release_id UUID;
+
 	CREATE INDEX IF NOT EXISTS idx_devices_bootstrap_token ON devices(bootstrap_token);
 	CREATE INDEX IF NOT EXISTS idx_devices_claimed_by_user ON devices(claimed_by_user_id);
 
@@ -75,6 +90,78 @@ func (db *DB) InitSchema() error {
 		updated_at TIMESTAMPTZ DEFAULT NOW()
 	);
 
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS hardware_models TEXT[];
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS min_source_version TEXT;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS required_bootloader TEXT;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS upgrade_path TEXT[];
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS signature BYTEA;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS signature_algorithm TEXT;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS signature_key_id TEXT;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS signature_bundle TEXT;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS blob_name TEXT;
+	UPDATE firmware SET blob_name = version WHERE blob_name IS NULL;
+	ALTER TABLE firmware ALTER COLUMN blob_name SET NOT NULL;
+	CREATE INDEX IF NOT EXISTS idx_firmware_blob_name ON firmware(blob_name);
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS state TEXT NOT NULL DEFAULT 'active';
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS state_reason TEXT;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS state_changed_by TEXT;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS state_changed_at TIMESTAMPTZ;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS purged_at TIMESTAMPTZ;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS bundle BOOLEAN NOT NULL DEFAULT FALSE;
+
+	CREATE TABLE IF NOT EXISTS firmware_artifacts (
+		firmware_id UUID NOT NULL REFERENCES firmware(id),
+		name TEXT NOT NULL,
+		type TEXT,
+		install_order INTEGER NOT NULL,
+		blob_name TEXT NOT NULL,
+		file_path TEXT NOT NULL,
+		file_size BIGINT NOT NULL,
+		checksum TEXT NOT NULL,
+		PRIMARY KEY (firmware_id, name),
+		UNIQUE (firmware_id, install_order)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_firmware_artifacts_blob_name ON firmware_artifacts(blob_name);
+
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS build_id TEXT;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS git_commit TEXT;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS build_timestamp TIMESTAMPTZ;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS target_hardware TEXT[];
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS changelog TEXT;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS sbom_format TEXT;
+	ALTER TABLE firmware ADD COLUMN IF NOT EXISTS sbom_spec_version TEXT;
+
+	CREATE TABLE IF NOT EXISTS firmware_sboms (
+		firmware_id UUID PRIMARY KEY REFERENCES firmware(id),
+		document TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS firmware_components (
+		firmware_id UUID NOT NULL REFERENCES firmware(id),
+		name TEXT NOT NULL,
+		version TEXT NOT NULL,
+		purl TEXT NOT NULL,
+		PRIMARY KEY (firmware_id, name, version, purl)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_firmware_components_name ON firmware_components(LOWER(name), version);
+
+	CREATE TABLE IF NOT EXISTS firmware_deltas (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		base_firmware_id UUID NOT NULL REFERENCES firmware(id),
+		target_firmware_id UUID NOT NULL REFERENCES firmware(id),
+		algorithm TEXT NOT NULL,
+		blob_name TEXT NOT NULL,
+		file_path TEXT NOT NULL,
+		file_size BIGINT NOT NULL,
+		checksum TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		UNIQUE (base_firmware_id, target_firmware_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_firmware_deltas_target ON firmware_deltas(target_firmware_id);
+
 	CREATE TABLE IF NOT EXISTS releases (
 		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 		firmware_id UUID NOT NULL REFERENCES firmware(id),
@@ -86,8 +173,94 @@ func (db *DB) InitSchema() error {
 		updated_at TIMESTAMPTZ DEFAULT NOW()
 	);
 
+	ALTER TABLE releases ADD COLUMN IF NOT EXISTS scheduled_at TIMESTAMPTZ;
+	ALTER TABLE releases ADD COLUMN IF NOT EXISTS health_guards JSONB;
+
 	CREATE INDEX IF NOT EXISTS idx_releases_firmware ON releases(firmware_id);
 	CREATE INDEX IF NOT EXISTS idx_releases_status ON releases(status);
+
+	CREATE TABLE IF NOT EXISTS release_devices (
+		release_id UUID NOT NULL REFERENCES releases(id),
+		device_id UUID NOT NULL REFERENCES devices(id),
+		firmware_id UUID REFERENCES firmware(id),
+		status TEXT NOT NULL,
+		reason TEXT,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		updated_at TIMESTAMPTZ DEFAULT NOW(),
+		PRIMARY KEY (release_id, device_id)
+	);
+
+	ALTER TABLE release_devices ADD COLUMN IF NOT EXISTS previous_version TEXT;
+	ALTER TABLE release_devices ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
+	ALTER TABLE release_devices ADD COLUMN IF NOT EXISTS wave TEXT;
+	ALTER TABLE release_devices ADD COLUMN IF NOT EXISTS command_id TEXT;
+	ALTER TABLE release_devices ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE release_devices ADD COLUMN IF NOT EXISTS timeout_at TIMESTAMPTZ;
+	ALTER TABLE release_devices ADD COLUMN IF NOT EXISTS baseline_battery DOUBLE PRECISION;
+	ALTER TABLE release_devices ADD COLUMN IF NOT EXISTS baseline_temperature DOUBLE PRECISION;
+	ALTER TABLE release_devices ADD COLUMN IF NOT EXISTS installed_at TIMESTAMPTZ;
+	ALTER TABLE release_devices ADD COLUMN IF NOT EXISTS delta_id UUID REFERENCES firmware_deltas(id);
+	ALTER TABLE release_devices ADD COLUMN IF NOT EXISTS full_image BOOLEAN NOT NULL DEFAULT FALSE;
+
+	CREATE INDEX IF NOT EXISTS idx_release_devices_device ON release_devices(device_id);
+
+	CREATE TABLE IF NOT EXISTS release_events (
+		id BIGSERIAL PRIMARY KEY,
+		release_id UUID NOT NULL REFERENCES releases(id),
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		reason TEXT,
+		from_status TEXT NOT NULL,
+		from_stage TEXT NOT NULL,
+		to_status TEXT NOT NULL,
+		to_stage TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_release_events_release ON release_events(release_id);
+
+	CREATE TABLE IF NOT EXISTS maintenance_windows (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		device_group TEXT,
+		days INTEGER[],
+		start_time TEXT NOT NULL,
+		duration_minutes INTEGER NOT NULL,
+		timezone TEXT,
+		created_at TIMESTAMPTZ DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS upload_sessions (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		version TEXT NOT NULL,
+		description TEXT,
+		hardware_models TEXT[],
+		min_source_version TEXT,
+		required_bootloader TEXT,
+		upgrade_path TEXT[],
+		size BIGINT,
+		expires_at TIMESTAMPTZ NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_upload_sessions_expires ON upload_sessions(expires_at);
+
+	CREATE TABLE IF NOT EXISTS leases (
+		name TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		acquired_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE OR REPLACE FUNCTION notify_release_change() RETURNS trigger AS $$
+	BEGIN
+		PERFORM pg_notify('release_changes', NEW.id::TEXT);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS releases_notify ON releases;
+	CREATE TRIGGER releases_notify AFTER INSERT OR UPDATE ON releases
+		FOR EACH ROW EXECUTE FUNCTION notify_release_change();
 	`
 
 	_, err := db.Exec(schema)
@@ -134,13 +307,8 @@ func (db *DB) MarkDeviceProvisioned(deviceID string) error {
 
 func (db *DB) GetDeviceByID(deviceID string) (*Device, error) {
 	var device Device
-	query := `SELECT id, bootstrap_token, claimed_by_user_id, claimed_at, provisioned_at, 
-	          certificate_serial, created_at, updated_at FROM devices WHERE id = $1`
-	err := db.QueryRow(query, deviceID).Scan(
-		&device.ID, &device.BootstrapToken, &device.ClaimedByUserID,
-		&device.ClaimedAt, &device.ProvisionedAt, &device.CertificateSerial,
-		&device.CreatedAt, &device.UpdatedAt,
-	)
+	query := `SELECT ` + deviceColumns + ` FROM devices WHERE id = $1`
+	err := scanDevice(db.QueryRow(query, deviceID), &device)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("device not found")
 	}
@@ -157,13 +325,44 @@ type Device struct {
 	ClaimedAt         *string
 	ProvisionedAt     *string
 	CertificateSerial *string
+	HardwareModel     *string
+	Region            *string
+	FirmwareVersion   *string
+	BootloaderVersion *string
+	DeviceGroup       *string
+	Timezone          *string
+	Status            *string
+	BatteryLevel      *float64
+	Temperature       *float64
+	UptimeSeconds     *int64
+	LastSeenAt        *time.Time
+	UpdateReleaseID   *string
 	CreatedAt         string
 	UpdatedAt         string
 }
 
+const deviceColumns = `id, bootstrap_token, claimed_by_user_id, claimed_at, provisioned_at,
+	certificate_serial, hardware_model, region, firmware_version, bootloader_version,
+	device_group, timezone, status, battery_level, temperature, uptime_seconds, last_seen_at,
+	update_release_id, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDevice(row rowScanner, device *Device) error {
+	return row.Scan(
+		&device.ID, &device.BootstrapToken, &device.ClaimedByUserID,
+		&device.ClaimedAt, &device.ProvisionedAt, &device.CertificateSerial,
+		&device.HardwareModel, &device.Region, &device.FirmwareVersion,
+		&device.BootloaderVersion, &device.DeviceGroup, &device.Timezone,
+		&device.Status, &device.BatteryLevel, &device.Temperature, &device.UptimeSeconds, &device.LastSeenAt,
+		&device.UpdateReleaseID, &device.CreatedAt, &device.UpdatedAt,
+	)
+}
+
 func (db *DB) ListDevices() ([]Device, error) {
-	query := `SELECT id, bootstrap_token, claimed_by_user_id, claimed_at, provisioned_at, 
-	          certificate_serial, created_at, updated_at FROM devices ORDER BY created_at DESC`
+	query := `SELECT ` + deviceColumns + ` FROM devices ORDER BY created_at DESC`
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list devices: %w", err)
@@ -173,12 +372,7 @@ func (db *DB) ListDevices() ([]Device, error) {
 	var devices []Device
 	for rows.Next() {
 		var device Device
-		err := rows.Scan(
-			&device.ID, &device.BootstrapToken, &device.ClaimedByUserID,
-			&device.ClaimedAt, &device.ProvisionedAt, &device.CertificateSerial,
-			&device.CreatedAt, &device.UpdatedAt,
-		)
-		if err != nil {
+		if err := scanDevice(rows, &device); err != nil {
 			return nil, fmt.Errorf("failed to scan device: %w", err)
 		}
 		devices = append(devices, device)
@@ -191,12 +385,50 @@ func (db *DB) ListDevices() ([]Device, error) {
 	return devices, nil
 }
 
-func (db *DB) CreateDeviceWithToken(token string) (string, error) {
+type DeviceAttributes struct {
+	HardwareModel string
+	Region        string
+	DeviceGroup   string
+	Timezone      string
+}
+
+func (db *DB) CreateDeviceWithToken(token string, attrs DeviceAttributes) (string, error) {
 	var deviceID string
-	query := `INSERT INTO devices (bootstrap_token) VALUES ($1) RETURNING id`
-	err := db.QueryRow(query, token).Scan(&deviceID)
+	query := `INSERT INTO devices (bootstrap_token, hardware_model, region, device_group, timezone)
+	          VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, '')) RETURNING id`
+	err := db.QueryRow(query, token, attrs.HardwareModel, attrs.Region, attrs.DeviceGroup, attrs.Timezone).Scan(&deviceID)
 	if err != nil {
 		return "", fmt.Errorf("failed to create device with token: %w", err)
 	}
 	return deviceID, nil
-}
\ No newline at end of file
+}
+
+// TelemetryUpdate is what a device reported. Empty and nil fields were not
+// reported and keep their stored values.
+type TelemetryUpdate struct {
+	FirmwareVersion   string
+	BootloaderVersion string
+	Status            string
+	BatteryLevel      *float64
+	Temperature       *float64
+	UptimeSeconds     *int64
+}
+
+func (db *DB) UpdateDeviceTelemetry(deviceID string, update TelemetryUpdate) error {
+	query := `UPDATE devices SET
+	            firmware_version = COALESCE(NULLIF($2, ''), firmware_version),
+	            bootloader_version = COALESCE(NULLIF($3, ''), bootloader_version),
+	            status = COALESCE(NULLIF($4, ''), status),
+	            battery_level = COALESCE($5, battery_level),
+	            temperature = COALESCE($6, temperature),
+	            uptime_seconds = COALESCE($7, uptime_seconds),
+	            last_seen_at = NOW(),
+	            updated_at = NOW()
+	          WHERE id = $1`
+	_, err := db.Exec(query, deviceID, update.FirmwareVersion, update.BootloaderVersion,
+		update.Status, update.BatteryLevel, update.Temperature, update.UptimeSeconds)
+	if err != nil {
+		return fmt.Errorf("failed to update device telemetry: %w", err)
+	}
+	return nil
+}
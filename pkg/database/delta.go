@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrFirmwareDeltaNotFound = errors.New("firmware delta not found")
+
+// FirmwareDelta is a patch that turns the base firmware image into the
+// target. BaseChecksum and TargetChecksum are the SHA-256 checksums of the
+// images before and after patching; Checksum is that of the patch itself.
+type FirmwareDelta struct {
+	ID               string
+	BaseFirmwareID   string
+	BaseVersion      string
+	BaseChecksum     string
+	TargetFirmwareID string
+	TargetVersion    string
+	TargetChecksum   string
+	Algorithm        string
+	BlobName         string
+	FilePath         string
+	FileSize         int64
+	Checksum         string
+	CreatedAt        string
+}
+
+const firmwareDeltaQuery = `SELECT d.id, d.base_firmware_id, b.version, b.checksum, d.target_firmware_id, t.version,
+	t.checksum, d.algorithm, d.blob_name, d.file_path, d.file_size, d.checksum, d.created_at
+	FROM firmware_deltas d
+	JOIN firmware b ON b.id = d.base_firmware_id
+	JOIN firmware t ON t.id = d.target_firmware_id`
+
+func scanFirmwareDelta(row rowScanner, delta *FirmwareDelta) error {
+	return row.Scan(
+		&delta.ID, &delta.BaseFirmwareID, &delta.BaseVersion, &delta.BaseChecksum, &delta.TargetFirmwareID,
+		&delta.TargetVersion, &delta.TargetChecksum, &delta.Algorithm, &delta.BlobName, &delta.FilePath,
+		&delta.FileSize, &delta.Checksum, &delta.CreatedAt,
+	)
+}
+
+// CreateFirmwareDelta records a patch. A patch already recorded for the same
+// pair of images is kept.
+func (db *DB) CreateFirmwareDelta(baseFirmwareID, targetFirmwareID, algorithm, blobName, filePath, checksum string,
+	fileSize int64) error {
+	query := `INSERT INTO firmware_deltas (base_firmware_id, target_firmware_id, algorithm, blob_name, file_path,
+	          file_size, checksum)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7)
+	          ON CONFLICT (base_firmware_id, target_firmware_id) DO NOTHING`
+	_, err := db.Exec(query, baseFirmwareID, targetFirmwareID, algorithm, blobName, filePath, fileSize, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to create firmware delta: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) GetFirmwareDeltaByID(deltaID string) (*FirmwareDelta, error) {
+	var delta FirmwareDelta
+	err := scanFirmwareDelta(db.QueryRow(firmwareDeltaQuery+` WHERE d.id = $1`, deltaID), &delta)
+	if err == sql.ErrNoRows {
+		return nil, ErrFirmwareDeltaNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get firmware delta: %w", err)
+	}
+	return &delta, nil
+}
+
+// GetFirmwareDelta returns the patch from the base to the target firmware.
+func (db *DB) GetFirmwareDelta(baseFirmwareID, targetFirmwareID string) (*FirmwareDelta, error) {
+	var delta FirmwareDelta
+	query := firmwareDeltaQuery + ` WHERE d.base_firmware_id = $1 AND d.target_firmware_id = $2`
+	err := scanFirmwareDelta(db.QueryRow(query, baseFirmwareID, targetFirmwareID), &delta)
+	if err == sql.ErrNoRows {
+		return nil, ErrFirmwareDeltaNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get firmware delta: %w", err)
+	}
+	return &delta, nil
+}
+
+// ListFirmwareDeltas returns the patches that produce the target firmware.
+func (db *DB) ListFirmwareDeltas(targetFirmwareID string) ([]FirmwareDelta, error) {
+	rows, err := db.Query(firmwareDeltaQuery+` WHERE d.target_firmware_id = $1 ORDER BY b.created_at DESC`,
+		targetFirmwareID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firmware deltas: %w", err)
+	}
+	defer rows.Close()
+
+	var deltas []FirmwareDelta
+	for rows.Next() {
+		var delta FirmwareDelta
+		if err := scanFirmwareDelta(rows, &delta); err != nil {
+			return nil, fmt.Errorf("failed to scan firmware delta: %w", err)
+		}
+		deltas = append(deltas, delta)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating firmware deltas: %w", err)
+	}
+
+	return deltas, nil
+}
@@ -1,16 +1,103 @@
 package database
 
-import "fmt"
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+	"github.com/lib/pq"
+)
+
+var ErrFirmwareNotFound = errors.New("firmware not found")
 
 type Firmware struct {
-	ID          string
-	Version     string
-	Description *string
-	FilePath    string
-	FileSize    int64
-	Checksum    string
-	CreatedAt   string
-	UpdatedAt   string
+	ID                 string
+	Version            string
+	Description        *string
+	BlobName           string
+	FilePath           string
+	FileSize           int64
+	Checksum           string
+	HardwareModels     []string
+	MinSourceVersion   *string
+	RequiredBootloader *string
+	UpgradePath        []string
+	Signature          []byte
+	SignatureAlgorithm *string
+	SignatureKeyID     *string
+	SignatureBundle    *string
+	State              string
+	StateReason        *string
+	StateChangedBy     *string
+	StateChangedAt     *time.Time
+	PurgedAt           *time.Time
+	Bundle             bool
+	BuildID            *string
+	GitCommit          *string
+	BuildTimestamp     *time.Time
+	TargetHardware     []string
+	Changelog          *string
+	SBOMFormat         *string
+	SBOMSpecVersion    *string
+	CreatedAt          string
+	UpdatedAt          string
+}
+
+// FirmwareSignature is the verified build signature of a firmware image. The
+// zero value records an unsigned image.
+type FirmwareSignature struct {
+	Signature []byte
+	Algorithm string
+	KeyID     string
+	Bundle    string
+}
+
+// BuildSignature returns the build signature the image was uploaded with.
+func (f *Firmware) BuildSignature() FirmwareSignature {
+	var signature FirmwareSignature
+	signature.Signature = f.Signature
+	if f.SignatureAlgorithm != nil {
+		signature.Algorithm = *f.SignatureAlgorithm
+	}
+	if f.SignatureKeyID != nil {
+		signature.KeyID = *f.SignatureKeyID
+	}
+	if f.SignatureBundle != nil {
+		signature.Bundle = *f.SignatureBundle
+	}
+	return signature
+}
+
+type FirmwareCompatibility struct {
+	HardwareModels     []string
+	MinSourceVersion   string
+	RequiredBootloader string
+	UpgradePath        []string
+}
+
+const firmwareColumns = `id, version, description, blob_name, file_path, file_size, checksum, hardware_models,
+	min_source_version, required_bootloader, upgrade_path, signature, signature_algorithm, signature_key_id,
+	signature_bundle, state, state_reason, state_changed_by, state_changed_at, purged_at, bundle, build_id,
+	git_commit, build_timestamp, target_hardware, changelog, sbom_format, sbom_spec_version, created_at, updated_at`
+
+func scanFirmware(row rowScanner, firmware *Firmware) error {
+	return row.Scan(
+		&firmware.ID, &firmware.Version, &firmware.Description, &firmware.BlobName, &firmware.FilePath,
+		&firmware.FileSize, &firmware.Checksum, pq.Array(&firmware.HardwareModels),
+		&firmware.MinSourceVersion, &firmware.RequiredBootloader, pq.Array(&firmware.UpgradePath),
+		&firmware.Signature, &firmware.SignatureAlgorithm, &firmware.SignatureKeyID, &firmware.SignatureBundle,
+		&firmware.State, &firmware.StateReason, &firmware.StateChangedBy, &firmware.StateChangedAt, &firmware.PurgedAt,
+		&firmware.Bundle, &firmware.BuildID, &firmware.GitCommit, &firmware.BuildTimestamp,
+		pq.Array(&firmware.TargetHardware), &firmware.Changelog, &firmware.SBOMFormat, &firmware.SBOMSpecVersion,
+		&firmware.CreatedAt, &firmware.UpdatedAt,
+	)
 }
 
 type Release struct {
@@ -20,29 +107,96 @@ type Release struct {
 	Stage        string
 	TargetFleet  *string
 	HealthPolicy *string
+	HealthGuards *rollout.HealthGuards
+	ScheduledAt  *time.Time
 	CreatedAt    string
 	UpdatedAt    string
 }
 
-func (db *DB) CreateFirmware(version, description, filePath, checksum string, fileSize int64) (string, error) {
+const releaseColumns = `id, firmware_id, status, stage, target_fleet, health_policy, health_guards, scheduled_at,
+	created_at, updated_at`
+
+func scanRelease(row rowScanner, release *Release) error {
+	var guards []byte
+	err := row.Scan(
+		&release.ID, &release.FirmwareID, &release.Status, &release.Stage,
+		&release.TargetFleet, &release.HealthPolicy, &guards, &release.ScheduledAt,
+		&release.CreatedAt, &release.UpdatedAt,
+	)
+	if err != nil || guards == nil {
+		return err
+	}
+	release.HealthGuards = &rollout.HealthGuards{}
+	if err := json.Unmarshal(guards, release.HealthGuards); err != nil {
+		return fmt.Errorf("invalid health guards: %w", err)
+	}
+	return nil
+}
+
+// CreateFirmware records firmware whose image is stored under blobName at
+// filePath, with the build metadata it was uploaded with. Firmware with
+// artifacts is a bundle, and its image is the bundle's manifest.
+func (db *DB) CreateFirmware(version, description, blobName, filePath, checksum string, fileSize int64,
+	compat FirmwareCompatibility, signature FirmwareSignature, artifacts []FirmwareArtifact,
+	metadata FirmwareMetadata) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	var firmwareID string
-	query := `INSERT INTO firmware (version, description, file_path, file_size, checksum) 
-	          VALUES ($1, $2, $3, $4, $5) RETURNING id`
-	err := db.QueryRow(query, version, description, filePath, fileSize, checksum).Scan(&firmwareID)
+	query := `INSERT INTO firmware (version, description, blob_name, file_path, file_size, checksum, hardware_models,
+	          min_source_version, required_bootloader, upgrade_path, signature, signature_algorithm, signature_key_id,
+	          signature_bundle, bundle)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), $10, NULLIF($11, ''::BYTEA), NULLIF($12, ''),
+	                  NULLIF($13, ''), NULLIF($14, ''), $15) RETURNING id`
+	err = tx.QueryRow(query, version, description, blobName, filePath, fileSize, checksum,
+		pq.Array(compat.HardwareModels), compat.MinSourceVersion, compat.RequiredBootloader,
+		pq.Array(compat.UpgradePath), signature.Signature, signature.Algorithm, signature.KeyID,
+		signature.Bundle, len(artifacts) > 0).Scan(&firmwareID)
 	if err != nil {
 		return "", fmt.Errorf("failed to create firmware: %w", err)
 	}
+
+	query = `INSERT INTO firmware_artifacts (firmware_id, name, type, install_order, blob_name, file_path, file_size,
+	         checksum)
+	         VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	for _, artifact := range artifacts {
+		_, err := tx.Exec(query, firmwareID, artifact.Name, artifact.Type, artifact.InstallOrder, artifact.BlobName,
+			artifact.FilePath, artifact.FileSize, artifact.Checksum)
+		if err != nil {
+			return "", fmt.Errorf("failed to create firmware artifact %s: %w", artifact.Name, err)
+		}
+	}
+
+	if err := setFirmwareMetadata(tx, firmwareID, metadata); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit firmware: %w", err)
+	}
 	return firmwareID, nil
 }
 
 func (db *DB) GetFirmwareByID(firmwareID string) (*Firmware, error) {
 	var firmware Firmware
-	query := `SELECT id, version, description, file_path, file_size, checksum, created_at, updated_at 
-	          FROM firmware WHERE id = $1`
-	err := db.QueryRow(query, firmwareID).Scan(
-		&firmware.ID, &firmware.Version, &firmware.Description, &firmware.FilePath,
-		&firmware.FileSize, &firmware.Checksum, &firmware.CreatedAt, &firmware.UpdatedAt,
-	)
+	query := `SELECT ` + firmwareColumns + ` FROM firmware WHERE id = $1`
+	err := scanFirmware(db.QueryRow(query, firmwareID), &firmware)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get firmware: %w", err)
+	}
+	return &firmware, nil
+}
+
+func (db *DB) GetFirmwareByVersion(version string) (*Firmware, error) {
+	var firmware Firmware
+	query := `SELECT ` + firmwareColumns + ` FROM firmware WHERE version = $1`
+	err := scanFirmware(db.QueryRow(query, version), &firmware)
+	if err == sql.ErrNoRows {
+		return nil, ErrFirmwareNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get firmware: %w", err)
 	}
@@ -50,8 +204,7 @@ func (db *DB) GetFirmwareByID(firmwareID string) (*Firmware, error) {
 }
 
 func (db *DB) ListFirmware() ([]Firmware, error) {
-	query := `SELECT id, version, description, file_path, file_size, checksum, created_at, updated_at 
-	          FROM firmware ORDER BY created_at DESC`
+	query := `SELECT ` + firmwareColumns + ` FROM firmware ORDER BY created_at DESC`
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list firmware: %w", err)
@@ -61,11 +214,7 @@ func (db *DB) ListFirmware() ([]Firmware, error) {
 	var firmwares []Firmware
 	for rows.Next() {
 		var firmware Firmware
-		err := rows.Scan(
-			&firmware.ID, &firmware.Version, &firmware.Description, &firmware.FilePath,
-			&firmware.FileSize, &firmware.Checksum, &firmware.CreatedAt, &firmware.UpdatedAt,
-		)
-		if err != nil {
+		if err := scanFirmware(rows, &firmware); err != nil {
 			return nil, fmt.Errorf("failed to scan firmware: %w", err)
 		}
 		firmwares = append(firmwares, firmware)
@@ -73,11 +222,112 @@ func (db *DB) ListFirmware() ([]Firmware, error) {
 	return firmwares, nil
 }
 
-func (db *DB) CreateRelease(firmwareID, targetFleet, healthPolicy string) (string, error) {
+// FirmwareBlobReferenced reports whether any firmware or bundle artifact that
+// has not been purged, or any delta, uses the stored image.
+func (db *DB) FirmwareBlobReferenced(blobName string) (bool, error) {
+	return firmwareBlobReferenced(db.QueryRow(firmwareBlobReferencedQuery, blobName))
+}
+
+const firmwareBlobReferencedQuery = `SELECT EXISTS (SELECT 1 FROM firmware WHERE blob_name = $1 AND purged_at IS NULL)
+	OR EXISTS (SELECT 1 FROM firmware_artifacts a JOIN firmware f ON f.id = a.firmware_id
+	           WHERE a.blob_name = $1 AND f.purged_at IS NULL)
+	OR EXISTS (SELECT 1 FROM firmware_deltas WHERE blob_name = $1)`
+
+func firmwareBlobReferenced(row rowScanner) (bool, error) {
+	var referenced bool
+	if err := row.Scan(&referenced); err != nil {
+		return false, fmt.Errorf("failed to check firmware blob: %w", err)
+	}
+	return referenced, nil
+}
+
+// LockFirmwareBlobs locks each named stored image until unlock is called.
+// Holding the locks from saving images until the rows that use them are
+// recorded keeps DeleteFirmwareBlob from removing an image that an upload
+// was deduplicated against. The locks belong to a connection of their own,
+// so unlock must be called before deleting any of the images.
+func (db *DB) LockFirmwareBlobs(blobNames []string) (unlock func(), err error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock firmware blobs: %w", err)
+	}
+	unlock = func() {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock_all()`); err != nil {
+			// Closing the session is the only other way to drop its locks.
+			log.Printf("Error unlocking firmware blobs: %v", err)
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}
+
+	// Locking in a fixed order keeps two uploads that share images from
+	// deadlocking.
+	names := slices.Clone(blobNames)
+	slices.Sort(names)
+	for _, name := range slices.Compact(names) {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1))`, name); err != nil {
+			unlock()
+			return nil, fmt.Errorf("failed to lock firmware blob %s: %w", name, err)
+		}
+	}
+	return unlock, nil
+}
+
+// DeleteFirmwareBlob calls remove to delete a stored image if nothing uses
+// it, as reported by FirmwareBlobReferenced. The image's lock is held across
+// the check and the removal, so neither can interleave with an upload
+// holding LockFirmwareBlobs. It reports whether the image was removed.
+func (db *DB) DeleteFirmwareBlob(blobName string, remove func() error) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, blobName); err != nil {
+		return false, fmt.Errorf("failed to lock firmware blob: %w", err)
+	}
+	referenced, err := firmwareBlobReferenced(tx.QueryRow(firmwareBlobReferencedQuery, blobName))
+	if err != nil || referenced {
+		return false, err
+	}
+	if err := remove(); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit firmware blob deletion: %w", err)
+	}
+	return true, nil
+}
+
+// SetFirmwareBlob points firmware at an image stored under a new name.
+func (db *DB) SetFirmwareBlob(firmwareID, blobName, filePath string) error {
+	_, err := db.Exec(`UPDATE firmware SET blob_name = $2, file_path = $3, updated_at = NOW() WHERE id = $1`,
+		firmwareID, blobName, filePath)
+	if err != nil {
+		return fmt.Errorf("failed to update firmware blob: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) CreateRelease(firmwareID, targetFleet, healthPolicy string, guards *rollout.HealthGuards,
+	scheduledAt *time.Time) (string, error) {
+	var guardsJSON *string
+	if guards != nil {
+		encoded, err := json.Marshal(guards)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode health guards: %w", err)
+		}
+		value := string(encoded)
+		guardsJSON = &value
+	}
+
 	var releaseID string
-	query := `INSERT INTO releases (firmware_id, target_fleet, health_policy) 
-	          VALUES ($1, $2, $3) RETURNING id`
-	err := db.QueryRow(query, firmwareID, targetFleet, healthPolicy).Scan(&releaseID)
+	query := `INSERT INTO releases (firmware_id, target_fleet, health_policy, health_guards, scheduled_at)
+	          VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err := db.QueryRow(query, firmwareID, targetFleet, healthPolicy, guardsJSON, scheduledAt).Scan(&releaseID)
 	if err != nil {
 		return "", fmt.Errorf("failed to create release: %w", err)
 	}
@@ -86,31 +336,28 @@ func (db *DB) CreateRelease(firmwareID, targetFleet, healthPolicy string) (strin
 
 func (db *DB) GetReleaseByID(releaseID string) (*Release, error) {
 	var release Release
-	query := `SELECT id, firmware_id, status, stage, target_fleet, health_policy, created_at, updated_at 
-	          FROM releases WHERE id = $1`
-	err := db.QueryRow(query, releaseID).Scan(
-		&release.ID, &release.FirmwareID, &release.Status, &release.Stage,
-		&release.TargetFleet, &release.HealthPolicy, &release.CreatedAt, &release.UpdatedAt,
-	)
+	query := `SELECT ` + releaseColumns + ` FROM releases WHERE id = $1`
+	err := scanRelease(db.QueryRow(query, releaseID), &release)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get release: %w", err)
 	}
 	return &release, nil
 }
 
-func (db *DB) UpdateReleaseStatus(releaseID, status, stage string) error {
-	query := `UPDATE releases SET status = $1, stage = $2, updated_at = NOW() WHERE id = $3`
-	_, err := db.Exec(query, status, stage, releaseID)
-	if err != nil {
-		return fmt.Errorf("failed to update release status: %w", err)
-	}
-	return nil
+func (db *DB) ListReleases() ([]Release, error) {
+	query := `SELECT ` + releaseColumns + ` FROM releases ORDER BY created_at DESC`
+	return db.queryReleases(query)
 }
 
-func (db *DB) ListReleases() ([]Release, error) {
-	query := `SELECT id, firmware_id, status, stage, target_fleet, health_policy, created_at, updated_at 
-	          FROM releases ORDER BY created_at DESC`
-	rows, err := db.Query(query)
+// ListReleasesByStatus returns releases in any of the given statuses, oldest
+// first.
+func (db *DB) ListReleasesByStatus(statuses []string) ([]Release, error) {
+	query := `SELECT ` + releaseColumns + ` FROM releases WHERE status = ANY($1) ORDER BY created_at`
+	return db.queryReleases(query, pq.Array(statuses))
+}
+
+func (db *DB) queryReleases(query string, args ...any) ([]Release, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list releases: %w", err)
 	}
@@ -119,11 +366,7 @@ func (db *DB) ListReleases() ([]Release, error) {
 	var releases []Release
 	for rows.Next() {
 		var release Release
-		err := rows.Scan(
-			&release.ID, &release.FirmwareID, &release.Status, &release.Stage,
-			&release.TargetFleet, &release.HealthPolicy, &release.CreatedAt, &release.UpdatedAt,
-		)
-		if err != nil {
+		if err := scanRelease(rows, &release); err != nil {
 			return nil, fmt.Errorf("failed to scan release: %w", err)
 		}
 		releases = append(releases, release)
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type Lease struct {
+	Name       string
+	Holder     string
+	AcquiredAt time.Time
+	ExpiresAt  time.Time
+}
+
+// AcquireLease takes or renews the named lease for holder. It succeeds only
+// if the lease is free, expired, or already held by holder.
+func (db *DB) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	query := `INSERT INTO leases (name, holder, expires_at)
+	          VALUES ($1, $2, NOW() + $3 * INTERVAL '1 millisecond')
+	          ON CONFLICT (name) DO UPDATE SET
+	            holder = EXCLUDED.holder,
+	            expires_at = EXCLUDED.expires_at,
+	            acquired_at = CASE WHEN leases.holder = EXCLUDED.holder THEN leases.acquired_at ELSE NOW() END
+	          WHERE leases.holder = EXCLUDED.holder OR leases.expires_at < NOW()
+	          RETURNING holder`
+	var current string
+	err := db.QueryRow(query, name, holder, ttl.Milliseconds()).Scan(&current)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	return current == holder, nil
+}
+
+func (db *DB) ReleaseLease(name, holder string) error {
+	query := `DELETE FROM leases WHERE name = $1 AND holder = $2`
+	if _, err := db.Exec(query, name, holder); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) GetLease(name string) (*Lease, error) {
+	var lease Lease
+	query := `SELECT name, holder, acquired_at, expires_at FROM leases WHERE name = $1 AND expires_at > NOW()`
+	err := db.QueryRow(query, name).Scan(&lease.Name, &lease.Holder, &lease.AcquiredAt, &lease.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lease: %w", err)
+	}
+	return &lease, nil
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+	"github.com/lib/pq"
+)
+
+// Firmware states. Deprecated firmware can still be released; yanked
+// firmware cannot, and devices still running it raise alerts.
+const (
+	FirmwareActive     = "active"
+	FirmwareDeprecated = "deprecated"
+	FirmwareYanked     = "yanked"
+)
+
+const (
+	FirmwareActionDeprecate = "deprecate"
+	FirmwareActionYank      = "yank"
+	FirmwareActionRestore   = "restore"
+)
+
+var (
+	ErrIllegalFirmwareTransition = errors.New("illegal firmware transition")
+	// ErrFirmwarePurged is returned for firmware whose image has been
+	// garbage-collected.
+	ErrFirmwarePurged = errors.New("firmware image has been deleted")
+)
+
+// FirmwareTransition returns the state firmware moves to when the action is
+// applied, or ErrIllegalFirmwareTransition if the action is not allowed from
+// the current state.
+func FirmwareTransition(state, action string) (string, error) {
+	switch {
+	case action == FirmwareActionDeprecate && state == FirmwareActive:
+		return FirmwareDeprecated, nil
+	case action == FirmwareActionYank && (state == FirmwareActive || state == FirmwareDeprecated):
+		return FirmwareYanked, nil
+	case action == FirmwareActionRestore && (state == FirmwareDeprecated || state == FirmwareYanked):
+		return FirmwareActive, nil
+	}
+	return "", fmt.Errorf("%w: cannot %s firmware that is %s", ErrIllegalFirmwareTransition, action, state)
+}
+
+// ApplyFirmwareAction moves firmware to the state the action leads to and
+// records who moved it and why. Purged firmware cannot be restored.
+func (db *DB) ApplyFirmwareAction(firmwareID, action, actor, reason string) (*Firmware, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var state string
+	var purgedAt *time.Time
+	err = tx.QueryRow(`SELECT state, purged_at FROM firmware WHERE id = $1 FOR UPDATE`, firmwareID).Scan(&state, &purgedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrFirmwareNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get firmware: %w", err)
+	}
+
+	next, err := FirmwareTransition(state, action)
+	if err != nil {
+		return nil, err
+	}
+	if next == FirmwareActive && purgedAt != nil {
+		return nil, fmt.Errorf("%w: %w", ErrIllegalFirmwareTransition, ErrFirmwarePurged)
+	}
+
+	query := `UPDATE firmware SET state = $2, state_reason = NULLIF($3, ''), state_changed_by = $4,
+	          state_changed_at = NOW(), updated_at = NOW()
+	          WHERE id = $1`
+	if _, err := tx.Exec(query, firmwareID, next, reason, actor); err != nil {
+		return nil, fmt.Errorf("failed to update firmware state: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit firmware action: %w", err)
+	}
+
+	return db.GetFirmwareByID(firmwareID)
+}
+
+// collectableFirmware selects deprecated and yanked firmware f whose image
+// can be deleted: it has been in that state since before the cutoff $1, no
+// device reports running it, and no open release ($3), nor any release
+// updated since the cutoff, sends it, steps through it or could roll back to
+// it. $2 is the active state.
+const collectableFirmware = `f.state <> $2 AND f.purged_at IS NULL AND f.state_changed_at < $1
+	AND NOT EXISTS (SELECT 1 FROM devices d WHERE d.firmware_version = f.version)
+	AND NOT EXISTS (
+	  SELECT 1 FROM releases r
+	  WHERE (r.status = ANY($3) OR r.updated_at >= $1)
+	    AND (r.firmware_id = f.id
+	      OR EXISTS (SELECT 1 FROM firmware t WHERE t.id = r.firmware_id AND f.version = ANY(t.upgrade_path))
+	      OR EXISTS (SELECT 1 FROM release_devices rd WHERE rd.release_id = r.id
+	                 AND (rd.firmware_id = f.id OR rd.previous_version = f.version))))`
+
+// ListCollectableFirmware returns the firmware whose image can be deleted
+// given the cutoff, oldest state change first.
+func (db *DB) ListCollectableFirmware(cutoff time.Time) ([]Firmware, error) {
+	query := `SELECT ` + firmwareColumns + ` FROM firmware f WHERE ` + collectableFirmware + `
+	          ORDER BY f.state_changed_at`
+	rows, err := db.Query(query, cutoff, FirmwareActive, pq.Array(rollout.OpenStatuses))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collectable firmware: %w", err)
+	}
+	defer rows.Close()
+
+	var firmwares []Firmware
+	for rows.Next() {
+		var firmware Firmware
+		if err := scanFirmware(rows, &firmware); err != nil {
+			return nil, fmt.Errorf("failed to scan firmware: %w", err)
+		}
+		firmwares = append(firmwares, firmware)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating firmware: %w", err)
+	}
+
+	return firmwares, nil
+}
+
+// PurgeFirmware marks firmware as having no stored image and deletes the
+// deltas to and from it, if it is still collectable given the cutoff. It
+// reports whether the firmware was purged and returns the blob names of
+// those deltas and of its bundle artifacts, which may now be unreferenced.
+// The firmware row is kept for the releases that refer to it.
+func (db *DB) PurgeFirmware(firmwareID string, cutoff time.Time) (bool, []string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE firmware f SET purged_at = NOW(), updated_at = NOW()
+	          WHERE f.id = $4 AND ` + collectableFirmware
+	result, err := tx.Exec(query, cutoff, FirmwareActive, pq.Array(rollout.OpenStatuses), firmwareID)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to purge firmware: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to purge firmware: %w", err)
+	}
+	if purged == 0 {
+		return false, nil, nil
+	}
+
+	query = `UPDATE release_devices SET delta_id = NULL
+	          WHERE delta_id IN (SELECT id FROM firmware_deltas WHERE base_firmware_id = $1 OR target_firmware_id = $1)`
+	if _, err := tx.Exec(query, firmwareID); err != nil {
+		return false, nil, fmt.Errorf("failed to detach firmware deltas: %w", err)
+	}
+
+	query = `WITH deleted AS (
+	           DELETE FROM firmware_deltas WHERE base_firmware_id = $1 OR target_firmware_id = $1 RETURNING blob_name)
+	         SELECT blob_name FROM deleted
+	         UNION ALL
+	         SELECT blob_name FROM firmware_artifacts WHERE firmware_id = $1`
+	rows, err := tx.Query(query, firmwareID)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to delete firmware deltas: %w", err)
+	}
+	var blobNames []string
+	for rows.Next() {
+		var blobName string
+		if err := rows.Scan(&blobName); err != nil {
+			rows.Close()
+			return false, nil, fmt.Errorf("failed to scan firmware delta: %w", err)
+		}
+		blobNames = append(blobNames, blobName)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, nil, fmt.Errorf("error iterating firmware deltas: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, nil, fmt.Errorf("failed to commit firmware purge: %w", err)
+	}
+	return true, blobNames, nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+	"github.com/lib/pq"
+)
+
+type MaintenanceWindow struct {
+	ID              string
+	DeviceGroup     *string
+	Days            []int64
+	StartTime       string
+	DurationMinutes int
+	Timezone        *string
+	CreatedAt       string
+}
+
+func (w MaintenanceWindow) Window() (rollout.Window, error) {
+	group, timezone := "", ""
+	if w.DeviceGroup != nil {
+		group = *w.DeviceGroup
+	}
+	if w.Timezone != nil {
+		timezone = *w.Timezone
+	}
+	return rollout.ParseWindow(group, w.Days, w.StartTime, w.DurationMinutes, timezone)
+}
+
+func (db *DB) CreateMaintenanceWindow(deviceGroup string, days []int64, startTime string, durationMinutes int, timezone string) (string, error) {
+	var windowID string
+	query := `INSERT INTO maintenance_windows (device_group, days, start_time, duration_minutes, timezone)
+	          VALUES (NULLIF($1, ''), $2, $3, $4, NULLIF($5, '')) RETURNING id`
+	err := db.QueryRow(query, deviceGroup, pq.Array(days), startTime, durationMinutes, timezone).Scan(&windowID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create maintenance window: %w", err)
+	}
+	return windowID, nil
+}
+
+func (db *DB) ListMaintenanceWindows() ([]MaintenanceWindow, error) {
+	query := `SELECT id, device_group, days, start_time, duration_minutes, timezone, created_at
+	          FROM maintenance_windows ORDER BY created_at`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list maintenance windows: %w", err)
+	}
+	defer rows.Close()
+
+	var windows []MaintenanceWindow
+	for rows.Next() {
+		var window MaintenanceWindow
+		err := rows.Scan(
+			&window.ID, &window.DeviceGroup, pq.Array(&window.Days), &window.StartTime,
+			&window.DurationMinutes, &window.Timezone, &window.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan maintenance window: %w", err)
+		}
+		windows = append(windows, window)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating maintenance windows: %w", err)
+	}
+
+	return windows, nil
+}
+
+func (db *DB) DeleteMaintenanceWindow(windowID string) (bool, error) {
+	result, err := db.Exec(`DELETE FROM maintenance_windows WHERE id = $1`, windowID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete maintenance window: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete maintenance window: %w", err)
+	}
+	return affected > 0, nil
+}
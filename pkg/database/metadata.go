@@ -0,0 +1,206 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrFirmwareSBOMNotFound = errors.New("firmware has no SBOM")
+
+// FirmwareMetadata describes how an image was built and what it contains.
+// Empty fields are not recorded.
+type FirmwareMetadata struct {
+	BuildID        string
+	GitCommit      string
+	BuildTimestamp *time.Time
+	TargetHardware []string
+	Changelog      string
+	SBOM           *FirmwareSBOM
+}
+
+// FirmwareSBOM is an SBOM document as it was uploaded, with the components
+// extracted from it.
+type FirmwareSBOM struct {
+	Format      string
+	SpecVersion string
+	Document    []byte
+	Components  []FirmwareComponent
+}
+
+// FirmwareComponent is software listed in a firmware's SBOM. Version and
+// PURL are empty when the SBOM does not give them.
+type FirmwareComponent struct {
+	FirmwareID string
+	Name       string
+	Version    string
+	PURL       string
+}
+
+// ComponentMatch is firmware containing the components a search matched.
+type ComponentMatch struct {
+	Firmware   Firmware
+	Components []FirmwareComponent
+}
+
+// SetFirmwareMetadata replaces the build metadata and SBOM of firmware.
+func (db *DB) SetFirmwareMetadata(firmwareID string, metadata FirmwareMetadata) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := setFirmwareMetadata(tx, firmwareID, metadata); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit firmware metadata: %w", err)
+	}
+	return nil
+}
+
+func setFirmwareMetadata(tx *sql.Tx, firmwareID string, metadata FirmwareMetadata) error {
+	var sbomFormat, sbomSpecVersion string
+	if metadata.SBOM != nil {
+		sbomFormat, sbomSpecVersion = metadata.SBOM.Format, metadata.SBOM.SpecVersion
+	}
+
+	query := `UPDATE firmware SET build_id = NULLIF($2, ''), git_commit = NULLIF($3, ''), build_timestamp = $4,
+	          target_hardware = $5, changelog = NULLIF($6, ''), sbom_format = NULLIF($7, ''),
+	          sbom_spec_version = NULLIF($8, ''), updated_at = NOW()
+	          WHERE id = $1`
+	result, err := tx.Exec(query, firmwareID, metadata.BuildID, metadata.GitCommit, metadata.BuildTimestamp,
+		pq.Array(metadata.TargetHardware), metadata.Changelog, sbomFormat, sbomSpecVersion)
+	if err != nil {
+		return fmt.Errorf("failed to update firmware metadata: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update firmware metadata: %w", err)
+	}
+	if updated == 0 {
+		return ErrFirmwareNotFound
+	}
+
+	if _, err := tx.Exec(`DELETE FROM firmware_components WHERE firmware_id = $1`, firmwareID); err != nil {
+		return fmt.Errorf("failed to delete firmware components: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM firmware_sboms WHERE firmware_id = $1`, firmwareID); err != nil {
+		return fmt.Errorf("failed to delete firmware SBOM: %w", err)
+	}
+	if metadata.SBOM == nil {
+		return nil
+	}
+
+	_, err = tx.Exec(`INSERT INTO firmware_sboms (firmware_id, document) VALUES ($1, $2)`, firmwareID,
+		string(metadata.SBOM.Document))
+	if err != nil {
+		return fmt.Errorf("failed to create firmware SBOM: %w", err)
+	}
+
+	query = `INSERT INTO firmware_components (firmware_id, name, version, purl) VALUES ($1, $2, $3, $4)
+	         ON CONFLICT DO NOTHING`
+	for _, component := range metadata.SBOM.Components {
+		if _, err := tx.Exec(query, firmwareID, component.Name, component.Version, component.PURL); err != nil {
+			return fmt.Errorf("failed to create firmware component %s: %w", component.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetFirmwareSBOM returns the SBOM document attached to firmware.
+func (db *DB) GetFirmwareSBOM(firmwareID string) ([]byte, error) {
+	var document string
+	err := db.QueryRow(`SELECT document FROM firmware_sboms WHERE firmware_id = $1`, firmwareID).Scan(&document)
+	if err == sql.ErrNoRows {
+		return nil, ErrFirmwareSBOMNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get firmware SBOM: %w", err)
+	}
+	return []byte(document), nil
+}
+
+// ListFirmwareComponents returns the components in a firmware's SBOM.
+func (db *DB) ListFirmwareComponents(firmwareID string) ([]FirmwareComponent, error) {
+	query := `SELECT firmware_id, name, version, purl FROM firmware_components WHERE firmware_id = $1
+	          ORDER BY name, version`
+	return db.queryFirmwareComponents(query, firmwareID)
+}
+
+// SearchFirmwareComponents returns the firmware whose SBOM lists a component
+// with the name, ignoring case, newest first. An empty version matches any
+// version.
+func (db *DB) SearchFirmwareComponents(name, version string) ([]ComponentMatch, error) {
+	query := `SELECT firmware_id, name, version, purl FROM firmware_components
+	          WHERE LOWER(name) = LOWER($1) AND ($2 = '' OR version = $2)
+	          ORDER BY name, version`
+	components, err := db.queryFirmwareComponents(query, name, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(components) == 0 {
+		return nil, nil
+	}
+
+	byFirmware := make(map[string][]FirmwareComponent)
+	var firmwareIDs []string
+	for _, component := range components {
+		if byFirmware[component.FirmwareID] == nil {
+			firmwareIDs = append(firmwareIDs, component.FirmwareID)
+		}
+		byFirmware[component.FirmwareID] = append(byFirmware[component.FirmwareID], component)
+	}
+
+	query = `SELECT ` + firmwareColumns + ` FROM firmware WHERE id = ANY($1) ORDER BY created_at DESC`
+	rows, err := db.Query(query, pq.Array(firmwareIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firmware: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []ComponentMatch
+	for rows.Next() {
+		var match ComponentMatch
+		if err := scanFirmware(rows, &match.Firmware); err != nil {
+			return nil, fmt.Errorf("failed to scan firmware: %w", err)
+		}
+		match.Components = byFirmware[match.Firmware.ID]
+		matches = append(matches, match)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating firmware: %w", err)
+	}
+
+	return matches, nil
+}
+
+func (db *DB) queryFirmwareComponents(query string, args ...any) ([]FirmwareComponent, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firmware components: %w", err)
+	}
+	defer rows.Close()
+
+	var components []FirmwareComponent
+	for rows.Next() {
+		var component FirmwareComponent
+		err := rows.Scan(&component.FirmwareID, &component.Name, &component.Version, &component.PURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan firmware component: %w", err)
+		}
+		components = append(components, component)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating firmware components: %w", err)
+	}
+
+	return components, nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const releaseChannel = "release_changes"
+
+// WatchReleases delivers the ID of each release that is created or changes
+// until stop is closed. The listener reconnects on its own; an empty ID is
+// delivered after a reconnect, when changes may have been missed.
+func (db *DB) WatchReleases(stop <-chan struct{}) (<-chan string, error) {
+	listener := pq.NewListener(db.connStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Database listener error: %v", err)
+		}
+	})
+	if err := listener.Listen(releaseChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen for release changes: %w", err)
+	}
+
+	changes := make(chan string, 1)
+	go func() {
+		defer close(changes)
+		defer listener.Close()
+		for {
+			select {
+			case n := <-listener.Notify:
+				releaseID := ""
+				if n != nil {
+					releaseID = n.Extra
+				}
+				select {
+				case changes <- releaseID:
+				case <-stop:
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,491 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+	"github.com/lib/pq"
+)
+
+var ErrReleaseNotFound = errors.New("release not found")
+
+// DeviceBusyError is returned when a release tries to send a command to a
+// device that another release still holds.
+type DeviceBusyError struct {
+	DeviceID  string
+	ReleaseID string
+}
+
+func (e *DeviceBusyError) Error() string {
+	return fmt.Sprintf("device %s is held by release %s", e.DeviceID, e.ReleaseID)
+}
+
+type ReleaseEvent struct {
+	ID         int64
+	ReleaseID  string
+	Action     string
+	Actor      string
+	Reason     *string
+	FromStatus string
+	FromStage  string
+	ToStatus   string
+	ToStage    string
+	CreatedAt  string
+}
+
+type ReleaseDevice struct {
+	ReleaseID       string
+	DeviceID        string
+	Wave            *string
+	FirmwareID      *string
+	PreviousVersion *string
+	Status          string
+	Reason          *string
+	CommandID       *string
+	Attempts        int
+	NextAttemptAt   *time.Time
+	TimeoutAt       *time.Time
+	BaselineBattery *float64
+	BaselineTemp    *float64
+	InstalledAt     *time.Time
+	DeltaID         *string
+	FullImage       bool
+	CreatedAt       string
+	UpdatedAt       string
+}
+
+type ReleaseDeviceUpdate struct {
+	ReleaseID       string
+	DeviceID        string
+	Wave            string
+	FirmwareID      string
+	PreviousVersion string
+	Status          string
+	Reason          string
+	CommandID       string
+	Attempts        int
+	NextAttemptAt   *time.Time
+	TimeoutAt       *time.Time
+	BaselineBattery *float64
+	BaselineTemp    *float64
+	DeltaID         string
+}
+
+// UpsertReleaseDevice records a device's state in a release. Moving a device
+// into one of rollout.HoldingStatuses claims it for the release first, and
+// fails with a *DeviceBusyError if another release holds it.
+func (db *DB) UpsertReleaseDevice(update ReleaseDeviceUpdate) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if slices.Contains(rollout.HoldingStatuses, update.Status) {
+		if err := claimDevice(tx, update.DeviceID, update.ReleaseID); err != nil {
+			return err
+		}
+	}
+
+	query := `INSERT INTO release_devices (release_id, device_id, firmware_id, previous_version, status, reason,
+	          next_attempt_at, wave, command_id, attempts, timeout_at, baseline_battery, baseline_temperature, delta_id)
+	          VALUES ($1, $2, NULLIF($3, '')::UUID, NULLIF($4, ''), $5, NULLIF($6, ''), $7, NULLIF($8, ''),
+	                  NULLIF($9, ''), $10, $11, $12, $13, NULLIF($14, '')::UUID)
+	          ON CONFLICT (release_id, device_id) DO UPDATE SET
+	            wave = COALESCE(release_devices.wave, EXCLUDED.wave),
+	            firmware_id = EXCLUDED.firmware_id,
+	            previous_version = COALESCE(release_devices.previous_version, EXCLUDED.previous_version),
+	            status = EXCLUDED.status,
+	            reason = EXCLUDED.reason,
+	            next_attempt_at = EXCLUDED.next_attempt_at,
+	            command_id = EXCLUDED.command_id,
+	            attempts = EXCLUDED.attempts,
+	            timeout_at = EXCLUDED.timeout_at,
+	            baseline_battery = COALESCE(release_devices.baseline_battery, EXCLUDED.baseline_battery),
+	            baseline_temperature = COALESCE(release_devices.baseline_temperature, EXCLUDED.baseline_temperature),
+	            delta_id = EXCLUDED.delta_id,
+	            updated_at = NOW()`
+	_, err = tx.Exec(query, update.ReleaseID, update.DeviceID, update.FirmwareID, update.PreviousVersion,
+		update.Status, update.Reason, update.NextAttemptAt, update.Wave, update.CommandID, update.Attempts,
+		update.TimeoutAt, update.BaselineBattery, update.BaselineTemp, update.DeltaID)
+	if err != nil {
+		return fmt.Errorf("failed to record release device: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit release device: %w", err)
+	}
+	return nil
+}
+
+// HoldReleaseDevice claims a device for a release and moves the device's row
+// in it to a holding status, recording the send attempt and the deadline for
+// the device's next report. It fails with a *DeviceBusyError if another
+// release holds the device.
+func (db *DB) HoldReleaseDevice(releaseID, deviceID, status, reason string, attempts int, timeoutAt *time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := claimDevice(tx, deviceID, releaseID); err != nil {
+		return err
+	}
+
+	query := `UPDATE release_devices SET status = $3, reason = NULLIF($4, ''), attempts = $5, next_attempt_at = NULL,
+	            timeout_at = $6, updated_at = NOW()
+	          WHERE release_id = $1 AND device_id = $2`
+	if _, err := tx.Exec(query, releaseID, deviceID, status, reason, attempts, timeoutAt); err != nil {
+		return fmt.Errorf("failed to update release device status: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit release device: %w", err)
+	}
+	return nil
+}
+
+// claimDevice records releaseID as the release holding the device. The
+// previous holder keeps the device while its release is open and its row is
+// in one of rollout.HoldingStatuses. The device row stays locked until the
+// transaction ends, so the caller must move its own row into a holding status
+// in the same transaction.
+func claimDevice(tx *sql.Tx, deviceID, releaseID string) error {
+	var holder sql.NullString
+	query := `SELECT update_release_id FROM devices WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRow(query, deviceID).Scan(&holder); err != nil {
+		return fmt.Errorf("failed to lock device: %w", err)
+	}
+
+	if holder.Valid && holder.String != releaseID {
+		var held bool
+		query = `SELECT EXISTS(
+		           SELECT 1 FROM release_devices rd JOIN releases r ON r.id = rd.release_id
+		           WHERE rd.release_id = $1 AND rd.device_id = $2 AND r.status = ANY($3) AND rd.status = ANY($4))`
+		err := tx.QueryRow(query, holder.String, deviceID, pq.Array(rollout.OpenStatuses),
+			pq.Array(rollout.HoldingStatuses)).Scan(&held)
+		if err != nil {
+			return fmt.Errorf("failed to check device holder: %w", err)
+		}
+		if held {
+			return &DeviceBusyError{DeviceID: deviceID, ReleaseID: holder.String}
+		}
+	}
+
+	query = `UPDATE devices SET update_release_id = $2, updated_at = NOW() WHERE id = $1`
+	if _, err := tx.Exec(query, deviceID, releaseID); err != nil {
+		return fmt.Errorf("failed to claim device: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) UpdateReleaseDeviceStatus(releaseID, deviceID, status, reason string) error {
+	query := `UPDATE release_devices SET status = $3, reason = NULLIF($4, ''), timeout_at = NULL, updated_at = NOW()
+	          WHERE release_id = $1 AND device_id = $2`
+	_, err := db.Exec(query, releaseID, deviceID, status, reason)
+	if err != nil {
+		return fmt.Errorf("failed to update release device status: %w", err)
+	}
+	return nil
+}
+
+// RestoreReleaseDevice puts a device's row in a release back the way it was
+// read, undoing a send whose command could not be published.
+func (db *DB) RestoreReleaseDevice(rd ReleaseDevice) error {
+	query := `UPDATE release_devices SET status = $3, reason = $4, attempts = $5, next_attempt_at = $6,
+	            timeout_at = $7, updated_at = NOW()
+	          WHERE release_id = $1 AND device_id = $2`
+	_, err := db.Exec(query, rd.ReleaseID, rd.DeviceID, rd.Status, rd.Reason, rd.Attempts, rd.NextAttemptAt,
+		rd.TimeoutAt)
+	if err != nil {
+		return fmt.Errorf("failed to restore release device: %w", err)
+	}
+	return nil
+}
+
+// UpdateActiveReleaseDeviceStatus moves a device's row in any in-progress
+// release from one of the given statuses to a new one and sets the deadline
+// for the next report. Update status reports from devices do not carry a
+// release ID, so this is how they are attributed. A non-empty commandID
+// restricts the update to the row that command was sent for, so late reports
+// for an earlier command are ignored. Moving a row to verifying records when
+// the device finished installing.
+func (db *DB) UpdateActiveReleaseDeviceStatus(deviceID, commandID string, from []string, status, reason string,
+	timeoutAt *time.Time) error {
+	query := `UPDATE release_devices rd SET status = $3, reason = NULLIF($4, ''), timeout_at = $5,
+	            installed_at = CASE WHEN $3 = '` + rollout.DeviceVerifying + `' THEN NOW() ELSE rd.installed_at END,
+	            updated_at = NOW()
+	          FROM releases r
+	          WHERE rd.release_id = r.id AND r.status IN ('in_progress', 'paused')
+	            AND rd.device_id = $1 AND rd.status = ANY($2)
+	            AND ($6 = '' OR rd.command_id = $6)`
+	_, err := db.Exec(query, deviceID, pq.Array(from), status, reason, timeoutAt, commandID)
+	if err != nil {
+		return fmt.Errorf("failed to update release device status: %w", err)
+	}
+	return nil
+}
+
+// FallBackToFullImage schedules an immediate resend of the full image to a
+// device that reported a failed delta update, and stops deltas being sent to
+// it in the release. The command ID is cleared so the resend gets a new one.
+// It reports false if the device's current command was not a delta, in which
+// case the failure should be recorded as usual.
+func (db *DB) FallBackToFullImage(deviceID, commandID, reason string, retryAt time.Time) (bool, error) {
+	query := `UPDATE release_devices rd SET status = $3, reason = NULLIF($4, ''), next_attempt_at = $5,
+	            timeout_at = NULL, full_image = TRUE, delta_id = NULL, command_id = NULL, updated_at = NOW()
+	          FROM releases r
+	          WHERE rd.release_id = r.id AND r.status IN ('in_progress', 'paused')
+	            AND rd.device_id = $1 AND rd.status = ANY($2) AND rd.delta_id IS NOT NULL
+	            AND ($6 = '' OR rd.command_id = $6)`
+	result, err := db.Exec(query, deviceID, pq.Array(rollout.InFlightStatuses), rollout.DeviceRetrying, reason,
+		retryAt, commandID)
+	if err != nil {
+		return false, fmt.Errorf("failed to fall back to full image: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to fall back to full image: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// ResolveReleaseDevice moves a device out of the given status. It reports
+// false if the device has already moved on.
+func (db *DB) ResolveReleaseDevice(releaseID, deviceID, from, status, reason string) (bool, error) {
+	query := `UPDATE release_devices SET status = $4, reason = NULLIF($5, ''), timeout_at = NULL, updated_at = NOW()
+	          WHERE release_id = $1 AND device_id = $2 AND status = $3`
+	result, err := db.Exec(query, releaseID, deviceID, from, status, reason)
+	if err != nil {
+		return false, fmt.Errorf("failed to update release device status: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update release device status: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// ExpireReleaseDevice moves a device whose report deadline has passed out of
+// the given status. It reports false if the device reported in the meantime.
+func (db *DB) ExpireReleaseDevice(releaseID, deviceID, from, status, reason string, nextAttemptAt *time.Time,
+	now time.Time) (bool, error) {
+	query := `UPDATE release_devices SET status = $4, reason = NULLIF($5, ''), next_attempt_at = $6, timeout_at = NULL,
+	            updated_at = NOW()
+	          WHERE release_id = $1 AND device_id = $2 AND status = $3 AND timeout_at <= $7`
+	result, err := db.Exec(query, releaseID, deviceID, from, status, reason, nextAttemptAt, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to expire release device: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to expire release device: %w", err)
+	}
+	return rows > 0, nil
+}
+
+type StatusCount struct {
+	ReleaseID string
+	Status    string
+	Count     int
+}
+
+// CountActiveReleaseDevices counts devices in the given statuses for every
+// release that is in progress or paused.
+func (db *DB) CountActiveReleaseDevices(statuses []string) ([]StatusCount, error) {
+	query := `SELECT rd.release_id, rd.status, COUNT(*)
+	          FROM release_devices rd JOIN releases r ON r.id = rd.release_id
+	          WHERE r.status IN ('in_progress', 'paused') AND rd.status = ANY($1)
+	          GROUP BY rd.release_id, rd.status`
+	rows, err := db.Query(query, pq.Array(statuses))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count release devices: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []StatusCount
+	for rows.Next() {
+		var count StatusCount
+		if err := rows.Scan(&count.ReleaseID, &count.Status, &count.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan release device count: %w", err)
+		}
+		counts = append(counts, count)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating release device counts: %w", err)
+	}
+
+	return counts, nil
+}
+
+func (db *DB) ListReleaseDevices(releaseID string) ([]ReleaseDevice, error) {
+	query := `SELECT ` + releaseDeviceColumns + `
+	          FROM release_devices WHERE release_id = $1 ORDER BY created_at`
+	return db.queryReleaseDevices(query, releaseID)
+}
+
+// ListActiveReleaseDevices returns the device's rows in the given status in
+// every release that is in progress or paused.
+func (db *DB) ListActiveReleaseDevices(deviceID, status string) ([]ReleaseDevice, error) {
+	query := `SELECT ` + releaseDeviceColumns + `
+	          FROM release_devices
+	          WHERE device_id = $1 AND status = $2 AND release_id IN (
+	            SELECT id FROM releases WHERE status IN ('in_progress', 'paused'))`
+	return db.queryReleaseDevices(query, deviceID, status)
+}
+
+// DeviceCanDownload reports whether the device holds the firmware in a
+// release that is still open, either as the image it is being sent or as the
+// version a rollback restores.
+func (db *DB) DeviceCanDownload(deviceID, firmwareID string) (bool, error) {
+	query := `SELECT EXISTS (
+	            SELECT 1 FROM release_devices rd
+	            JOIN releases r ON r.id = rd.release_id
+	            JOIN firmware f ON f.id = $2
+	            WHERE rd.device_id = $1 AND r.status = ANY($3) AND rd.status = ANY($4)
+	              AND (rd.firmware_id = f.id OR (rd.status = $5 AND rd.previous_version = f.version)))`
+	var allowed bool
+	err := db.QueryRow(query, deviceID, firmwareID, pq.Array(rollout.OpenStatuses),
+		pq.Array(rollout.HoldingStatuses), rollout.DeviceRollbackSent).Scan(&allowed)
+	if err != nil {
+		return false, fmt.Errorf("failed to check firmware access: %w", err)
+	}
+	return allowed, nil
+}
+
+// DeviceCanDownloadDelta reports whether the delta was sent to the device in
+// a release that is still open.
+func (db *DB) DeviceCanDownloadDelta(deviceID, deltaID string) (bool, error) {
+	query := `SELECT EXISTS (
+	            SELECT 1 FROM release_devices rd
+	            JOIN releases r ON r.id = rd.release_id
+	            WHERE rd.device_id = $1 AND rd.delta_id = $2 AND r.status = ANY($3) AND rd.status = ANY($4))`
+	var allowed bool
+	err := db.QueryRow(query, deviceID, deltaID, pq.Array(rollout.OpenStatuses),
+		pq.Array(rollout.HoldingStatuses)).Scan(&allowed)
+	if err != nil {
+		return false, fmt.Errorf("failed to check delta access: %w", err)
+	}
+	return allowed, nil
+}
+
+const releaseDeviceColumns = `release_id, device_id, wave, firmware_id, previous_version, status, reason,
+	command_id, attempts, next_attempt_at, timeout_at, baseline_battery, baseline_temperature, installed_at,
+	delta_id, full_image, created_at, updated_at`
+
+func (db *DB) queryReleaseDevices(query string, args ...any) ([]ReleaseDevice, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list release devices: %w", err)
+	}
+	defer rows.Close()
+
+	var releaseDevices []ReleaseDevice
+	for rows.Next() {
+		var rd ReleaseDevice
+		err := rows.Scan(
+			&rd.ReleaseID, &rd.DeviceID, &rd.Wave, &rd.FirmwareID, &rd.PreviousVersion, &rd.Status, &rd.Reason,
+			&rd.CommandID, &rd.Attempts, &rd.NextAttemptAt, &rd.TimeoutAt, &rd.BaselineBattery, &rd.BaselineTemp,
+			&rd.InstalledAt, &rd.DeltaID, &rd.FullImage, &rd.CreatedAt, &rd.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan release device: %w", err)
+		}
+		releaseDevices = append(releaseDevices, rd)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating release devices: %w", err)
+	}
+
+	return releaseDevices, nil
+}
+
+// ApplyReleaseAction moves a release through the rollout state machine and
+// records who did it and why. The current state is read under a row lock so
+// concurrent actions cannot both succeed from the same starting state.
+func (db *DB) ApplyReleaseAction(releaseID, action, actor, reason string) (*Release, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var current rollout.State
+	query := `SELECT status, stage FROM releases WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRow(query, releaseID).Scan(&current.Status, &current.Stage)
+	if err == sql.ErrNoRows {
+		return nil, ErrReleaseNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get release: %w", err)
+	}
+
+	next, err := rollout.Transition(current, action)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `UPDATE releases SET status = $1, stage = $2, updated_at = NOW() WHERE id = $3`
+	if _, err := tx.Exec(query, next.Status, next.Stage, releaseID); err != nil {
+		return nil, fmt.Errorf("failed to update release status: %w", err)
+	}
+
+	if action == rollout.ActionRetryFailed {
+		query = `UPDATE release_devices SET status = $2, reason = 'retry requested', command_id = NULL, attempts = 0,
+		           timeout_at = NULL, next_attempt_at = NULL, updated_at = NOW()
+		         WHERE release_id = $1 AND status = ANY($3)`
+		failed := []string{rollout.DeviceFailed, rollout.DeviceTimedOut}
+		if _, err := tx.Exec(query, releaseID, rollout.DeviceDeferred, pq.Array(failed)); err != nil {
+			return nil, fmt.Errorf("failed to reset failed devices: %w", err)
+		}
+	}
+
+	query = `INSERT INTO release_events (release_id, action, actor, reason, from_status, from_stage, to_status, to_stage)
+	         VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8)`
+	_, err = tx.Exec(query, releaseID, action, actor, reason, current.Status, current.Stage, next.Status, next.Stage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record release event: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit release action: %w", err)
+	}
+
+	return db.GetReleaseByID(releaseID)
+}
+
+func (db *DB) ListReleaseEvents(releaseID string) ([]ReleaseEvent, error) {
+	query := `SELECT id, release_id, action, actor, reason, from_status, from_stage, to_status, to_stage, created_at
+	          FROM release_events WHERE release_id = $1 ORDER BY id`
+	rows, err := db.Query(query, releaseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list release events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []ReleaseEvent
+	for rows.Next() {
+		var event ReleaseEvent
+		err := rows.Scan(
+			&event.ID, &event.ReleaseID, &event.Action, &event.Actor, &event.Reason,
+			&event.FromStatus, &event.FromStage, &event.ToStatus, &event.ToStage, &event.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan release event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating release events: %w", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrUploadSessionNotFound = errors.New("upload session not found")
+
+// UploadSession is a resumable firmware upload. The firmware metadata is
+// fixed when the session is created; the image arrives in chunks.
+type UploadSession struct {
+	ID                 string
+	Version            string
+	Description        *string
+	HardwareModels     []string
+	MinSourceVersion   *string
+	RequiredBootloader *string
+	UpgradePath        []string
+	Size               *int64
+	ExpiresAt          time.Time
+	CreatedAt          string
+}
+
+// Compatibility returns the compatibility the firmware is created with.
+func (s *UploadSession) Compatibility() FirmwareCompatibility {
+	compat := FirmwareCompatibility{HardwareModels: s.HardwareModels, UpgradePath: s.UpgradePath}
+	if s.MinSourceVersion != nil {
+		compat.MinSourceVersion = *s.MinSourceVersion
+	}
+	if s.RequiredBootloader != nil {
+		compat.RequiredBootloader = *s.RequiredBootloader
+	}
+	return compat
+}
+
+func (db *DB) CreateUploadSession(version, description string, compat FirmwareCompatibility, size *int64,
+	expiresAt time.Time) (string, error) {
+	var sessionID string
+	query := `INSERT INTO upload_sessions (version, description, hardware_models, min_source_version,
+	          required_bootloader, upgrade_path, size, expires_at)
+	          VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8) RETURNING id`
+	err := db.QueryRow(query, version, description, pq.Array(compat.HardwareModels), compat.MinSourceVersion,
+		compat.RequiredBootloader, pq.Array(compat.UpgradePath), size, expiresAt).Scan(&sessionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload session: %w", err)
+	}
+	return sessionID, nil
+}
+
+// GetUploadSession returns a session that has not expired.
+func (db *DB) GetUploadSession(sessionID string) (*UploadSession, error) {
+	var session UploadSession
+	query := `SELECT id, version, description, hardware_models, min_source_version, required_bootloader,
+	          upgrade_path, size, expires_at, created_at
+	          FROM upload_sessions WHERE id = $1 AND expires_at > NOW()`
+	err := db.QueryRow(query, sessionID).Scan(
+		&session.ID, &session.Version, &session.Description, pq.Array(&session.HardwareModels),
+		&session.MinSourceVersion, &session.RequiredBootloader, pq.Array(&session.UpgradePath),
+		&session.Size, &session.ExpiresAt, &session.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrUploadSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+	return &session, nil
+}
+
+// ExtendUploadSession moves the expiry of a session that is still receiving
+// chunks.
+func (db *DB) ExtendUploadSession(sessionID string, expiresAt time.Time) error {
+	_, err := db.Exec(`UPDATE upload_sessions SET expires_at = $2 WHERE id = $1`, sessionID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to extend upload session: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredUploadSession deletes a session if it is still expired, so a
+// session extended in the meantime is kept. It reports whether it did.
+func (db *DB) DeleteExpiredUploadSession(sessionID string) (bool, error) {
+	result, err := db.Exec(`DELETE FROM upload_sessions WHERE id = $1 AND expires_at <= NOW()`, sessionID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete upload session: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete upload session: %w", err)
+	}
+	return affected > 0, nil
+}
+
+func (db *DB) DeleteUploadSession(sessionID string) error {
+	_, err := db.Exec(`DELETE FROM upload_sessions WHERE id = $1`, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete upload session: %w", err)
+	}
+	return nil
+}
+
+// ListExpiredUploadSessions returns the IDs of sessions that expired before
+// the given time.
+func (db *DB) ListExpiredUploadSessions(before time.Time) ([]string, error) {
+	rows, err := db.Query(`SELECT id FROM upload_sessions WHERE expires_at <= $1`, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired upload sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan upload session: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating upload sessions: %w", err)
+	}
+
+	return ids, nil
+}
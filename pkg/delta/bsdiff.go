@@ -0,0 +1,354 @@
+// Package delta builds binary patches between firmware images, so devices
+// that already run a known image download only what changed.
+//
+// Patches are produced with Colin Percival's bsdiff algorithm. A patch is the
+// 8-byte magic "AURABSD1", the size of the new image as a little-endian
+// uint64, and a zlib stream of blocks. Each block is three little-endian
+// int64 values x, y and z, then x bytes that are added bytewise to the next x
+// bytes of the old image, then y bytes copied to the new image as they are;
+// the read position in the old image then moves by z. Blocks are applied in
+// order until the new image is complete.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Algorithm names the patch format in update commands.
+const Algorithm = "bsdiff-zlib"
+
+const magic = "AURABSD1"
+
+var ErrCorruptPatch = errors.New("corrupt patch")
+
+// Diff returns a patch that turns oldData into newData.
+func Diff(oldData, newData []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString(magic)
+	binary.Write(&out, binary.LittleEndian, uint64(len(newData)))
+
+	zw, err := zlib.NewWriterLevel(&out, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(zw)
+
+	index := suffixSort(oldData)
+	oldSize, newSize := len(oldData), len(newData)
+
+	var scan, pos, length, lastScan, lastPos, lastOffset int
+	var diff []byte
+	for scan < newSize {
+		oldScore := 0
+		scan += length
+		for scsc := scan; scan < newSize; scan++ {
+			pos, length = search(index, oldData, newData[scan:], 0, oldSize)
+
+			for ; scsc < scan+length; scsc++ {
+				if scsc+lastOffset < oldSize && oldData[scsc+lastOffset] == newData[scsc] {
+					oldScore++
+				}
+			}
+			if (length == oldScore && length != 0) || length > oldScore+8 {
+				break
+			}
+			if scan+lastOffset < oldSize && oldData[scan+lastOffset] == newData[scan] {
+				oldScore--
+			}
+		}
+
+		if length == oldScore && scan != newSize {
+			continue
+		}
+
+		// Extend the previous match forwards and the new one backwards
+		// while at least half of the bytes agree.
+		lenF := 0
+		for i, s, best := 0, 0, 0; lastScan+i < scan && lastPos+i < oldSize; {
+			if oldData[lastPos+i] == newData[lastScan+i] {
+				s++
+			}
+			i++
+			if s*2-i > best*2-lenF {
+				best, lenF = s, i
+			}
+		}
+
+		lenB := 0
+		if scan < newSize {
+			for i, s, best := 1, 0, 0; scan >= lastScan+i && pos >= i; i++ {
+				if oldData[pos-i] == newData[scan-i] {
+					s++
+				}
+				if s*2-i > best*2-lenB {
+					best, lenB = s, i
+				}
+			}
+		}
+
+		if lastScan+lenF > scan-lenB {
+			overlap := (lastScan + lenF) - (scan - lenB)
+			s, best, lenS := 0, 0, 0
+			for i := 0; i < overlap; i++ {
+				if newData[lastScan+lenF-overlap+i] == oldData[lastPos+lenF-overlap+i] {
+					s++
+				}
+				if newData[scan-lenB+i] == oldData[pos-lenB+i] {
+					s--
+				}
+				if s > best {
+					best, lenS = s, i+1
+				}
+			}
+			lenF += lenS - overlap
+			lenB -= lenS
+		}
+
+		diff = diff[:0]
+		for i := 0; i < lenF; i++ {
+			diff = append(diff, newData[lastScan+i]-oldData[lastPos+i])
+		}
+		extra := newData[lastScan+lenF : scan-lenB]
+		control := [3]int64{int64(lenF), int64(len(extra)), int64((pos - lenB) - (lastPos + lenF))}
+		binary.Write(w, binary.LittleEndian, control)
+		w.Write(diff)
+		w.Write(extra)
+
+		lastScan = scan - lenB
+		lastPos = pos - lenB
+		lastOffset = pos - scan
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write patch: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write patch: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// Patch applies a patch made by Diff to oldData. Patches for images larger
+// than maxSize are rejected before anything is allocated.
+func Patch(oldData, patch []byte, maxSize int64) ([]byte, error) {
+	if len(patch) < len(magic)+8 || string(patch[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: bad header", ErrCorruptPatch)
+	}
+	newSize := binary.LittleEndian.Uint64(patch[len(magic):])
+	if newSize > uint64(maxSize) {
+		return nil, fmt.Errorf("%w: image of %d bytes is larger than %d", ErrCorruptPatch, newSize, maxSize)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(patch[len(magic)+8:]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptPatch, err)
+	}
+	defer zr.Close()
+	r := bufio.NewReader(zr)
+
+	newData := make([]byte, newSize)
+	var oldPos, newPos int64
+	for newPos < int64(newSize) {
+		var control [3]int64
+		if err := binary.Read(r, binary.LittleEndian, &control); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptPatch, err)
+		}
+		add, copyLen, seek := control[0], control[1], control[2]
+		if add < 0 || copyLen < 0 || add > int64(newSize)-newPos || copyLen > int64(newSize)-newPos-add {
+			return nil, fmt.Errorf("%w: block out of range", ErrCorruptPatch)
+		}
+
+		block := newData[newPos : newPos+add]
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptPatch, err)
+		}
+		for i := range block {
+			if p := oldPos + int64(i); p >= 0 && p < int64(len(oldData)) {
+				block[i] += oldData[p]
+			}
+		}
+		newPos += add
+		oldPos += add
+
+		if _, err := io.ReadFull(r, newData[newPos:newPos+copyLen]); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptPatch, err)
+		}
+		newPos += copyLen
+		oldPos += seek
+	}
+	return newData, nil
+}
+
+// search finds the longest prefix of target in data among the suffixes
+// index[start:end+1], which are in sorted order.
+func search(index []int, data, target []byte, start, end int) (pos, length int) {
+	for end-start >= 2 {
+		mid := start + (end-start)/2
+		suffix := data[index[mid]:]
+		n := min(len(suffix), len(target))
+		if bytes.Compare(suffix[:n], target[:n]) < 0 {
+			start = mid
+		} else {
+			end = mid
+		}
+	}
+	x := matchLen(data[index[start]:], target)
+	y := matchLen(data[index[end]:], target)
+	if x > y {
+		return index[start], x
+	}
+	return index[end], y
+}
+
+func matchLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// suffixSort returns the suffix array of data, including the empty suffix,
+// using Larsson and Sadakane's qsufsort as bsdiff does.
+func suffixSort(data []byte) []int {
+	n := len(data)
+	index := make([]int, n+1)
+	group := make([]int, n+1)
+
+	var buckets [256]int
+	for _, c := range data {
+		buckets[c]++
+	}
+	for i := 1; i < 256; i++ {
+		buckets[i] += buckets[i-1]
+	}
+	for i := 255; i > 0; i-- {
+		buckets[i] = buckets[i-1]
+	}
+	buckets[0] = 0
+
+	for i, c := range data {
+		buckets[c]++
+		index[buckets[c]] = i
+	}
+	index[0] = n
+	for i, c := range data {
+		group[i] = buckets[c]
+	}
+	group[n] = 0
+	for i := 1; i < 256; i++ {
+		if buckets[i] == buckets[i-1]+1 {
+			index[buckets[i]] = -1
+		}
+	}
+	index[0] = -1
+
+	for h := 1; index[0] != -(n + 1); h += h {
+		length := 0
+		i := 0
+		for i < n+1 {
+			if index[i] < 0 {
+				length -= index[i]
+				i -= index[i]
+				continue
+			}
+			if length != 0 {
+				index[i-length] = -length
+			}
+			length = group[index[i]] + 1 - i
+			split(index, group, i, length, h)
+			i += length
+			length = 0
+		}
+		if length != 0 {
+			index[i-length] = -length
+		}
+	}
+
+	for i := 0; i < n+1; i++ {
+		index[group[i]] = i
+	}
+	return index
+}
+
+func split(index, group []int, start, length, h int) {
+	if length < 16 {
+		for k, j := start, 0; k < start+length; k += j {
+			j = 1
+			x := group[index[k]+h]
+			for i := 1; k+i < start+length; i++ {
+				if group[index[k+i]+h] < x {
+					x = group[index[k+i]+h]
+					j = 0
+				}
+				if group[index[k+i]+h] == x {
+					index[k+j], index[k+i] = index[k+i], index[k+j]
+					j++
+				}
+			}
+			for i := 0; i < j; i++ {
+				group[index[k+i]] = k + j - 1
+			}
+			if j == 1 {
+				index[k] = -1
+			}
+		}
+		return
+	}
+
+	x := group[index[start+length/2]+h]
+	jj, kk := 0, 0
+	for i := start; i < start+length; i++ {
+		if group[index[i]+h] < x {
+			jj++
+		}
+		if group[index[i]+h] == x {
+			kk++
+		}
+	}
+	jj += start
+	kk += jj
+
+	i, j, k := start, 0, 0
+	for i < jj {
+		switch v := group[index[i]+h]; {
+		case v < x:
+			i++
+		case v == x:
+			index[i], index[jj+j] = index[jj+j], index[i]
+			j++
+		default:
+			index[i], index[kk+k] = index[kk+k], index[i]
+			k++
+		}
+	}
+	for jj+j < kk {
+		if group[index[jj+j]+h] == x {
+			j++
+		} else {
+			index[jj+j], index[kk+k] = index[kk+k], index[jj+j]
+			k++
+		}
+	}
+
+	if jj > start {
+		split(index, group, start, jj-start, h)
+	}
+	for i := 0; i < kk-jj; i++ {
+		group[index[jj+i]] = kk - 1
+	}
+	if jj == kk-1 {
+		index[jj] = -1
+	}
+	if start+length > kk {
+		split(index, group, kk, start+length-kk, h)
+	}
+}
@@ -0,0 +1,65 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestPatchRestoresImage(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		r.Read(data)
+		return data
+	}
+
+	image := random(64 << 10)
+	copy(image[8<<10:], make([]byte, 16<<10))
+	edited := append([]byte{}, image...)
+	for i := 0; i < 50; i++ {
+		edited[r.Intn(len(edited))]++
+	}
+	edited = append(edited[:1000], append([]byte("inserted section"), edited[1000:]...)...)
+
+	tests := []struct {
+		name     string
+		old, new []byte
+	}{
+		{"edited", image, edited},
+		{"unrelated", random(4 << 10), random(3 << 10)},
+		{"from empty", nil, random(100)},
+		{"to empty", random(100), nil},
+		{"repetitive", bytes.Repeat([]byte{0, 1, 0}, 5000), bytes.Repeat([]byte{0, 1, 1}, 4000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := Diff(tt.old, tt.new)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Patch(tt.old, patch, int64(len(tt.new)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.new) {
+				t.Fatal("patched image does not match")
+			}
+		})
+	}
+
+	patch, err := Diff(image, edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patch) > len(edited)/10 {
+		t.Errorf("patch is %d bytes for a %d byte image", len(patch), len(edited))
+	}
+	if _, err := Patch(image, patch[:len(patch)/2], int64(len(edited))); !errors.Is(err, ErrCorruptPatch) {
+		t.Errorf("truncated patch: got %v, want ErrCorruptPatch", err)
+	}
+	if _, err := Patch(image, patch, int64(len(edited)-1)); !errors.Is(err, ErrCorruptPatch) {
+		t.Errorf("oversized image: got %v, want ErrCorruptPatch", err)
+	}
+}
@@ -0,0 +1,133 @@
+package delta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/storage"
+)
+
+var (
+	// ErrImageTooLarge is returned when an image is too large to diff.
+	ErrImageTooLarge = errors.New("firmware image too large for a delta")
+	// ErrNoSavings is returned when a patch would not be enough smaller
+	// than the full image to be worth sending.
+	ErrNoSavings = errors.New("delta is not smaller than the full image")
+)
+
+// Builder makes patches between stored firmware images and records them
+// with the target firmware. Diffing holds both images and their suffix
+// array in memory, so builds run one at a time.
+type Builder struct {
+	db           *database.DB
+	storage      storage.Storage
+	uploadDir    string
+	maxImageSize int64
+	minSavings   float64
+
+	mu sync.Mutex
+}
+
+func NewBuilder(db *database.DB, storage storage.Storage, uploadDir string, maxImageSize int64,
+	minSavings float64) *Builder {
+	return &Builder{
+		db:           db,
+		storage:      storage,
+		uploadDir:    uploadDir,
+		maxImageSize: maxImageSize,
+		minSavings:   minSavings,
+	}
+}
+
+// Build returns the patch from base to target, making it if it does not
+// exist yet. Each patch is applied to the base before it is stored, so a
+// recorded patch is known to reproduce the target exactly.
+func (b *Builder) Build(base, target *database.Firmware) (*database.FirmwareDelta, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	existing, err := b.db.GetFirmwareDelta(base.ID, target.ID)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, database.ErrFirmwareDeltaNotFound) {
+		return nil, err
+	}
+	if base.FileSize > b.maxImageSize || target.FileSize > b.maxImageSize {
+		return nil, ErrImageTooLarge
+	}
+
+	oldData, err := b.read(base)
+	if err != nil {
+		return nil, err
+	}
+	newData, err := b.read(target)
+	if err != nil {
+		return nil, err
+	}
+
+	patch, err := Diff(oldData, newData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff firmware: %w", err)
+	}
+	patched, err := Patch(oldData, patch, int64(len(newData)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify delta: %w", err)
+	}
+	if !bytes.Equal(patched, newData) {
+		return nil, errors.New("failed to verify delta: patched image does not match the target")
+	}
+	if float64(len(patch)) > float64(len(newData))*(1-b.minSavings) {
+		return nil, ErrNoSavings
+	}
+
+	upload, err := storage.NewUpload(b.uploadDir, bytes.NewReader(patch), int64(len(patch)))
+	if err != nil {
+		return nil, err
+	}
+	defer upload.Discard()
+
+	blobName := storage.BlobName(upload.Checksum())
+	unlock, err := b.db.LockFirmwareBlobs([]string{blobName})
+	if err != nil {
+		return nil, err
+	}
+	filePath, err := b.storage.SaveFirmware(blobName, upload)
+	if err == nil {
+		err = b.db.CreateFirmwareDelta(base.ID, target.ID, Algorithm, blobName, filePath, upload.Checksum(), upload.Size())
+	}
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	return b.db.GetFirmwareDelta(base.ID, target.ID)
+}
+
+// read loads a stored image and checks it against its recorded checksum.
+func (b *Builder) read(firmware *database.Firmware) ([]byte, error) {
+	body, err := b.storage.GetFirmware(firmware.BlobName)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, b.maxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read firmware %s: %w", firmware.Version, err)
+	}
+	if int64(len(data)) > b.maxImageSize {
+		return nil, ErrImageTooLarge
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != strings.ToLower(firmware.Checksum) {
+		return nil, fmt.Errorf("stored image of firmware %s does not match its checksum", firmware.Version)
+	}
+	return data, nil
+}
@@ -11,14 +11,18 @@ import (
 type Client struct {
 	client mqtt.Client
 	broker string
+	signer Signer
 }
 
+// Config configures the broker connection. Signer signs the commands the
+// client publishes; a client without one can only subscribe.
 type Config struct {
 	Broker   string
 	Port     int
 	ClientID string
 	Username string
 	Password string
+	Signer   Signer
 }
 
 func NewClient(cfg Config) (*Client, error) {
@@ -48,6 +52,7 @@ func NewClient(cfg Config) (*Client, error) {
 	return &Client{
 		client: client,
 		broker: brokerURL,
+		signer: cfg.Signer,
 	}, nil
 }
 
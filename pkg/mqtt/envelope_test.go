@@ -0,0 +1,88 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/10xdev4u-alt/aura/pkg/pki"
+)
+
+func TestCommandEnvelope(t *testing.T) {
+	signer, err := pki.LoadUpdateSigner(filepath.Join(t.TempDir(), "update.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := pki.LoadUpdateSigner(filepath.Join(t.TempDir(), "other.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := &UpdateCommand{DeviceID: "device-1", CommandID: "cmd-1", Action: "update", Version: "2.0.0"}
+	sealed, err := (&Client{signer: signer}).seal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(*CommandEnvelope)
+		verifier Verifier
+		valid    bool
+	}{
+		{name: "round trip", verifier: signer, valid: true},
+		{name: "modified payload", verifier: signer, modify: func(e *CommandEnvelope) {
+			e.Payload = bytes.Replace(e.Payload, []byte("2.0.0"), []byte("9.0.0"), 1)
+		}},
+		{name: "modified signature", verifier: signer, modify: func(e *CommandEnvelope) { e.Signature[0] ^= 1 }},
+		{name: "unknown version", verifier: signer, modify: func(e *CommandEnvelope) { e.Version++ }},
+		{name: "modified algorithm", verifier: signer, modify: func(e *CommandEnvelope) { e.Algorithm = "none" }},
+		{name: "modified key ID", verifier: signer, modify: func(e *CommandEnvelope) { e.KeyID = other.KeyID() }},
+		{name: "other key", verifier: other, modify: func(e *CommandEnvelope) { e.KeyID = other.KeyID() }},
+	}
+	t.Run("signature covers version", func(t *testing.T) {
+		var envelope CommandEnvelope
+		if err := json.Unmarshal(sealed, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		message := SignedMessage(envelope.Version+1, envelope.Algorithm, envelope.KeyID, envelope.Payload)
+		if err := signer.Verify(envelope.KeyID, envelope.Algorithm, message, envelope.Signature); err == nil {
+			t.Error("signature verified for another envelope version")
+		}
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var envelope CommandEnvelope
+			if err := json.Unmarshal(sealed, &envelope); err != nil {
+				t.Fatal(err)
+			}
+			if tt.modify != nil {
+				tt.modify(&envelope)
+			}
+			data, err := json.Marshal(envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload, err := OpenEnvelope(data, tt.verifier)
+			if !tt.valid {
+				if err == nil {
+					t.Fatal("modified envelope verified")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(payload, want) {
+				t.Errorf("payload = %s, want %s", payload, want)
+			}
+		})
+	}
+}
@@ -3,36 +3,134 @@ package mqtt
 import (
 	"encoding/json"
 	"log"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 type DeviceTelemetry struct {
-	DeviceID    string  `json:"device_id"`
-	Timestamp   int64   `json:"timestamp"`
-	BatteryLevel float64 `json:"battery_level,omitempty"`
-	Temperature float64 `json:"temperature,omitempty"`
-	Uptime      int64   `json:"uptime,omitempty"`
-	FirmwareVersion string `json:"firmware_version,omitempty"`
-	Status      string  `json:"status"`
+	DeviceID          string   `json:"device_id"`
+	Timestamp         int64    `json:"timestamp"`
+	BatteryLevel      *float64 `json:"battery_level,omitempty"`
+	Temperature       *float64 `json:"temperature,omitempty"`
+	Uptime            *int64   `json:"uptime,omitempty"`
+	FirmwareVersion   string   `json:"firmware_version,omitempty"`
+	BootloaderVersion string   `json:"bootloader_version,omitempty"`
+	Status            string   `json:"status"`
 }
 
+// UpdateCommand tells a device to install a firmware image. It is published
+// inside a signed CommandEnvelope. A device acts on a command ID at most once
+// and ignores commands past ExpiresAt; a resent command keeps its ID. When
+// the image was signed by a build key, Signature carries that detached
+// signature so the device can verify the image itself before installing.
 type UpdateCommand struct {
-	DeviceID    string `json:"device_id"`
-	FirmwareURL string `json:"firmware_url"`
-	Version     string `json:"version"`
-	Checksum    string `json:"checksum"`
+	DeviceID           string    `json:"device_id"`
+	CommandID          string    `json:"command_id"`
+	ReleaseID          string    `json:"release_id"`
+	Action             string    `json:"action"`
+	IssuedAt           time.Time `json:"issued_at"`
+	ExpiresAt          time.Time `json:"expires_at"`
+	FirmwareURL        string    `json:"firmware_url"`
+	Version            string    `json:"version"`
+	FileSize           int64     `json:"file_size"`
+	HashAlgorithm      string    `json:"hash_algorithm"`
+	Checksum           string    `json:"checksum"`
+	Signature          []byte    `json:"signature,omitempty"`
+	SignatureAlgorithm string    `json:"signature_algorithm,omitempty"`
+	SignatureKeyID     string    `json:"signature_key_id,omitempty"`
+	// Delta, when set, is a patch from the device's current image to this
+	// one. A device whose image matches BaseChecksum may download and apply
+	// it instead of FirmwareURL, and must check the result against
+	// ResultChecksum before installing; the signature covers the result.
+	Delta *DeltaUpdate `json:"delta,omitempty"`
+	// Bundle is set for firmware made of several artifacts. FirmwareURL,
+	// FileSize, Checksum and Signature then describe the bundle's manifest.
+	Bundle *BundleUpdate `json:"bundle,omitempty"`
+}
+
+type DeltaUpdate struct {
+	URL            string `json:"url"`
+	Algorithm      string `json:"algorithm"`
+	FileSize       int64  `json:"file_size"`
+	HashAlgorithm  string `json:"hash_algorithm"`
+	Checksum       string `json:"checksum"`
+	BaseVersion    string `json:"base_version"`
+	BaseChecksum   string `json:"base_checksum"`
+	ResultChecksum string `json:"result_checksum"`
+}
+
+// BundleUpdate lists the artifacts of a bundle, which a device installs in
+// ascending InstallOrder. Each artifact's checksum is also pinned by the
+// manifest, which the device fetches from FirmwareURL and checks first.
+type BundleUpdate struct {
+	Artifacts []ArtifactUpdate `json:"artifacts"`
+}
+
+type ArtifactUpdate struct {
+	Name          string `json:"name"`
+	Type          string `json:"type,omitempty"`
+	InstallOrder  int    `json:"install_order"`
+	URL           string `json:"url"`
+	FileSize      int64  `json:"file_size"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Checksum      string `json:"checksum"`
 }
 
 type UpdateStatus struct {
-	DeviceID string `json:"device_id"`
-	Status   string `json:"status"`
-	Progress int    `json:"progress"`
-	Error    string `json:"error,omitempty"`
+	DeviceID  string `json:"device_id"`
+	CommandID string `json:"command_id,omitempty"`
+	Status    string `json:"status"`
+	Progress  int    `json:"progress"`
+	Error     string `json:"error,omitempty"`
+}
+
+// RollbackCommand tells a device to restore the version it ran before a
+// release. It is published inside a signed CommandEnvelope like UpdateCommand.
+type RollbackCommand struct {
+	DeviceID           string        `json:"device_id"`
+	CommandID          string        `json:"command_id"`
+	ReleaseID          string        `json:"release_id"`
+	Action             string        `json:"action"`
+	IssuedAt           time.Time     `json:"issued_at"`
+	ExpiresAt          time.Time     `json:"expires_at"`
+	Version            string        `json:"version,omitempty"`
+	FirmwareURL        string        `json:"firmware_url,omitempty"`
+	FileSize           int64         `json:"file_size,omitempty"`
+	HashAlgorithm      string        `json:"hash_algorithm,omitempty"`
+	Checksum           string        `json:"checksum,omitempty"`
+	Signature          []byte        `json:"signature,omitempty"`
+	SignatureAlgorithm string        `json:"signature_algorithm,omitempty"`
+	SignatureKeyID     string        `json:"signature_key_id,omitempty"`
+	Bundle             *BundleUpdate `json:"bundle,omitempty"`
+}
+
+type RollbackStatus struct {
+	DeviceID  string `json:"device_id"`
+	ReleaseID string `json:"release_id"`
+	Status    string `json:"status"`
+	Version   string `json:"version,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// AlertYankedFirmware is the type of alert raised for devices that still
+// report running yanked firmware.
+const AlertYankedFirmware = "yanked_firmware_in_use"
+
+// FirmwareAlert tells operators about devices running firmware they should
+// not. Alerts are published unsigned on aura/alerts/firmware.
+type FirmwareAlert struct {
+	Type       string    `json:"type"`
+	FirmwareID string    `json:"firmware_id"`
+	Version    string    `json:"version"`
+	Reason     string    `json:"reason,omitempty"`
+	DeviceIDs  []string  `json:"device_ids"`
+	Timestamp  time.Time `json:"timestamp"`
 }
 
 type TelemetryHandler func(telemetry *DeviceTelemetry)
 type UpdateStatusHandler func(status *UpdateStatus)
+type RollbackStatusHandler func(status *RollbackStatus)
 
 func (c *Client) SubscribeToTelemetry(handler TelemetryHandler) error {
 	topic := "aura/devices/+/telemetry"
@@ -60,15 +158,38 @@ func (c *Client) SubscribeToUpdateStatus(handler UpdateStatusHandler) error {
 
 func (c *Client) PublishUpdateCommand(deviceID string, cmd *UpdateCommand) error {
 	topic := "aura/devices/" + deviceID + "/update/command"
-	payload, err := json.Marshal(cmd)
+	payload, err := c.seal(cmd)
 	if err != nil {
 		return err
 	}
 	return c.Publish(topic, payload)
 }
 
-func (c *Client) PublishRollbackCommand(deviceID string) error {
+func (c *Client) SubscribeToRollbackStatus(handler RollbackStatusHandler) error {
+	topic := "aura/devices/+/update/rollback/status"
+	return c.Subscribe(topic, func(client mqtt.Client, msg mqtt.Message) {
+		var status RollbackStatus
+		if err := json.Unmarshal(msg.Payload(), &status); err != nil {
+			log.Printf("Error parsing rollback status: %v", err)
+			return
+		}
+		handler(&status)
+	})
+}
+
+func (c *Client) PublishRollbackCommand(deviceID string, cmd *RollbackCommand) error {
 	topic := "aura/devices/" + deviceID + "/update/rollback"
-	payload := []byte(`{"action":"rollback"}`)
+	payload, err := c.seal(cmd)
+	if err != nil {
+		return err
+	}
 	return c.Publish(topic, payload)
 }
+
+func (c *Client) PublishFirmwareAlert(alert *FirmwareAlert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	return c.Publish("aura/alerts/firmware", payload)
+}
@@ -0,0 +1,122 @@
+package ota
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
+
+// CanaryPolicy controls which devices receive a release first.
+type CanaryPolicy struct {
+	Size            int
+	DogfoodDevices  []string
+	MinBatteryLevel float64
+	OfflineAfter    time.Duration
+}
+
+// SelectCanaryDevices picks the canary wave for a release from the devices
+// that could be sent the target now, so skipped and deferred devices do not
+// take up canary slots. Dogfood devices always go first; the remaining slots
+// are spread across hardware model and region strata, ordered within each
+// stratum by a hash of the release and device IDs so the choice is stable for
+// a release but differs between them.
+func SelectCanaryDevices(releaseID string, devices []database.Device, target *database.Firmware,
+	catalog map[string]*database.Firmware, policy CanaryPolicy, now time.Time) []database.Device {
+	dogfood := make(map[string]bool, len(policy.DogfoodDevices))
+	for _, id := range policy.DogfoodDevices {
+		dogfood[id] = true
+	}
+
+	var selected []database.Device
+	strata := make(map[string][]database.Device)
+	for _, device := range devices {
+		if !isEligible(device, policy, now) {
+			continue
+		}
+		plan := planDispatch(device, target, catalog, policy, false, now)
+		if plan.Status != rollout.DeviceSent && plan.Status != rollout.DeviceStepping {
+			continue
+		}
+		if dogfood[device.ID] {
+			selected = append(selected, device)
+			continue
+		}
+		key := stratumKey(device)
+		strata[key] = append(strata[key], device)
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return canaryRank(releaseID, selected[i].ID) < canaryRank(releaseID, selected[j].ID)
+	})
+
+	keys := make([]string, 0, len(strata))
+	for key, members := range strata {
+		sort.Slice(members, func(i, j int) bool {
+			return canaryRank(releaseID, members[i].ID) < canaryRank(releaseID, members[j].ID)
+		})
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	remaining := policy.Size
+	for round := 0; remaining > 0; round++ {
+		picked := false
+		for _, key := range keys {
+			if remaining == 0 {
+				break
+			}
+			if round < len(strata[key]) {
+				selected = append(selected, strata[key][round])
+				remaining--
+				picked = true
+			}
+		}
+		if !picked {
+			break
+		}
+	}
+
+	return selected
+}
+
+func isEligible(device database.Device, policy CanaryPolicy, now time.Time) bool {
+	if offlineReason(device, policy, now) != "" {
+		return false
+	}
+	if device.BatteryLevel != nil && *device.BatteryLevel < policy.MinBatteryLevel {
+		return false
+	}
+	return true
+}
+
+// offlineReason explains why a device is considered offline, or returns an
+// empty string if it is not.
+func offlineReason(device database.Device, policy CanaryPolicy, now time.Time) string {
+	if device.Status != nil && *device.Status == "offline" {
+		return "device reports offline"
+	}
+	if policy.OfflineAfter > 0 && device.LastSeenAt != nil && now.Sub(*device.LastSeenAt) > policy.OfflineAfter {
+		return "device not seen since " + device.LastSeenAt.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+func stratumKey(device database.Device) string {
+	model, region := "unknown", "unknown"
+	if device.HardwareModel != nil && *device.HardwareModel != "" {
+		model = *device.HardwareModel
+	}
+	if device.Region != nil && *device.Region != "" {
+		region = *device.Region
+	}
+	return model + "/" + region
+}
+
+func canaryRank(releaseID, deviceID string) uint64 {
+	sum := sha256.Sum256([]byte(releaseID + ":" + deviceID))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,130 @@
+package ota
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+	"github.com/10xdev4u-alt/aura/pkg/version"
+)
+
+// UpdatePlan is the outcome of checking a device against a release target.
+// Firmware is the image to send, which may be an intermediate step on the
+// target's upgrade path rather than the target itself.
+type UpdatePlan struct {
+	Status   string
+	Firmware *database.Firmware
+	Reason   string
+}
+
+// PlanUpdate decides whether a device can take the target firmware now, must
+// first be stepped through an intermediate version, should be retried later,
+// or can never take it.
+func PlanUpdate(device database.Device, target *database.Firmware, catalog map[string]*database.Firmware) UpdatePlan {
+	if reason := hardwareMismatch(device, target); reason != "" {
+		return UpdatePlan{Status: rollout.DeviceSkipped, Reason: reason}
+	}
+
+	current := ""
+	if device.FirmwareVersion != nil {
+		current = *device.FirmwareVersion
+	}
+	if current == target.Version {
+		return UpdatePlan{Status: rollout.DeviceSkipped, Reason: "already on version " + target.Version}
+	}
+	// A device running a newer version is never downgraded by a release.
+	if cmp, err := version.Compare(current, target.Version); err == nil && cmp >= 0 {
+		if cmp == 0 {
+			return UpdatePlan{Status: rollout.DeviceSkipped, Reason: "already on version " + target.Version}
+		}
+		return UpdatePlan{Status: rollout.DeviceSkipped, Reason: "already on a newer version " + current}
+	}
+
+	if target.RequiredBootloader != nil && *target.RequiredBootloader != "" {
+		if device.BootloaderVersion == nil {
+			return UpdatePlan{Status: rollout.DeviceDeferred, Reason: "bootloader version unknown"}
+		}
+		cmp, err := version.Compare(*device.BootloaderVersion, *target.RequiredBootloader)
+		if err != nil {
+			return UpdatePlan{Status: rollout.DeviceDeferred, Reason: fmt.Sprintf("cannot compare bootloader version: %v", err)}
+		}
+		if cmp < 0 {
+			return UpdatePlan{Status: rollout.DeviceDeferred, Reason: fmt.Sprintf("bootloader %s is older than required %s",
+				*device.BootloaderVersion, *target.RequiredBootloader)}
+		}
+	}
+
+	needsSourceCheck := len(target.UpgradePath) > 0 || (target.MinSourceVersion != nil && *target.MinSourceVersion != "")
+	if !needsSourceCheck {
+		return UpdatePlan{Status: rollout.DeviceSent, Firmware: target}
+	}
+	if current == "" {
+		return UpdatePlan{Status: rollout.DeviceDeferred, Reason: "current firmware version unknown"}
+	}
+
+	for _, step := range target.UpgradePath {
+		cmp, err := version.Compare(current, step)
+		if err != nil {
+			return UpdatePlan{Status: rollout.DeviceDeferred, Reason: fmt.Sprintf("cannot compare firmware version: %v", err)}
+		}
+		if cmp >= 0 {
+			continue
+		}
+		stepFirmware, ok := catalog[step]
+		if !ok {
+			return UpdatePlan{Status: rollout.DeviceDeferred, Reason: "intermediate version " + step + " is not available"}
+		}
+		if stepFirmware.State == database.FirmwareYanked {
+			return UpdatePlan{Status: rollout.DeviceDeferred, Reason: "intermediate version " + step + " is yanked"}
+		}
+		if reason := hardwareMismatch(device, stepFirmware); reason != "" {
+			return UpdatePlan{Status: rollout.DeviceSkipped, Reason: "intermediate version " + step + ": " + reason}
+		}
+		return UpdatePlan{Status: rollout.DeviceStepping, Firmware: stepFirmware, Reason: "stepping through " + step}
+	}
+
+	if target.MinSourceVersion != nil && *target.MinSourceVersion != "" {
+		cmp, err := version.Compare(current, *target.MinSourceVersion)
+		if err != nil {
+			return UpdatePlan{Status: rollout.DeviceDeferred, Reason: fmt.Sprintf("cannot compare firmware version: %v", err)}
+		}
+		if cmp < 0 {
+			return UpdatePlan{Status: rollout.DeviceSkipped, Reason: fmt.Sprintf("firmware %s is older than minimum source version %s",
+				current, *target.MinSourceVersion)}
+		}
+	}
+
+	return UpdatePlan{Status: rollout.DeviceSent, Firmware: target}
+}
+
+// planDispatch is PlanUpdate for a device about to be sent a command: an
+// update a device could take is deferred while the device is offline, unless
+// the device was already sent it. A device that goes quiet after receiving an
+// update is retried so that it eventually counts as timed out.
+func planDispatch(device database.Device, target *database.Firmware, catalog map[string]*database.Firmware,
+	policy CanaryPolicy, attempted bool, now time.Time) UpdatePlan {
+	plan := PlanUpdate(device, target, catalog)
+	if attempted || (plan.Status != rollout.DeviceSent && plan.Status != rollout.DeviceStepping) {
+		return plan
+	}
+	if reason := offlineReason(device, policy, now); reason != "" {
+		return UpdatePlan{Status: rollout.DeviceDeferred, Reason: reason}
+	}
+	return plan
+}
+
+func hardwareMismatch(device database.Device, firmware *database.Firmware) string {
+	if len(firmware.HardwareModels) == 0 {
+		return ""
+	}
+	if device.HardwareModel == nil {
+		return "hardware model unknown"
+	}
+	for _, model := range firmware.HardwareModels {
+		if model == *device.HardwareModel {
+			return ""
+		}
+	}
+	return "hardware model " + *device.HardwareModel + " is not supported"
+}
@@ -0,0 +1,83 @@
+package ota
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
+
+func TestPlanUpdate(t *testing.T) {
+	catalog := map[string]*database.Firmware{
+		"1.5.0": {ID: "fw-1.5.0", Version: "1.5.0", State: database.FirmwareActive},
+		"1.8.0": {ID: "fw-1.8.0", Version: "1.8.0", State: database.FirmwareYanked},
+	}
+
+	tests := []struct {
+		name       string
+		current    string
+		bootloader string
+		model      string
+		target     database.Firmware
+		status     string
+		firmware   string
+		reason     string
+	}{
+		{name: "older", current: "1.0.0", status: rollout.DeviceSent, firmware: "2.0.0"},
+		{name: "same", current: "2.0.0", status: rollout.DeviceSkipped, reason: "already on version 2.0.0"},
+		{name: "same with build metadata", current: "2.0.0+build.7", status: rollout.DeviceSkipped,
+			reason: "already on version 2.0.0"},
+		{name: "newer", current: "2.1.0", status: rollout.DeviceSkipped, reason: "already on a newer version 2.1.0"},
+		{name: "newer prerelease", current: "2.0.0-rc.10", target: database.Firmware{Version: "2.0.0-rc.2"},
+			status: rollout.DeviceSkipped, reason: "already on a newer version"},
+		{name: "prerelease of target", current: "2.0.0-rc.1", status: rollout.DeviceSent, firmware: "2.0.0"},
+		{name: "unparsed current version", current: "custom", status: rollout.DeviceSent, firmware: "2.0.0"},
+		{name: "other hardware", current: "1.0.0", model: "aura-s1",
+			target: database.Firmware{HardwareModels: []string{"aura-s2"}}, status: rollout.DeviceSkipped},
+		{name: "old bootloader", current: "1.0.0", bootloader: "0.9.0",
+			target: database.Firmware{RequiredBootloader: optional("1.0.0")}, status: rollout.DeviceDeferred,
+			reason: "bootloader 0.9.0 is older than required 1.0.0"},
+		{name: "prerelease bootloader", current: "1.0.0", bootloader: "1.0.0-rc.10",
+			target: database.Firmware{RequiredBootloader: optional("1.0.0-rc.2")}, status: rollout.DeviceSent,
+			firmware: "2.0.0"},
+		{name: "below min source", current: "1.0.0", target: database.Firmware{MinSourceVersion: optional("1.2.0")},
+			status: rollout.DeviceSkipped, reason: "firmware 1.0.0 is older than minimum source version 1.2.0"},
+		{name: "upgrade step", current: "1.0.0", target: database.Firmware{UpgradePath: []string{"1.5.0"}},
+			status: rollout.DeviceStepping, firmware: "1.5.0"},
+		{name: "past upgrade step", current: "1.5.0", target: database.Firmware{UpgradePath: []string{"1.5.0"}},
+			status: rollout.DeviceSent, firmware: "2.0.0"},
+		{name: "yanked upgrade step", current: "1.5.0", target: database.Firmware{UpgradePath: []string{"1.5.0", "1.8.0"}},
+			status: rollout.DeviceDeferred, reason: "intermediate version 1.8.0 is yanked"},
+		{name: "missing upgrade step", current: "1.0.0", target: database.Firmware{UpgradePath: []string{"1.2.0"}},
+			status: rollout.DeviceDeferred, reason: "intermediate version 1.2.0 is not available"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			target.ID = "fw-target"
+			if target.Version == "" {
+				target.Version = "2.0.0"
+			}
+			device := database.Device{ID: "device-1", FirmwareVersion: optional(tt.current)}
+			if tt.bootloader != "" {
+				device.BootloaderVersion = optional(tt.bootloader)
+			}
+			if tt.model != "" {
+				device.HardwareModel = optional(tt.model)
+			}
+
+			plan := PlanUpdate(device, &target, catalog)
+			if plan.Status != tt.status || !strings.HasPrefix(plan.Reason, tt.reason) {
+				t.Errorf("got %s (%s), want %s (%s...)", plan.Status, plan.Reason, tt.status, tt.reason)
+			}
+			version := ""
+			if plan.Firmware != nil {
+				version = plan.Firmware.Version
+			}
+			if version != tt.firmware {
+				t.Errorf("plan sends %q, want %q", version, tt.firmware)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package ota
+
+import (
+	"log"
+	"strings"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
+
+const (
+	// ConflictQueue holds a release that targets devices of an earlier open
+	// release until the earlier release finishes.
+	ConflictQueue = "queue"
+	// ConflictReject refuses a release that targets devices of an open
+	// release.
+	ConflictReject = "reject"
+)
+
+// ReleaseConflict is an open release that targets some of the same devices
+// as another release.
+type ReleaseConflict struct {
+	ReleaseID string `json:"release_id"`
+	Status    string `json:"status"`
+	Version   string `json:"version"`
+	Devices   int    `json:"devices"`
+}
+
+// FindConflicts returns the releases whose firmware could be sent to any of
+// the devices that target could be sent to. Two images conflict on a device
+// when the hardware model of the device is supported by both.
+func FindConflicts(target *database.Firmware, releases []database.Release, firmwares []database.Firmware,
+	devices []database.Device) []ReleaseConflict {
+	byID := make(map[string]*database.Firmware, len(firmwares))
+	for i := range firmwares {
+		byID[firmwares[i].ID] = &firmwares[i]
+	}
+
+	conflicts := []ReleaseConflict{}
+	for _, release := range releases {
+		other, ok := byID[release.FirmwareID]
+		if !ok {
+			continue
+		}
+		shared := 0
+		for _, device := range devices {
+			if hardwareMismatch(device, target) == "" && hardwareMismatch(device, other) == "" {
+				shared++
+			}
+		}
+		if shared > 0 {
+			conflicts = append(conflicts, ReleaseConflict{
+				ReleaseID: release.ID,
+				Status:    release.Status,
+				Version:   other.Version,
+				Devices:   shared,
+			})
+		}
+	}
+	return conflicts
+}
+
+// earlierConflicts returns the open releases that must finish before a
+// pending release can start: releases that have started, and pending
+// releases created before it that are due, so overlapping releases start in
+// the order they were created.
+func (o *Orchestrator) earlierConflicts(release database.Release, target *database.Firmware) ([]ReleaseConflict, error) {
+	open, err := o.db.ListReleasesByStatus(rollout.OpenStatuses)
+	if err != nil {
+		return nil, err
+	}
+
+	now := o.clock.Now()
+	var ahead []database.Release
+	earlier := true
+	for _, other := range open {
+		if other.ID == release.ID {
+			earlier = false
+			continue
+		}
+		if other.Status == rollout.StatusPending {
+			if !earlier || (other.ScheduledAt != nil && now.Before(*other.ScheduledAt)) {
+				continue
+			}
+		}
+		ahead = append(ahead, other)
+	}
+	if len(ahead) == 0 {
+		return nil, nil
+	}
+
+	firmwares, err := o.db.ListFirmware()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := o.db.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	return FindConflicts(target, ahead, firmwares, devices), nil
+}
+
+// resolveConflicts reports whether a pending release may start. A release
+// that overlaps an earlier one stays pending under ConflictQueue and is
+// aborted under ConflictReject.
+func (o *Orchestrator) resolveConflicts(release database.Release, target *database.Firmware) bool {
+	conflicts, err := o.earlierConflicts(release, target)
+	if err != nil {
+		log.Printf("Error checking release %s for conflicts: %v", release.ID, err)
+		return false
+	}
+	if len(conflicts) == 0 {
+		return true
+	}
+
+	ids := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		ids[i] = conflict.ReleaseID
+	}
+	holders := strings.Join(ids, ", ")
+
+	if o.conflictPolicy == ConflictReject {
+		log.Printf("Release %s targets devices of release %s, rejecting", release.ID, holders)
+		o.applyAction(release.ID, rollout.ActionAbort, "targets devices of open release "+holders)
+		return false
+	}
+	log.Printf("Release %s queued behind release %s", release.ID, holders)
+	return false
+}
@@ -0,0 +1,112 @@
+package ota
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/mqtt"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
+
+// DeltaPair is an image a release would send and the image a device runs
+// now, from which a delta can be built.
+type DeltaPair struct {
+	Base   *database.Firmware
+	Target *database.Firmware
+}
+
+// DeltaPairs returns the distinct pairs of current and next image for the
+// devices a release would update, including intermediate upgrade steps.
+// Devices running a version that is not in the catalog are left out, since
+// there is no image to diff against, as are bundles, whose image is only a
+// manifest.
+func DeltaPairs(target *database.Firmware, catalog map[string]*database.Firmware, devices []database.Device) []DeltaPair {
+	seen := make(map[[2]string]bool)
+	var pairs []DeltaPair
+	for _, device := range devices {
+		if device.FirmwareVersion == nil {
+			continue
+		}
+		base, ok := catalog[*device.FirmwareVersion]
+		if !ok || base.Bundle {
+			continue
+		}
+		plan := PlanUpdate(device, target, catalog)
+		if plan.Status != rollout.DeviceSent && plan.Status != rollout.DeviceStepping {
+			continue
+		}
+		key := [2]string{base.ID, plan.Firmware.ID}
+		if base.ID == plan.Firmware.ID || plan.Firmware.Bundle || seen[key] {
+			continue
+		}
+		seen[key] = true
+		pairs = append(pairs, DeltaPair{Base: base, Target: plan.Firmware})
+	}
+	return pairs
+}
+
+// selectDelta returns the delta from the image a device runs to the one it
+// is being sent, if one has been built. Devices that failed a delta in the
+// release are sent full images from then on.
+func (o *Orchestrator) selectDelta(device database.Device, prior *database.ReleaseDevice, firmware *database.Firmware,
+	catalog map[string]*database.Firmware) *database.FirmwareDelta {
+	if !o.deltaUpdates || device.FirmwareVersion == nil || (prior != nil && prior.FullImage) {
+		return nil
+	}
+	base, ok := catalog[*device.FirmwareVersion]
+	if !ok || base.ID == firmware.ID {
+		return nil
+	}
+	found, err := o.db.GetFirmwareDelta(base.ID, firmware.ID)
+	if err != nil {
+		if !errors.Is(err, database.ErrFirmwareDeltaNotFound) {
+			log.Printf("Error getting delta from %s to %s: %v", base.Version, firmware.Version, err)
+		}
+		return nil
+	}
+	return found
+}
+
+func (o *Orchestrator) deltaUpdate(found *database.FirmwareDelta, deviceID string, now time.Time) *mqtt.DeltaUpdate {
+	deltaURL := o.downloadURL + "/deltas/" + found.ID
+	if o.urlSigner.Enabled() {
+		expires := now.Add(o.commandTTL + o.timeouts.Download)
+		deltaURL += "?" + o.urlSigner.Sign(DeltaResource(found.ID), deviceID, expires).Encode()
+	}
+	return &mqtt.DeltaUpdate{
+		URL:            deltaURL,
+		Algorithm:      found.Algorithm,
+		FileSize:       found.FileSize,
+		HashAlgorithm:  mqtt.HashSHA256,
+		Checksum:       found.Checksum,
+		BaseVersion:    found.BaseVersion,
+		BaseChecksum:   found.BaseChecksum,
+		ResultChecksum: found.TargetChecksum,
+	}
+}
+
+// DeltaResource is what a signed delta URL is issued for, kept apart from
+// firmware IDs so a URL for one cannot fetch the other.
+func DeltaResource(deltaID string) string {
+	return "delta/" + deltaID
+}
+
+// fallBackToFullImage resends the full image to a device whose delta update
+// failed. It reports false if the failed command was not a delta.
+func (o *Orchestrator) fallBackToFullImage(status *mqtt.UpdateStatus) bool {
+	reason := "delta update failed, resending full image"
+	if status.Error != "" {
+		reason = "delta update failed (" + status.Error + "), resending full image"
+	}
+	fellBack, err := o.db.FallBackToFullImage(status.DeviceID, status.CommandID, reason, o.clock.Now())
+	if err != nil {
+		log.Printf("Error falling back to full image for device %s: %v", status.DeviceID, err)
+		return false
+	}
+	if fellBack {
+		log.Printf("Device %s: %s", status.DeviceID, reason)
+	}
+	return fellBack
+}
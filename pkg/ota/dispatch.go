@@ -0,0 +1,276 @@
+package ota
+
+import (
+	"errors"
+	"log"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/mqtt"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
+
+func (o *Orchestrator) loadTarget(release database.Release) (*database.Firmware, map[string]*database.Firmware, error) {
+	target, err := o.db.GetFirmwareByID(release.FirmwareID)
+	if err != nil {
+		return nil, nil, err
+	}
+	catalog, err := o.firmwareCatalog()
+	if err != nil {
+		return nil, nil, err
+	}
+	return target, catalog, nil
+}
+
+func (o *Orchestrator) firmwareCatalog() (map[string]*database.Firmware, error) {
+	firmwares, err := o.db.ListFirmware()
+	if err != nil {
+		return nil, err
+	}
+	return Catalog(firmwares), nil
+}
+
+// Catalog indexes firmware by version for update planning. Firmware whose
+// image has been deleted is left out.
+func Catalog(firmwares []database.Firmware) map[string]*database.Firmware {
+	catalog := make(map[string]*database.Firmware, len(firmwares))
+	for i := range firmwares {
+		if firmwares[i].PurgedAt != nil {
+			continue
+		}
+		catalog[firmwares[i].Version] = &firmwares[i]
+	}
+	return catalog
+}
+
+// dispatchUpdate plans and sends the release to a device. prior is the
+// device's existing row in the release, if any, and carries the command ID
+// and attempt count forward to a resend.
+func (o *Orchestrator) dispatchUpdate(releaseID, wave string, device database.Device, prior *database.ReleaseDevice,
+	target *database.Firmware, catalog map[string]*database.Firmware) bool {
+	now := o.clock.Now()
+	attempted := prior != nil && prior.Attempts > 0
+	plan := planDispatch(device, target, catalog, o.canary, attempted, now)
+
+	update := database.ReleaseDeviceUpdate{
+		ReleaseID: releaseID,
+		DeviceID:  device.ID,
+		Wave:      wave,
+		Status:    plan.Status,
+		Reason:    plan.Reason,
+	}
+	if plan.Firmware != nil {
+		update.FirmwareID = plan.Firmware.ID
+	}
+	if device.FirmwareVersion != nil {
+		update.PreviousVersion = *device.FirmwareVersion
+	}
+	if prior != nil {
+		if prior.CommandID != nil {
+			update.CommandID = *prior.CommandID
+		}
+		update.Attempts = prior.Attempts
+	}
+
+	sendable := plan.Status == rollout.DeviceSent || plan.Status == rollout.DeviceStepping
+	if sendable {
+		group := ""
+		if device.DeviceGroup != nil {
+			group = *device.DeviceGroup
+		}
+		open, opensAt := rollout.NextDispatch(o.windows, group, rollout.DeviceLocation(device.Timezone), now)
+		if !open {
+			sendable = false
+			update.Status = rollout.DeviceScheduled
+			update.Reason = "outside maintenance window"
+			if !opensAt.IsZero() {
+				update.NextAttemptAt = &opensAt
+			}
+		}
+	}
+
+	if sendable && !o.dispatcher.Acquire(releaseID) {
+		sendable = false
+		update.Status = rollout.DeviceQueued
+		update.Reason = "dispatch limit reached"
+	}
+
+	var found *database.FirmwareDelta
+	deferred := update
+	if sendable {
+		found = o.selectDelta(device, prior, plan.Firmware, catalog)
+		if found != nil {
+			update.DeltaID = found.ID
+		}
+		commandID := commandIDFor(prior, plan.Firmware)
+		if commandID != update.CommandID {
+			update.CommandID = commandID
+			update.Attempts = 0
+		}
+		update.Attempts++
+		update.TimeoutAt = o.deadline(update.Status)
+		update.BaselineBattery = device.BatteryLevel
+		update.BaselineTemp = device.Temperature
+	}
+
+	if err := o.db.UpsertReleaseDevice(update); err != nil {
+		log.Printf("Error recording release %s for device %s: %v", releaseID, device.ID, err)
+		if !sendable {
+			return false
+		}
+		o.dispatcher.Release(releaseID)
+		var busy *database.DeviceBusyError
+		if !errors.As(err, &busy) {
+			return false
+		}
+		deferred.Status = rollout.DeviceDeferred
+		deferred.Reason = "held by release " + busy.ReleaseID
+		if err := o.db.UpsertReleaseDevice(deferred); err != nil {
+			log.Printf("Error recording release %s for device %s: %v", releaseID, device.ID, err)
+		}
+		return false
+	}
+
+	if !sendable {
+		log.Printf("Release %s %s for device %s: %s", releaseID, update.Status, device.ID, update.Reason)
+		return false
+	}
+
+	signature := plan.Firmware.BuildSignature()
+	cmd := &mqtt.UpdateCommand{
+		DeviceID:           device.ID,
+		CommandID:          update.CommandID,
+		ReleaseID:          releaseID,
+		Action:             "update",
+		IssuedAt:           now,
+		ExpiresAt:          now.Add(o.commandTTL),
+		FirmwareURL:        o.firmwareURL(plan.Firmware, device.ID, now),
+		Version:            plan.Firmware.Version,
+		FileSize:           plan.Firmware.FileSize,
+		HashAlgorithm:      mqtt.HashSHA256,
+		Checksum:           plan.Firmware.Checksum,
+		Signature:          signature.Signature,
+		SignatureAlgorithm: signature.Algorithm,
+		SignatureKeyID:     signature.KeyID,
+	}
+	if found != nil {
+		cmd.Delta = o.deltaUpdate(found, device.ID, now)
+	}
+	if plan.Firmware.Bundle {
+		bundle, err := o.bundleUpdate(plan.Firmware, device.ID, now)
+		if err != nil {
+			log.Printf("Error listing artifacts of firmware %s: %v", plan.Firmware.Version, err)
+			o.unsend(update, "failed to list bundle artifacts")
+			return false
+		}
+		cmd.Bundle = bundle
+	}
+	if err := o.mqttClient.PublishUpdateCommand(device.ID, cmd); err != nil {
+		log.Printf("Error sending update command to device %s: %v", device.ID, err)
+		o.unsend(update, "failed to publish update command")
+		return false
+	}
+	return true
+}
+
+// unsend undoes recording an update as sent when its command could not be
+// sent: the device is retried after a backoff and the attempt is not counted.
+func (o *Orchestrator) unsend(update database.ReleaseDeviceUpdate, reason string) {
+	o.dispatcher.Release(update.ReleaseID)
+
+	update.Attempts--
+	retryAt := o.clock.Now().Add(o.retry.Backoff(max(update.Attempts, 1)))
+	update.Status = rollout.DeviceRetrying
+	update.Reason = reason
+	update.NextAttemptAt = &retryAt
+	update.TimeoutAt = nil
+	if err := o.db.UpsertReleaseDevice(update); err != nil {
+		log.Printf("Error recording release %s for device %s: %v", update.ReleaseID, update.DeviceID, err)
+	}
+}
+
+// advancePendingDevices re-plans devices that were deferred, devices whose
+// maintenance window has opened or retry backoff has elapsed, and devices
+// that have finished an intermediate step of the upgrade path.
+func (o *Orchestrator) advancePendingDevices(releaseID string, target *database.Firmware, catalog map[string]*database.Firmware) {
+	releaseDevices, err := o.db.ListReleaseDevices(releaseID)
+	if err != nil {
+		log.Printf("Error listing devices for release %s: %v", releaseID, err)
+		return
+	}
+
+	now := o.clock.Now()
+	var pending []database.ReleaseDevice
+	for _, rd := range releaseDevices {
+		switch rd.Status {
+		case rollout.DeviceQueued, rollout.DeviceDeferred, rollout.DeviceStepping:
+			pending = append(pending, rd)
+		case rollout.DeviceScheduled, rollout.DeviceRetrying:
+			if rd.NextAttemptAt == nil || !now.Before(*rd.NextAttemptAt) {
+				pending = append(pending, rd)
+			}
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	for _, rd := range pending {
+		if o.stopping() || !o.dispatcher.HasCapacity(releaseID) {
+			return
+		}
+		device, err := o.db.GetDeviceByID(rd.DeviceID)
+		if err != nil {
+			log.Printf("Error getting device %s: %v", rd.DeviceID, err)
+			continue
+		}
+		if rd.Status == rollout.DeviceStepping && !reachedStep(device, rd, catalog) {
+			continue
+		}
+		o.dispatchUpdate(releaseID, "", *device, &rd, target, catalog)
+	}
+}
+
+func reachedStep(device *database.Device, rd database.ReleaseDevice, catalog map[string]*database.Firmware) bool {
+	if device.FirmwareVersion == nil || rd.FirmwareID == nil {
+		return false
+	}
+	step, ok := catalog[*device.FirmwareVersion]
+	return ok && step.ID == *rd.FirmwareID
+}
+
+// dispatchRemaining sends the release to every device that is not yet part
+// of it. It runs for releases in the production stage.
+func (o *Orchestrator) dispatchRemaining(releaseID string, target *database.Firmware, catalog map[string]*database.Firmware) {
+	releaseDevices, err := o.db.ListReleaseDevices(releaseID)
+	if err != nil {
+		log.Printf("Error listing devices for release %s: %v", releaseID, err)
+		return
+	}
+	known := make(map[string]bool, len(releaseDevices))
+	for _, rd := range releaseDevices {
+		known[rd.DeviceID] = true
+	}
+
+	devices, err := o.db.ListDevices()
+	if err != nil {
+		log.Printf("Error getting devices for release %s: %v", releaseID, err)
+		return
+	}
+
+	dispatched := 0
+	for _, device := range devices {
+		if known[device.ID] {
+			continue
+		}
+		if o.stopping() || !o.dispatcher.HasCapacity(releaseID) {
+			break
+		}
+		if o.dispatchUpdate(releaseID, rollout.StageProduction, device, nil, target, catalog) {
+			dispatched++
+		}
+	}
+
+	if dispatched > 0 {
+		log.Printf("Release %s production wave sent to %d devices", releaseID, dispatched)
+	}
+}
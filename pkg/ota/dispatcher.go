@@ -0,0 +1,158 @@
+package ota
+
+import (
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// DispatchLimits bound how fast update commands go out. A zero value for
+// any limit disables it.
+type DispatchLimits struct {
+	CommandsPerSecond     float64
+	Burst                 int
+	MaxInFlightPerRelease int
+	MaxInFlight           int
+	MaxDownloading        int
+}
+
+// Dispatcher paces update commands with a token bucket and caps how many
+// updates may be in flight per release and across the fleet. In-flight and
+// downloading counts are loaded from the database at the start of each cycle
+// with Reset and then tracked as commands are acquired.
+type Dispatcher struct {
+	limits DispatchLimits
+	clock  Clock
+
+	mu          sync.Mutex
+	tokens      float64
+	refilledAt  time.Time
+	inFlight    map[string]int
+	total       int
+	downloading int
+}
+
+func NewDispatcher(limits DispatchLimits, clock Clock) *Dispatcher {
+	if clock == nil {
+		clock = realClock{}
+	}
+	burst := float64(limits.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &Dispatcher{
+		limits:     limits,
+		clock:      clock,
+		tokens:     burst,
+		refilledAt: clock.Now(),
+		inFlight:   make(map[string]int),
+	}
+}
+
+func (d *Dispatcher) Reset(inFlight map[string]int, downloading int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.inFlight = make(map[string]int, len(inFlight))
+	d.total = 0
+	for releaseID, n := range inFlight {
+		d.inFlight[releaseID] = n
+		d.total += n
+	}
+	d.downloading = downloading
+}
+
+// HasCapacity reports whether another update for the release would stay
+// within the in-flight and download limits.
+func (d *Dispatcher) HasCapacity(releaseID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.hasCapacity(releaseID)
+}
+
+func (d *Dispatcher) hasCapacity(releaseID string) bool {
+	if d.limits.MaxInFlightPerRelease > 0 && d.inFlight[releaseID] >= d.limits.MaxInFlightPerRelease {
+		return false
+	}
+	if d.limits.MaxInFlight > 0 && d.total >= d.limits.MaxInFlight {
+		return false
+	}
+	if d.limits.MaxDownloading > 0 && d.downloading >= d.limits.MaxDownloading {
+		return false
+	}
+	return true
+}
+
+// Acquire reserves an in-flight slot for the release and waits for the rate
+// limiter. It returns false without waiting if no slot is available.
+func (d *Dispatcher) Acquire(releaseID string) bool {
+	d.mu.Lock()
+	if !d.hasCapacity(releaseID) {
+		d.mu.Unlock()
+		return false
+	}
+	d.inFlight[releaseID]++
+	d.total++
+	d.mu.Unlock()
+
+	d.Wait()
+	return true
+}
+
+// Release returns a slot reserved by Acquire whose command was not sent.
+func (d *Dispatcher) Release(releaseID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.inFlight[releaseID] > 0 {
+		d.inFlight[releaseID]--
+		d.total--
+	}
+}
+
+// Wait blocks until the rate limiter allows one more command.
+func (d *Dispatcher) Wait() {
+	if d.limits.CommandsPerSecond <= 0 {
+		return
+	}
+
+	for {
+		d.mu.Lock()
+		d.refill()
+		if d.tokens >= 1 {
+			d.tokens--
+			d.mu.Unlock()
+			return
+		}
+		wait := time.Duration((1 - d.tokens) / d.limits.CommandsPerSecond * float64(time.Second))
+		d.mu.Unlock()
+
+		d.clock.Sleep(wait)
+	}
+}
+
+func (d *Dispatcher) refill() {
+	now := d.clock.Now()
+	elapsed := now.Sub(d.refilledAt).Seconds()
+	d.refilledAt = now
+	if elapsed <= 0 {
+		return
+	}
+
+	burst := float64(d.limits.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	d.tokens += elapsed * d.limits.CommandsPerSecond
+	if d.tokens > burst {
+		d.tokens = burst
+	}
+}
@@ -0,0 +1,107 @@
+package ota
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDispatcherRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   DispatchLimits
+		idle     time.Duration
+		commands int
+		elapsed  time.Duration
+	}{
+		{name: "unlimited", commands: 100},
+		{name: "within burst", limits: DispatchLimits{CommandsPerSecond: 10, Burst: 5}, commands: 5},
+		{name: "beyond burst", limits: DispatchLimits{CommandsPerSecond: 10, Burst: 5}, commands: 8,
+			elapsed: 300 * time.Millisecond},
+		{name: "burst of at least one", limits: DispatchLimits{CommandsPerSecond: 2}, commands: 3,
+			elapsed: time.Second},
+		{name: "refill capped at burst", limits: DispatchLimits{CommandsPerSecond: 1, Burst: 2}, idle: time.Minute,
+			commands: 3, elapsed: time.Second},
+		{name: "partial refill", limits: DispatchLimits{CommandsPerSecond: 4, Burst: 1}, idle: 100 * time.Millisecond,
+			commands: 2, elapsed: 400 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			d := NewDispatcher(tt.limits, clock)
+			if tt.idle > 0 {
+				// Spend the initial burst, then sit idle.
+				for i := 0; i < max(tt.limits.Burst, 1); i++ {
+					d.Wait()
+				}
+				clock.Advance(tt.idle)
+			}
+
+			start := clock.Now()
+			for i := 0; i < tt.commands; i++ {
+				d.Wait()
+			}
+			if elapsed := clock.Now().Sub(start).Round(time.Millisecond); elapsed != tt.elapsed {
+				t.Errorf("%d commands took %s, want %s", tt.commands, elapsed, tt.elapsed)
+			}
+		})
+	}
+}
+
+func TestDispatcherCapacity(t *testing.T) {
+	tests := []struct {
+		name        string
+		limits      DispatchLimits
+		inFlight    map[string]int
+		downloading int
+		acquire     []string
+		want        []bool
+	}{
+		{name: "no limits", acquire: []string{"r1", "r1", "r2"}, want: []bool{true, true, true}},
+		{name: "per release", limits: DispatchLimits{MaxInFlightPerRelease: 2},
+			acquire: []string{"r1", "r1", "r1", "r2"}, want: []bool{true, true, false, true}},
+		{name: "per release after reset", limits: DispatchLimits{MaxInFlightPerRelease: 2},
+			inFlight: map[string]int{"r1": 2}, acquire: []string{"r1", "r2"}, want: []bool{false, true}},
+		{name: "global", limits: DispatchLimits{MaxInFlight: 3}, inFlight: map[string]int{"r1": 2},
+			acquire: []string{"r2", "r2", "r1"}, want: []bool{true, false, false}},
+		{name: "downloading at limit", limits: DispatchLimits{MaxDownloading: 5}, downloading: 5,
+			acquire: []string{"r1"}, want: []bool{false}},
+		{name: "downloading below limit", limits: DispatchLimits{MaxDownloading: 5}, downloading: 4,
+			acquire: []string{"r1", "r1"}, want: []bool{true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDispatcher(tt.limits, newFakeClock())
+			d.Reset(tt.inFlight, tt.downloading)
+
+			var got []bool
+			for _, releaseID := range tt.acquire {
+				got = append(got, d.Acquire(releaseID))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Acquire = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDispatcherRelease(t *testing.T) {
+	d := NewDispatcher(DispatchLimits{MaxInFlightPerRelease: 1, MaxInFlight: 2}, newFakeClock())
+	if !d.Acquire("r1") || !d.Acquire("r2") {
+		t.Fatal("first acquires failed")
+	}
+	if d.HasCapacity("r3") {
+		t.Fatal("capacity beyond max in flight")
+	}
+
+	d.Release("r1")
+	if !d.HasCapacity("r1") || !d.Acquire("r3") {
+		t.Error("released slot was not returned")
+	}
+
+	// Releasing a release with nothing in flight must not free a slot.
+	d.Release("r4")
+	if d.HasCapacity("r4") {
+		t.Error("release without an acquire freed a slot")
+	}
+}
@@ -0,0 +1,759 @@
+package ota
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/mqtt"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
+
+var (
+	_ Store     = (*fakeStore)(nil)
+	_ Publisher = (*fakePublisher)(nil)
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeStore is an in-memory Store that mirrors the semantics of the
+// PostgreSQL queries the orchestrator relies on.
+type fakeStore struct {
+	clock *fakeClock
+
+	mu             sync.Mutex
+	devices        map[string]*database.Device
+	firmware       map[string]*database.Firmware
+	deltas         map[[2]string]*database.FirmwareDelta
+	artifacts      map[string][]database.FirmwareArtifact
+	releases       map[string]*database.Release
+	releaseOrder   []string
+	releaseDevices map[string]*database.ReleaseDevice
+	rowOrder       []string
+	events         []database.ReleaseEvent
+	leases         map[string]*database.Lease
+	changes        chan string
+	nextID         int
+}
+
+func newFakeStore(clock *fakeClock) *fakeStore {
+	return &fakeStore{
+		clock:          clock,
+		devices:        make(map[string]*database.Device),
+		firmware:       make(map[string]*database.Firmware),
+		deltas:         make(map[[2]string]*database.FirmwareDelta),
+		artifacts:      make(map[string][]database.FirmwareArtifact),
+		releases:       make(map[string]*database.Release),
+		releaseDevices: make(map[string]*database.ReleaseDevice),
+		leases:         make(map[string]*database.Lease),
+		changes:        make(chan string),
+	}
+}
+
+func (s *fakeStore) id(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-%03d", prefix, s.nextID)
+}
+
+func (s *fakeStore) addDevice(model, region, version string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	battery := 90.0
+	status := "healthy"
+	device := &database.Device{
+		ID:              s.id("device"),
+		HardwareModel:   &model,
+		Region:          &region,
+		FirmwareVersion: &version,
+		Status:          &status,
+		BatteryLevel:    &battery,
+		LastSeenAt:      &now,
+	}
+	s.devices[device.ID] = device
+	return device.ID
+}
+
+func (s *fakeStore) addFirmware(version string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	firmware := &database.Firmware{
+		ID:       s.id("firmware"),
+		Version:  version,
+		Checksum: "sha256-" + version,
+		State:    database.FirmwareActive,
+	}
+	s.firmware[firmware.ID] = firmware
+	return firmware.ID
+}
+
+func (s *fakeStore) setFirmwareState(firmwareID, state, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.firmware[firmwareID].State = state
+	s.firmware[firmwareID].StateReason = optional(reason)
+}
+
+func (s *fakeStore) restrictFirmware(firmwareID string, models ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.firmware[firmwareID].HardwareModels = models
+}
+
+func (s *fakeStore) setUpgradePath(firmwareID string, steps ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.firmware[firmwareID].UpgradePath = steps
+}
+
+func (s *fakeStore) setBattery(deviceID string, level float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[deviceID].BatteryLevel = &level
+}
+
+// makeBundle turns firmware into a bundle of the named artifacts, installed
+// in the order given.
+func (s *fakeStore) makeBundle(firmwareID string, names ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.firmware[firmwareID].Bundle = true
+	for i, name := range names {
+		s.artifacts[firmwareID] = append(s.artifacts[firmwareID], database.FirmwareArtifact{
+			FirmwareID:   firmwareID,
+			Name:         name,
+			InstallOrder: i + 1,
+			FileSize:     4096,
+			Checksum:     "sha256-" + name,
+		})
+	}
+}
+
+func (s *fakeStore) addDelta(baseID, targetID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	base, target := s.firmware[baseID], s.firmware[targetID]
+	delta := &database.FirmwareDelta{
+		ID:               s.id("delta"),
+		BaseFirmwareID:   baseID,
+		BaseVersion:      base.Version,
+		BaseChecksum:     base.Checksum,
+		TargetFirmwareID: targetID,
+		TargetVersion:    target.Version,
+		TargetChecksum:   target.Checksum,
+		Algorithm:        "bsdiff-zlib",
+		FileSize:         1024,
+		Checksum:         "sha256-delta-" + base.Version + "-" + target.Version,
+	}
+	s.deltas[[2]string{baseID, targetID}] = delta
+	return delta.ID
+}
+
+func (s *fakeStore) signFirmware(firmwareID string, signature []byte, algorithm, keyID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	firmware := s.firmware[firmwareID]
+	firmware.Signature = signature
+	firmware.SignatureAlgorithm = &algorithm
+	firmware.SignatureKeyID = &keyID
+}
+
+func (s *fakeStore) addRelease(firmwareID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	release := &database.Release{
+		ID:         s.id("release"),
+		FirmwareID: firmwareID,
+		Status:     rollout.StatusPending,
+		Stage:      rollout.StageCanary,
+	}
+	s.releases[release.ID] = release
+	s.releaseOrder = append(s.releaseOrder, release.ID)
+	return release.ID
+}
+
+func (s *fakeStore) setReleaseState(releaseID, status, stage string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releases[releaseID].Status, s.releases[releaseID].Stage = status, stage
+}
+
+func (s *fakeStore) release(releaseID string) database.Release {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.releases[releaseID]
+}
+
+func (s *fakeStore) row(releaseID, deviceID string) database.ReleaseDevice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.releaseDevices[releaseID+"/"+deviceID]
+}
+
+func (s *fakeStore) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	lease, exists := s.leases[name]
+	if exists && lease.Holder != holder && now.Before(lease.ExpiresAt) {
+		return false, nil
+	}
+	s.leases[name] = &database.Lease{Name: name, Holder: holder, AcquiredAt: now, ExpiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *fakeStore) ReleaseLease(name, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lease, exists := s.leases[name]; exists && lease.Holder == holder {
+		delete(s.leases, name)
+	}
+	return nil
+}
+
+func (s *fakeStore) GetLease(name string) (*database.Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lease, exists := s.leases[name]
+	if !exists || !s.clock.Now().Before(lease.ExpiresAt) {
+		return nil, nil
+	}
+	copied := *lease
+	return &copied, nil
+}
+
+func (s *fakeStore) GetDeviceByID(deviceID string) (*database.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return nil, fmt.Errorf("device not found")
+	}
+	copied := *device
+	return &copied, nil
+}
+
+func (s *fakeStore) ListDevices() ([]database.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := make([]database.Device, 0, len(s.devices))
+	for _, device := range s.devices {
+		devices = append(devices, *device)
+	}
+	slices.SortFunc(devices, func(a, b database.Device) int {
+		if a.ID < b.ID {
+			return -1
+		}
+		if a.ID > b.ID {
+			return 1
+		}
+		return 0
+	})
+	return devices, nil
+}
+
+func (s *fakeStore) UpdateDeviceTelemetry(deviceID string, update database.TelemetryUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return nil
+	}
+	if update.FirmwareVersion != "" {
+		device.FirmwareVersion = &update.FirmwareVersion
+	}
+	if update.BootloaderVersion != "" {
+		device.BootloaderVersion = &update.BootloaderVersion
+	}
+	if update.Status != "" {
+		device.Status = &update.Status
+	}
+	if update.BatteryLevel != nil {
+		device.BatteryLevel = update.BatteryLevel
+	}
+	if update.Temperature != nil {
+		device.Temperature = update.Temperature
+	}
+	if update.UptimeSeconds != nil {
+		device.UptimeSeconds = update.UptimeSeconds
+	}
+	now := s.clock.Now()
+	device.LastSeenAt = &now
+	return nil
+}
+
+func (s *fakeStore) GetFirmwareByID(firmwareID string) (*database.Firmware, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	firmware, exists := s.firmware[firmwareID]
+	if !exists {
+		return nil, fmt.Errorf("failed to get firmware: not found")
+	}
+	copied := *firmware
+	return &copied, nil
+}
+
+func (s *fakeStore) ListFirmware() ([]database.Firmware, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	firmwares := make([]database.Firmware, 0, len(s.firmware))
+	for _, firmware := range s.firmware {
+		firmwares = append(firmwares, *firmware)
+	}
+	return firmwares, nil
+}
+
+func (s *fakeStore) GetFirmwareDelta(baseFirmwareID, targetFirmwareID string) (*database.FirmwareDelta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delta, exists := s.deltas[[2]string{baseFirmwareID, targetFirmwareID}]
+	if !exists {
+		return nil, database.ErrFirmwareDeltaNotFound
+	}
+	copied := *delta
+	return &copied, nil
+}
+
+func (s *fakeStore) ListFirmwareArtifacts(firmwareID string) ([]database.FirmwareArtifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.artifacts[firmwareID]), nil
+}
+
+func (s *fakeStore) GetReleaseByID(releaseID string) (*database.Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	release, exists := s.releases[releaseID]
+	if !exists {
+		return nil, database.ErrReleaseNotFound
+	}
+	copied := *release
+	return &copied, nil
+}
+
+func (s *fakeStore) ListReleasesByStatus(statuses []string) ([]database.Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var releases []database.Release
+	for _, id := range s.releaseOrder {
+		if release := s.releases[id]; slices.Contains(statuses, release.Status) {
+			releases = append(releases, *release)
+		}
+	}
+	return releases, nil
+}
+
+func (s *fakeStore) ApplyReleaseAction(releaseID, action, actor, reason string) (*database.Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	release, exists := s.releases[releaseID]
+	if !exists {
+		return nil, database.ErrReleaseNotFound
+	}
+	current := rollout.State{Status: release.Status, Stage: release.Stage}
+	next, err := rollout.Transition(current, action)
+	if err != nil {
+		return nil, err
+	}
+	release.Status, release.Stage = next.Status, next.Stage
+
+	if action == rollout.ActionRetryFailed {
+		for _, rd := range s.releaseDevices {
+			if rd.ReleaseID == releaseID && (rd.Status == rollout.DeviceFailed || rd.Status == rollout.DeviceTimedOut) {
+				rd.Status = rollout.DeviceDeferred
+				rd.CommandID, rd.Attempts, rd.TimeoutAt, rd.NextAttemptAt = nil, 0, nil, nil
+			}
+		}
+	}
+
+	s.events = append(s.events, database.ReleaseEvent{
+		ReleaseID: releaseID, Action: action, Actor: actor,
+		FromStatus: current.Status, FromStage: current.Stage, ToStatus: next.Status, ToStage: next.Stage,
+	})
+	copied := *release
+	return &copied, nil
+}
+
+func (s *fakeStore) WatchReleases(stop <-chan struct{}) (<-chan string, error) {
+	changes := make(chan string)
+	go func() {
+		defer close(changes)
+		for {
+			select {
+			case releaseID := <-s.changes:
+				select {
+				case changes <- releaseID:
+				case <-stop:
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
+
+func (s *fakeStore) ListReleaseDevices(releaseID string) ([]database.ReleaseDevice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []database.ReleaseDevice
+	for _, key := range s.rowOrder {
+		if rd := s.releaseDevices[key]; rd.ReleaseID == releaseID {
+			rows = append(rows, *rd)
+		}
+	}
+	return rows, nil
+}
+
+func (s *fakeStore) activeRelease(releaseID string) bool {
+	status := s.releases[releaseID].Status
+	return status == rollout.StatusInProgress || status == rollout.StatusPaused
+}
+
+func (s *fakeStore) ListActiveReleaseDevices(deviceID, status string) ([]database.ReleaseDevice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []database.ReleaseDevice
+	for _, key := range s.rowOrder {
+		rd := s.releaseDevices[key]
+		if rd.DeviceID == deviceID && rd.Status == status && s.activeRelease(rd.ReleaseID) {
+			rows = append(rows, *rd)
+		}
+	}
+	return rows, nil
+}
+
+func (s *fakeStore) CountActiveReleaseDevices(statuses []string) ([]database.StatusCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[[2]string]int)
+	for _, rd := range s.releaseDevices {
+		if s.activeRelease(rd.ReleaseID) && slices.Contains(statuses, rd.Status) {
+			counts[[2]string{rd.ReleaseID, rd.Status}]++
+		}
+	}
+	var result []database.StatusCount
+	for key, n := range counts {
+		result = append(result, database.StatusCount{ReleaseID: key[0], Status: key[1], Count: n})
+	}
+	return result, nil
+}
+
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// claim mirrors claimDevice: the holding release keeps the device while it
+// is open and its row is in a holding status.
+func (s *fakeStore) claim(deviceID, releaseID string) error {
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return fmt.Errorf("failed to lock device: not found")
+	}
+	if holder := device.UpdateReleaseID; holder != nil && *holder != releaseID {
+		rd, exists := s.releaseDevices[*holder+"/"+deviceID]
+		if exists && slices.Contains(rollout.OpenStatuses, s.releases[*holder].Status) &&
+			slices.Contains(rollout.HoldingStatuses, rd.Status) {
+			return &database.DeviceBusyError{DeviceID: deviceID, ReleaseID: *holder}
+		}
+	}
+	device.UpdateReleaseID = &releaseID
+	return nil
+}
+
+func (s *fakeStore) UpsertReleaseDevice(update database.ReleaseDeviceUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.Contains(rollout.HoldingStatuses, update.Status) {
+		if err := s.claim(update.DeviceID, update.ReleaseID); err != nil {
+			return err
+		}
+	}
+
+	key := update.ReleaseID + "/" + update.DeviceID
+	rd, exists := s.releaseDevices[key]
+	if !exists {
+		rd = &database.ReleaseDevice{ReleaseID: update.ReleaseID, DeviceID: update.DeviceID}
+		s.releaseDevices[key] = rd
+		s.rowOrder = append(s.rowOrder, key)
+	}
+	if rd.Wave == nil {
+		rd.Wave = optional(update.Wave)
+	}
+	if rd.PreviousVersion == nil {
+		rd.PreviousVersion = optional(update.PreviousVersion)
+	}
+	if rd.BaselineBattery == nil {
+		rd.BaselineBattery = update.BaselineBattery
+	}
+	if rd.BaselineTemp == nil {
+		rd.BaselineTemp = update.BaselineTemp
+	}
+	rd.FirmwareID = optional(update.FirmwareID)
+	rd.Status = update.Status
+	rd.Reason = optional(update.Reason)
+	rd.NextAttemptAt = update.NextAttemptAt
+	rd.CommandID = optional(update.CommandID)
+	rd.Attempts = update.Attempts
+	rd.TimeoutAt = update.TimeoutAt
+	rd.DeltaID = optional(update.DeltaID)
+	return nil
+}
+
+func (s *fakeStore) UpdateReleaseDeviceStatus(releaseID, deviceID, status, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rd, exists := s.releaseDevices[releaseID+"/"+deviceID]; exists {
+		rd.Status, rd.Reason, rd.TimeoutAt = status, optional(reason), nil
+	}
+	return nil
+}
+
+func (s *fakeStore) HoldReleaseDevice(releaseID, deviceID, status, reason string, attempts int,
+	timeoutAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.claim(deviceID, releaseID); err != nil {
+		return err
+	}
+	if rd, exists := s.releaseDevices[releaseID+"/"+deviceID]; exists {
+		rd.Status, rd.Reason, rd.Attempts = status, optional(reason), attempts
+		rd.NextAttemptAt, rd.TimeoutAt = nil, timeoutAt
+	}
+	return nil
+}
+
+func (s *fakeStore) RestoreReleaseDevice(prior database.ReleaseDevice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rd, exists := s.releaseDevices[prior.ReleaseID+"/"+prior.DeviceID]; exists {
+		rd.Status, rd.Reason, rd.Attempts = prior.Status, prior.Reason, prior.Attempts
+		rd.NextAttemptAt, rd.TimeoutAt = prior.NextAttemptAt, prior.TimeoutAt
+	}
+	return nil
+}
+
+func (s *fakeStore) UpdateActiveReleaseDeviceStatus(deviceID, commandID string, from []string, status, reason string,
+	timeoutAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rd := range s.releaseDevices {
+		if rd.DeviceID != deviceID || !s.activeRelease(rd.ReleaseID) || !slices.Contains(from, rd.Status) {
+			continue
+		}
+		if commandID != "" && (rd.CommandID == nil || *rd.CommandID != commandID) {
+			continue
+		}
+		rd.Status, rd.Reason, rd.TimeoutAt = status, optional(reason), timeoutAt
+		if status == rollout.DeviceVerifying {
+			now := s.clock.Now()
+			rd.InstalledAt = &now
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) FallBackToFullImage(deviceID, commandID, reason string, retryAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fellBack := false
+	for _, rd := range s.releaseDevices {
+		if rd.DeviceID != deviceID || !s.activeRelease(rd.ReleaseID) || rd.DeltaID == nil ||
+			!slices.Contains(rollout.InFlightStatuses, rd.Status) {
+			continue
+		}
+		if commandID != "" && (rd.CommandID == nil || *rd.CommandID != commandID) {
+			continue
+		}
+		rd.Status, rd.Reason, rd.NextAttemptAt, rd.TimeoutAt = rollout.DeviceRetrying, optional(reason), &retryAt, nil
+		rd.FullImage, rd.DeltaID, rd.CommandID = true, nil, nil
+		fellBack = true
+	}
+	return fellBack, nil
+}
+
+func (s *fakeStore) ResolveReleaseDevice(releaseID, deviceID, from, status, reason string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rd, exists := s.releaseDevices[releaseID+"/"+deviceID]
+	if !exists || rd.Status != from {
+		return false, nil
+	}
+	rd.Status, rd.Reason, rd.TimeoutAt = status, optional(reason), nil
+	return true, nil
+}
+
+func (s *fakeStore) ExpireReleaseDevice(releaseID, deviceID, from, status, reason string, nextAttemptAt *time.Time,
+	now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rd, exists := s.releaseDevices[releaseID+"/"+deviceID]
+	if !exists || rd.Status != from || rd.TimeoutAt == nil || rd.TimeoutAt.After(now) {
+		return false, nil
+	}
+	rd.Status, rd.Reason, rd.NextAttemptAt, rd.TimeoutAt = status, optional(reason), nextAttemptAt, nil
+	return true, nil
+}
+
+func (s *fakeStore) ListMaintenanceWindows() ([]database.MaintenanceWindow, error) {
+	return nil, nil
+}
+
+// fakePublisher records the commands the orchestrator sends and lets tests
+// deliver device reports through the handlers it subscribed.
+type fakePublisher struct {
+	mu               sync.Mutex
+	telemetry        mqtt.TelemetryHandler
+	updateStatus     mqtt.UpdateStatusHandler
+	rollbackStatus   mqtt.RollbackStatusHandler
+	updateCommands   []mqtt.UpdateCommand
+	rollbackCommands []mqtt.RollbackCommand
+	alerts           []mqtt.FirmwareAlert
+	// publishErr, when set, fails every command publish.
+	publishErr error
+}
+
+func (p *fakePublisher) SubscribeToTelemetry(handler mqtt.TelemetryHandler) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.telemetry = handler
+	return nil
+}
+
+func (p *fakePublisher) SubscribeToUpdateStatus(handler mqtt.UpdateStatusHandler) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.updateStatus = handler
+	return nil
+}
+
+func (p *fakePublisher) SubscribeToRollbackStatus(handler mqtt.RollbackStatusHandler) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rollbackStatus = handler
+	return nil
+}
+
+func (p *fakePublisher) PublishUpdateCommand(deviceID string, cmd *mqtt.UpdateCommand) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.publishErr != nil {
+		return p.publishErr
+	}
+	p.updateCommands = append(p.updateCommands, *cmd)
+	return nil
+}
+
+func (p *fakePublisher) PublishRollbackCommand(deviceID string, cmd *mqtt.RollbackCommand) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.publishErr != nil {
+		return p.publishErr
+	}
+	p.rollbackCommands = append(p.rollbackCommands, *cmd)
+	return nil
+}
+
+func (p *fakePublisher) PublishFirmwareAlert(alert *mqtt.FirmwareAlert) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.alerts = append(p.alerts, *alert)
+	return nil
+}
+
+func (p *fakePublisher) sentAlerts() []mqtt.FirmwareAlert {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.alerts)
+}
+
+func (p *fakePublisher) sentUpdates() []mqtt.UpdateCommand {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.updateCommands)
+}
+
+func (p *fakePublisher) sentRollbacks() []mqtt.RollbackCommand {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.rollbackCommands)
+}
+
+func (p *fakePublisher) reportTelemetry(telemetry mqtt.DeviceTelemetry) {
+	p.mu.Lock()
+	handler := p.telemetry
+	p.mu.Unlock()
+	handler(&telemetry)
+}
+
+func (p *fakePublisher) reportUpdate(status mqtt.UpdateStatus) {
+	p.mu.Lock()
+	handler := p.updateStatus
+	p.mu.Unlock()
+	handler(&status)
+}
+
+func (p *fakePublisher) reportRollback(status mqtt.RollbackStatus) {
+	p.mu.Lock()
+	handler := p.rollbackStatus
+	p.mu.Unlock()
+	handler(&status)
+}
@@ -0,0 +1,152 @@
+package ota
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
+
+// GuardResult is the outcome of checking a device's telemetry against a
+// release's health guards. Status is empty while the device has not yet
+// passed every guard but may still do so.
+type GuardResult struct {
+	Status string
+	Reason string
+}
+
+// EvaluateGuards checks the telemetry a device has reported since it
+// finished installing. Battery drain and temperature rise beyond the guards
+// fail the device at once; a device that has not yet reported the target
+// version, a healthy status and enough uptime is left waiting.
+func EvaluateGuards(guards *rollout.HealthGuards, device database.Device, rd database.ReleaseDevice, targetVersion string) GuardResult {
+	if guards == nil {
+		return GuardResult{Status: rollout.DeviceUpdated}
+	}
+	if rd.InstalledAt == nil || device.LastSeenAt == nil || device.LastSeenAt.Before(*rd.InstalledAt) {
+		return GuardResult{Reason: "no telemetry since install"}
+	}
+
+	if guards.MaxBatteryDrop > 0 && rd.BaselineBattery != nil && device.BatteryLevel != nil {
+		if drop := *rd.BaselineBattery - *device.BatteryLevel; drop > guards.MaxBatteryDrop {
+			return GuardResult{Status: rollout.DeviceFailed, Reason: fmt.Sprintf(
+				"battery dropped %.1f points from %.1f, more than %.1f", drop, *rd.BaselineBattery, guards.MaxBatteryDrop)}
+		}
+	}
+	if guards.MaxTemperatureRise > 0 && rd.BaselineTemp != nil && device.Temperature != nil {
+		if rise := *device.Temperature - *rd.BaselineTemp; rise > guards.MaxTemperatureRise {
+			return GuardResult{Status: rollout.DeviceFailed, Reason: fmt.Sprintf(
+				"temperature rose %.1f from %.1f, more than %.1f", rise, *rd.BaselineTemp, guards.MaxTemperatureRise)}
+		}
+	}
+
+	if device.FirmwareVersion == nil || *device.FirmwareVersion != targetVersion {
+		current := "no version"
+		if device.FirmwareVersion != nil {
+			current = *device.FirmwareVersion
+		}
+		return GuardResult{Reason: fmt.Sprintf("reports %s instead of %s", current, targetVersion)}
+	}
+	status := ""
+	if device.Status != nil {
+		status = *device.Status
+	}
+	if !guards.IsHealthyStatus(status) {
+		return GuardResult{Reason: fmt.Sprintf("status %q is not healthy", status)}
+	}
+	if guards.MinUptimeSeconds > 0 {
+		var uptime int64
+		if device.UptimeSeconds != nil {
+			uptime = *device.UptimeSeconds
+		}
+		if uptime < guards.MinUptimeSeconds {
+			return GuardResult{Reason: fmt.Sprintf("uptime %ds below %ds", uptime, guards.MinUptimeSeconds)}
+		}
+	}
+
+	return GuardResult{Status: rollout.DeviceUpdated}
+}
+
+// verifyDevice checks a device that reported a completed install against
+// the health guards of each active release it is verifying for. It runs when
+// the device reports completion and on each telemetry message.
+func (o *Orchestrator) verifyDevice(deviceID string) {
+	releaseDevices, err := o.db.ListActiveReleaseDevices(deviceID, rollout.DeviceVerifying)
+	if err != nil {
+		log.Printf("Error listing releases verifying device %s: %v", deviceID, err)
+		return
+	}
+	if len(releaseDevices) == 0 {
+		return
+	}
+
+	device, err := o.db.GetDeviceByID(deviceID)
+	if err != nil {
+		log.Printf("Error getting device %s: %v", deviceID, err)
+		return
+	}
+	for _, rd := range releaseDevices {
+		release, err := o.db.GetReleaseByID(rd.ReleaseID)
+		if err != nil {
+			log.Printf("Error getting release %s: %v", rd.ReleaseID, err)
+			continue
+		}
+		o.verifyReleaseDevice(*release, rd, *device)
+	}
+}
+
+// verifyRelease re-checks every device the release is verifying, so that
+// devices that stop reporting fail once their guard deadline passes.
+func (o *Orchestrator) verifyRelease(release database.Release) {
+	releaseDevices, err := o.db.ListReleaseDevices(release.ID)
+	if err != nil {
+		log.Printf("Error listing devices for release %s: %v", release.ID, err)
+		return
+	}
+	for _, rd := range releaseDevices {
+		if rd.Status != rollout.DeviceVerifying {
+			continue
+		}
+		device, err := o.db.GetDeviceByID(rd.DeviceID)
+		if err != nil {
+			log.Printf("Error getting device %s: %v", rd.DeviceID, err)
+			continue
+		}
+		o.verifyReleaseDevice(release, rd, *device)
+	}
+}
+
+func (o *Orchestrator) verifyReleaseDevice(release database.Release, rd database.ReleaseDevice, device database.Device) {
+	targetVersion := ""
+	if release.HealthGuards != nil && rd.FirmwareID != nil {
+		firmware, err := o.db.GetFirmwareByID(*rd.FirmwareID)
+		if err != nil {
+			log.Printf("Error getting firmware for device %s: %v", rd.DeviceID, err)
+			return
+		}
+		targetVersion = firmware.Version
+	}
+
+	result := EvaluateGuards(release.HealthGuards, device, rd, targetVersion)
+	if result.Status == "" {
+		if rd.InstalledAt == nil || o.clock.Now().Before(rd.InstalledAt.Add(release.HealthGuards.ReportWithin())) {
+			return
+		}
+		result = GuardResult{Status: rollout.DeviceFailed, Reason: fmt.Sprintf("health guards not met within %s: %s",
+			release.HealthGuards.ReportWithin(), result.Reason)}
+	}
+
+	resolved, err := o.db.ResolveReleaseDevice(release.ID, rd.DeviceID, rollout.DeviceVerifying, result.Status, result.Reason)
+	if err != nil {
+		log.Printf("Error recording verification of device %s: %v", rd.DeviceID, err)
+		return
+	}
+	if !resolved {
+		return
+	}
+	if result.Status == rollout.DeviceFailed {
+		log.Printf("Release %s failed health guards on device %s: %s", release.ID, rd.DeviceID, result.Reason)
+	}
+	o.trigger()
+}
@@ -0,0 +1,126 @@
+package ota
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
+
+func TestEvaluateGuards(t *testing.T) {
+	installed := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	reported := installed.Add(5 * time.Minute)
+	guards := &rollout.HealthGuards{
+		ReportWithinMinutes: 30,
+		MinUptimeSeconds:    300,
+		MaxBatteryDrop:      10,
+		MaxTemperatureRise:  5,
+	}
+	float := func(v float64) *float64 { return &v }
+	int64p := func(v int64) *int64 { return &v }
+
+	// healthy returns a device that passes every guard for a row with
+	// baselines of 80% battery and 30 °C.
+	healthy := func() (database.Device, database.ReleaseDevice) {
+		device := database.Device{
+			FirmwareVersion: optional("2.0.0"),
+			Status:          optional("healthy"),
+			BatteryLevel:    float(75),
+			Temperature:     float(32),
+			UptimeSeconds:   int64p(600),
+			LastSeenAt:      &reported,
+		}
+		rd := database.ReleaseDevice{
+			InstalledAt:     &installed,
+			BaselineBattery: float(80),
+			BaselineTemp:    float(30),
+		}
+		return device, rd
+	}
+
+	tests := []struct {
+		name     string
+		noGuards bool
+		guards   *rollout.HealthGuards
+		change   func(*database.Device, *database.ReleaseDevice)
+		status   string
+		reason   string
+	}{
+		{name: "no guards", noGuards: true, change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.LastSeenAt = nil
+		}, status: rollout.DeviceUpdated},
+		{name: "healthy", status: rollout.DeviceUpdated},
+		{name: "no telemetry since install", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			before := installed.Add(-time.Minute)
+			d.LastSeenAt = &before
+		}, reason: "no telemetry since install"},
+		{name: "battery drop", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.BatteryLevel = float(65)
+		}, status: rollout.DeviceFailed, reason: "battery dropped 15.0"},
+		{name: "flat battery", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.BatteryLevel = float(0)
+		}, status: rollout.DeviceFailed, reason: "battery dropped 80.0"},
+		{name: "battery drop within limit", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.BatteryLevel = float(71)
+		}, status: rollout.DeviceUpdated},
+		{name: "no battery baseline", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			rd.BaselineBattery = nil
+			d.BatteryLevel = float(0)
+		}, status: rollout.DeviceUpdated},
+		{name: "no battery reading", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.BatteryLevel = nil
+		}, status: rollout.DeviceUpdated},
+		{name: "temperature rise", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.Temperature = float(36)
+		}, status: rollout.DeviceFailed, reason: "temperature rose 6.0"},
+		{name: "temperature rise from 0 °C", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			rd.BaselineTemp = float(0)
+			d.Temperature = float(8)
+		}, status: rollout.DeviceFailed, reason: "temperature rose 8.0"},
+		{name: "no temperature baseline", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			rd.BaselineTemp = nil
+			d.Temperature = float(90)
+		}, status: rollout.DeviceUpdated},
+		{name: "old version", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.FirmwareVersion = optional("1.0.0")
+		}, reason: "reports 1.0.0 instead of 2.0.0"},
+		{name: "no version", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.FirmwareVersion = nil
+		}, reason: "reports no version instead of 2.0.0"},
+		{name: "unhealthy status", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.Status = optional("degraded")
+		}, reason: `status "degraded" is not healthy`},
+		{name: "custom healthy status", guards: &rollout.HealthGuards{ReportWithinMinutes: 30,
+			HealthyStatuses: []string{"degraded"}}, change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.Status = optional("degraded")
+		}, status: rollout.DeviceUpdated},
+		{name: "low uptime", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.UptimeSeconds = int64p(0)
+		}, reason: "uptime 0s below 300s"},
+		{name: "no uptime", change: func(d *database.Device, rd *database.ReleaseDevice) {
+			d.UptimeSeconds = nil
+		}, reason: "uptime 0s below 300s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device, rd := healthy()
+			if tt.change != nil {
+				tt.change(&device, &rd)
+			}
+			g := guards
+			if tt.guards != nil {
+				g = tt.guards
+			}
+			if tt.noGuards {
+				g = nil
+			}
+
+			result := EvaluateGuards(g, device, rd, "2.0.0")
+			if result.Status != tt.status || !strings.HasPrefix(result.Reason, tt.reason) {
+				t.Errorf("got %q (%s), want %q (%s...)", result.Status, result.Reason, tt.status, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package ota
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const leaderLeaseName = "ota-orchestrator"
+
+// LeaderElector holds a lease row in the database so that only one
+// orchestrator replica processes releases. The lease is renewed at a third of
+// its TTL, so a replica that dies is replaced within one TTL; a replica that
+// stops cleanly releases it immediately.
+type LeaderElector struct {
+	db        LeaseStore
+	replicaID string
+	ttl       time.Duration
+	clock     Clock
+	done      chan struct{}
+
+	mu         sync.RWMutex
+	validUntil time.Time
+}
+
+func NewLeaderElector(db LeaseStore, replicaID string, ttl time.Duration, clock Clock) *LeaderElector {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &LeaderElector{
+		db:        db,
+		replicaID: replicaID,
+		ttl:       ttl,
+		clock:     clock,
+		done:      make(chan struct{}),
+	}
+}
+
+func (e *LeaderElector) Run(stop <-chan struct{}) {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	e.renew()
+	for {
+		select {
+		case <-ticker.C:
+			e.renew()
+		case <-stop:
+			e.resign()
+			return
+		}
+	}
+}
+
+func (e *LeaderElector) renew() {
+	wasLeader := e.IsLeader()
+	attemptedAt := e.clock.Now()
+
+	acquired, err := e.db.AcquireLease(leaderLeaseName, e.replicaID, e.ttl)
+	if err != nil {
+		log.Printf("Error renewing leader lease: %v", err)
+	}
+
+	e.mu.Lock()
+	if acquired {
+		e.validUntil = attemptedAt.Add(e.ttl)
+	} else if err == nil {
+		e.validUntil = time.Time{}
+	}
+	e.mu.Unlock()
+
+	if isLeader := e.IsLeader(); isLeader != wasLeader {
+		if isLeader {
+			log.Printf("Replica %s became OTA orchestrator leader", e.replicaID)
+		} else {
+			log.Printf("Replica %s lost OTA orchestrator leadership", e.replicaID)
+		}
+	}
+}
+
+func (e *LeaderElector) resign() {
+	e.mu.Lock()
+	wasLeader := e.clock.Now().Before(e.validUntil)
+	e.validUntil = time.Time{}
+	e.mu.Unlock()
+
+	if !wasLeader {
+		return
+	}
+	if err := e.db.ReleaseLease(leaderLeaseName, e.replicaID); err != nil {
+		log.Printf("Error releasing leader lease: %v", err)
+	}
+}
+
+// IsLeader reports whether this replica holds a lease that has not yet
+// expired by its own clock. A replica that cannot reach the database stops
+// acting as leader once its last renewal runs out.
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.clock.Now().Before(e.validUntil)
+}
+
+// Done is closed once Run has returned and the lease has been released.
+func (e *LeaderElector) Done() <-chan struct{} {
+	return e.done
+}
+
+func (e *LeaderElector) ReplicaID() string {
+	return e.replicaID
+}
+
+// CurrentLeader returns the replica ID holding the lease, or an empty string
+// if no replica holds it.
+func (e *LeaderElector) CurrentLeader() (string, error) {
+	lease, err := e.db.GetLease(leaderLeaseName)
+	if err != nil || lease == nil {
+		return "", err
+	}
+	return lease.Holder, nil
+}
@@ -0,0 +1,68 @@
+package ota
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaderElection(t *testing.T) {
+	clock := newFakeClock()
+	store := newFakeStore(clock)
+	ttl := 30 * time.Second
+	a := NewLeaderElector(store, "replica-a", ttl, clock)
+	b := NewLeaderElector(store, "replica-b", ttl, clock)
+
+	assertLeader := func(t *testing.T, want string) {
+		t.Helper()
+		for _, e := range []*LeaderElector{a, b} {
+			if got := e.IsLeader(); got != (e.ReplicaID() == want) {
+				t.Errorf("%s IsLeader = %v, want leader %q", e.ReplicaID(), got, want)
+			}
+		}
+		if leader, err := a.CurrentLeader(); err != nil || leader != want {
+			t.Errorf("CurrentLeader = %q, %v, want %q", leader, err, want)
+		}
+	}
+
+	t.Run("acquire", func(t *testing.T) {
+		a.renew()
+		b.renew()
+		assertLeader(t, "replica-a")
+	})
+
+	t.Run("renew", func(t *testing.T) {
+		clock.Advance(ttl / 3)
+		a.renew()
+		b.renew()
+		// Past the first lease's expiry, but within the renewed one.
+		clock.Advance(ttl - time.Second)
+		assertLeader(t, "replica-a")
+	})
+
+	t.Run("loss on expiry", func(t *testing.T) {
+		clock.Advance(time.Second)
+		assertLeader(t, "")
+
+		b.renew()
+		a.renew()
+		assertLeader(t, "replica-b")
+	})
+
+	t.Run("resign", func(t *testing.T) {
+		b.resign()
+		assertLeader(t, "")
+
+		// A replica that resigned without leading must not drop another's lease.
+		b.renew()
+		a.renew()
+		a.resign()
+		assertLeader(t, "replica-b")
+	})
+}
+
+func TestOrchestratorDefaultsLeaseTTL(t *testing.T) {
+	o := NewOrchestrator(newFakeStore(newFakeClock()), &fakePublisher{}, Config{ReplicaID: "replica-a"})
+	if o.elector.ttl != defaultLeaseTTL {
+		t.Errorf("lease TTL = %s, want %s", o.elector.ttl, defaultLeaseTTL)
+	}
+}
@@ -1,45 +1,163 @@
 package ota
 
 import (
+	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/10xdev4u-alt/aura/pkg/database"
 	"github.com/10xdev4u-alt/aura/pkg/mqtt"
+	"github.com/10xdev4u-alt/aura/pkg/pki"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
 )
 
+// Orchestrator drives releases through their rollout. Release processing
+// runs only on the goroutine executing Start; MQTT handlers, which paho calls
+// from its own goroutines, only write device state to the store and wake that
+// loop. Health metrics are shared with readers such as GetReleaseHealth and
+// are guarded by mu.
 type Orchestrator struct {
-	db            *database.DB
-	mqttClient    *mqtt.Client
-	pollInterval  time.Duration
-	stopChan      chan struct{}
+	db             Store
+	mqttClient     Publisher
+	pollInterval   time.Duration
+	canary         CanaryPolicy
+	elector        *LeaderElector
+	windows        []rollout.Window
+	dispatcher     *Dispatcher
+	timeouts       UpdateTimeouts
+	retry          RetryPolicy
+	clock          Clock
+	conflictPolicy string
+	commandTTL     time.Duration
+	downloadURL    string
+	urlSigner      *pki.URLSigner
+	deltaUpdates   bool
+	yankedAlerts   time.Duration
+	lastYanked     time.Time
+	wake           chan struct{}
+	stopChan       chan struct{}
+	electorStop    chan struct{}
+	done           chan struct{}
+
+	mu            sync.RWMutex
 	healthMetrics map[string]*ReleaseHealth
 }
 
+type Config struct {
+	PollInterval    time.Duration
+	Canary          CanaryPolicy
+	Dispatch        DispatchLimits
+	Timeouts        UpdateTimeouts
+	Retry           RetryPolicy
+	ConflictPolicy  string
+	CommandTTL      time.Duration
+	DownloadBaseURL string
+	URLSigner       *pki.URLSigner
+	DeltaUpdates    bool
+	// YankedAlertInterval is how often devices running yanked firmware are
+	// alerted on.
+	YankedAlertInterval time.Duration
+	ReplicaID           string
+	LeaseTTL            time.Duration
+	Clock               Clock
+}
+
+type Status struct {
+	ReplicaID string `json:"replica_id"`
+	Leader    bool   `json:"leader"`
+	LeaderID  string `json:"leader_id"`
+}
+
 type ReleaseHealth struct {
 	ReleaseID      string
 	SuccessCount   int
 	FailureCount   int
+	PendingCount   int
 	TotalDevices   int
 	CurrentStage   string
 	LastUpdateTime time.Time
 }
 
-func NewOrchestrator(db *database.DB, mqttClient *mqtt.Client) *Orchestrator {
+const (
+	orchestratorActor   = "orchestrator"
+	minSuccessRate      = 0.8
+	minHealthSample     = 5
+	defaultPollInterval = 30 * time.Second
+	defaultCommandTTL   = time.Hour
+	defaultLeaseTTL     = 15 * time.Second
+	defaultYankedAlerts = time.Hour
+)
+
+// activeStatuses are the release statuses the orchestrator acts on.
+var activeStatuses = []string{rollout.StatusPending, rollout.StatusInProgress, rollout.StatusRollingBack}
+
+func NewOrchestrator(db Store, mqttClient Publisher, cfg Config) *Orchestrator {
+	clock := cfg.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	pollInterval := cfg.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	conflictPolicy := cfg.ConflictPolicy
+	if conflictPolicy == "" {
+		conflictPolicy = ConflictQueue
+	}
+	commandTTL := cfg.CommandTTL
+	if commandTTL <= 0 {
+		commandTTL = defaultCommandTTL
+	}
+	leaseTTL := cfg.LeaseTTL
+	if leaseTTL <= 0 {
+		leaseTTL = defaultLeaseTTL
+	}
+	yankedAlerts := cfg.YankedAlertInterval
+	if yankedAlerts <= 0 {
+		yankedAlerts = defaultYankedAlerts
+	}
 	return &Orchestrator{
-		db:            db,
-		mqttClient:    mqttClient,
-		pollInterval:  30 * time.Second,
-		stopChan:      make(chan struct{}),
-		healthMetrics: make(map[string]*ReleaseHealth),
+		db:             db,
+		mqttClient:     mqttClient,
+		pollInterval:   pollInterval,
+		canary:         cfg.Canary,
+		elector:        NewLeaderElector(db, cfg.ReplicaID, leaseTTL, clock),
+		dispatcher:     NewDispatcher(cfg.Dispatch, clock),
+		timeouts:       cfg.Timeouts,
+		retry:          cfg.Retry,
+		clock:          clock,
+		conflictPolicy: conflictPolicy,
+		commandTTL:     commandTTL,
+		downloadURL:    strings.TrimSuffix(cfg.DownloadBaseURL, "/"),
+		urlSigner:      cfg.URLSigner,
+		deltaUpdates:   cfg.DeltaUpdates,
+		yankedAlerts:   yankedAlerts,
+		wake:           make(chan struct{}, 1),
+		stopChan:       make(chan struct{}),
+		electorStop:    make(chan struct{}),
+		done:           make(chan struct{}),
+		healthMetrics:  make(map[string]*ReleaseHealth),
 	}
 }
 
+// Start runs the orchestrator until Stop is called. Releases are processed
+// when the database reports a release change, when a device reports update
+// progress, and every poll interval as a fallback.
 func (o *Orchestrator) Start() {
+	defer close(o.done)
 	log.Println("OTA Orchestrator started")
 
-	o.mqttClient.SubscribeToTelemetry(o.handleTelemetry)
-	o.mqttClient.SubscribeToUpdateStatus(o.handleUpdateStatus)
+	o.subscribe()
+	go o.elector.Run(o.electorStop)
+
+	changes, err := o.db.WatchReleases(o.stopChan)
+	if err != nil {
+		log.Printf("Error watching release changes, falling back to polling every %s: %v", o.pollInterval, err)
+	} else {
+		go o.forwardChanges(changes)
+	}
 
 	ticker := time.NewTicker(o.pollInterval)
 	defer ticker.Stop()
@@ -47,185 +165,486 @@ func (o *Orchestrator) Start() {
 	for {
 		select {
 		case <-ticker.C:
-			o.processReleases()
+		case <-o.wake:
 		case <-o.stopChan:
 			log.Println("OTA Orchestrator stopped")
 			return
 		}
+		if o.elector.IsLeader() {
+			o.processReleases()
+			o.alertYankedFirmware()
+		}
+	}
+}
+
+func (o *Orchestrator) subscribe() {
+	if err := o.mqttClient.SubscribeToTelemetry(o.handleTelemetry); err != nil {
+		log.Printf("Error subscribing to telemetry: %v", err)
+	}
+	if err := o.mqttClient.SubscribeToUpdateStatus(o.handleUpdateStatus); err != nil {
+		log.Printf("Error subscribing to update status: %v", err)
+	}
+	if err := o.mqttClient.SubscribeToRollbackStatus(o.handleRollbackStatus); err != nil {
+		log.Printf("Error subscribing to rollback status: %v", err)
+	}
+}
+
+func (o *Orchestrator) forwardChanges(changes <-chan string) {
+	for range changes {
+		o.trigger()
+	}
+}
+
+// trigger asks the main loop to process releases as soon as it is free.
+// Requests made while a cycle is already pending are coalesced.
+func (o *Orchestrator) trigger() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Orchestrator) stopping() bool {
+	select {
+	case <-o.stopChan:
+		return true
+	default:
+		return false
 	}
 }
 
 func (o *Orchestrator) handleTelemetry(telemetry *mqtt.DeviceTelemetry) {
 	log.Printf("Received telemetry from device %s: status=%s, version=%s",
 		telemetry.DeviceID, telemetry.Status, telemetry.FirmwareVersion)
+
+	err := o.db.UpdateDeviceTelemetry(telemetry.DeviceID, database.TelemetryUpdate{
+		FirmwareVersion:   telemetry.FirmwareVersion,
+		BootloaderVersion: telemetry.BootloaderVersion,
+		Status:            telemetry.Status,
+		BatteryLevel:      telemetry.BatteryLevel,
+		Temperature:       telemetry.Temperature,
+		UptimeSeconds:     telemetry.Uptime,
+	})
+	if err != nil {
+		log.Printf("Error recording telemetry for device %s: %v", telemetry.DeviceID, err)
+		return
+	}
+
+	o.verifyDevice(telemetry.DeviceID)
 }
 
 func (o *Orchestrator) handleUpdateStatus(status *mqtt.UpdateStatus) {
 	log.Printf("Update status from device %s: %s (progress: %d%%)",
 		status.DeviceID, status.Status, status.Progress)
 
-	if status.Status == "completed" {
+	var from []string
+	var next string
+	switch status.Status {
+	case "downloading":
+		from, next = []string{rollout.DeviceSent, rollout.DeviceInstalling}, rollout.DeviceDownloading
+	case "installing":
+		from, next = []string{rollout.DeviceSent, rollout.DeviceDownloading}, rollout.DeviceInstalling
+	case "rebooting":
+		from, next = []string{rollout.DeviceSent, rollout.DeviceDownloading, rollout.DeviceInstalling}, rollout.DeviceRebooting
+	case "completed":
 		log.Printf("Device %s successfully updated", status.DeviceID)
-	} else if status.Status == "failed" {
+		from = []string{rollout.DeviceSent, rollout.DeviceDownloading, rollout.DeviceInstalling, rollout.DeviceRebooting}
+		next = rollout.DeviceVerifying
+	case "failed":
 		log.Printf("Device %s update failed: %s", status.DeviceID, status.Error)
+		if o.fallBackToFullImage(status) {
+			o.trigger()
+			return
+		}
+		from, next = rollout.InFlightStatuses, rollout.DeviceFailed
+	default:
+		return
+	}
+
+	err := o.db.UpdateActiveReleaseDeviceStatus(status.DeviceID, status.CommandID, from, next, status.Error, o.deadline(next))
+	if err != nil {
+		log.Printf("Error recording update status of device %s: %v", status.DeviceID, err)
+		return
+	}
+
+	switch next {
+	case rollout.DeviceVerifying:
+		o.verifyDevice(status.DeviceID)
+	case rollout.DeviceFailed:
+		o.trigger()
 	}
 }
 
+func (o *Orchestrator) handleRollbackStatus(status *mqtt.RollbackStatus) {
+	log.Printf("Rollback status from device %s for release %s: %s",
+		status.DeviceID, status.ReleaseID, status.Status)
+
+	var next string
+	switch status.Status {
+	case "completed":
+		next = rollout.DeviceRolledBack
+	case "failed":
+		next = rollout.DeviceRollbackFailed
+	default:
+		return
+	}
+
+	if err := o.db.UpdateReleaseDeviceStatus(status.ReleaseID, status.DeviceID, next, status.Error); err != nil {
+		log.Printf("Error recording rollback of device %s: %v", status.DeviceID, err)
+		return
+	}
+	o.trigger()
+}
+
+// Stop waits for the current processing cycle to finish sending the commands
+// it has started, then gives up leadership.
 func (o *Orchestrator) Stop() {
 	close(o.stopChan)
+	<-o.done
+	close(o.electorStop)
+	<-o.elector.Done()
 }
 
 func (o *Orchestrator) processReleases() {
-	releases, err := o.db.ListReleases()
+	releases, err := o.db.ListReleasesByStatus(activeStatuses)
 	if err != nil {
 		log.Printf("Error fetching releases: %v", err)
 		return
 	}
 
+	o.windows, err = o.loadWindows()
+	if err != nil {
+		log.Printf("Error fetching maintenance windows: %v", err)
+		return
+	}
+
+	if err := o.syncDispatcher(); err != nil {
+		log.Printf("Error counting in-flight updates: %v", err)
+		return
+	}
+
+	now := o.clock.Now()
 	for _, release := range releases {
-		if release.Status == "pending" {
-			o.startRelease(release.ID)
-		} else if release.Status == "in_progress" {
-			o.monitorRelease(release.ID)
+		if o.stopping() {
+			return
+		}
+		switch release.Status {
+		case rollout.StatusPending:
+			if release.ScheduledAt != nil && now.Before(*release.ScheduledAt) {
+				continue
+			}
+			o.startRelease(release)
+		case rollout.StatusInProgress:
+			o.monitorRelease(release)
+		case rollout.StatusRollingBack:
+			o.continueRollback(release.ID)
 		}
 	}
 }
 
-func (o *Orchestrator) startRelease(releaseID string) {
-	log.Printf("Starting release: %s", releaseID)
+func (o *Orchestrator) syncDispatcher() error {
+	counts, err := o.db.CountActiveReleaseDevices(rollout.InFlightStatuses)
+	if err != nil {
+		return err
+	}
+	inFlight := make(map[string]int)
+	downloading := 0
+	for _, count := range counts {
+		inFlight[count.ReleaseID] += count.Count
+		if count.Status == rollout.DeviceDownloading {
+			downloading += count.Count
+		}
+	}
+	o.dispatcher.Reset(inFlight, downloading)
+	return nil
+}
 
-	err := o.db.UpdateReleaseStatus(releaseID, "in_progress", "canary")
+func (o *Orchestrator) loadWindows() ([]rollout.Window, error) {
+	stored, err := o.db.ListMaintenanceWindows()
 	if err != nil {
-		log.Printf("Error starting release %s: %v", releaseID, err)
-		return
+		return nil, err
 	}
+	windows := make([]rollout.Window, 0, len(stored))
+	for _, w := range stored {
+		window, err := w.Window()
+		if err != nil {
+			log.Printf("Skipping invalid maintenance window %s: %v", w.ID, err)
+			continue
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
 
-	o.healthMetrics[releaseID] = &ReleaseHealth{
-		ReleaseID:      releaseID,
-		CurrentStage:   "canary",
-		LastUpdateTime: time.Now(),
+func (o *Orchestrator) applyAction(releaseID, action, reason string) bool {
+	if _, err := o.db.ApplyReleaseAction(releaseID, action, orchestratorActor, reason); err != nil {
+		log.Printf("Error applying %s to release %s: %v", action, releaseID, err)
+		return false
 	}
+	return true
+}
 
-	release, err := o.db.GetReleaseByID(releaseID)
+func (o *Orchestrator) startRelease(release database.Release) {
+	target, catalog, err := o.loadTarget(release)
 	if err != nil {
-		log.Printf("Error getting release %s: %v", releaseID, err)
+		log.Printf("Error loading firmware for release %s: %v", release.ID, err)
 		return
 	}
 
-	firmware, err := o.db.GetFirmwareByID(release.FirmwareID)
-	if err != nil {
-		log.Printf("Error getting firmware for release %s: %v", releaseID, err)
+	if target.State == database.FirmwareYanked {
+		log.Printf("Release %s targets yanked firmware %s, aborting", release.ID, target.Version)
+		o.applyAction(release.ID, rollout.ActionAbort, "firmware "+target.Version+" is yanked")
 		return
 	}
 
-	devices, err := o.db.ListDevices()
-	if err != nil {
-		log.Printf("Error getting devices for release %s: %v", releaseID, err)
+	if !o.resolveConflicts(release, target) {
 		return
 	}
 
-	canaryCount := 5
-	if len(devices) < canaryCount {
-		canaryCount = len(devices)
+	log.Printf("Starting release: %s", release.ID)
+
+	if !o.applyAction(release.ID, rollout.ActionStart, "") {
+		return
 	}
 
-	for i := 0; i < canaryCount; i++ {
-		device := devices[i]
-		cmd := &mqtt.UpdateCommand{
-			DeviceID:    device.ID,
-			FirmwareURL: "https://firmware.aura.example.com/" + firmware.ID,
-			Version:     firmware.Version,
-			Checksum:    firmware.Checksum,
-		}
-		if err := o.mqttClient.PublishUpdateCommand(device.ID, cmd); err != nil {
-			log.Printf("Error sending update command to device %s: %v", device.ID, err)
-		}
+	o.setHealth(&ReleaseHealth{
+		ReleaseID:      release.ID,
+		CurrentStage:   rollout.StageCanary,
+		LastUpdateTime: o.clock.Now(),
+	})
+
+	devices, err := o.db.ListDevices()
+	if err != nil {
+		log.Printf("Error getting devices for release %s: %v", release.ID, err)
+		return
 	}
 
-	log.Printf("Release %s moved to canary stage, sent to %d devices", releaseID, canaryCount)
+	dispatched := o.dispatchCanary(release.ID, devices, target, catalog)
+	log.Printf("Release %s moved to canary stage, sent to %d devices", release.ID, dispatched)
 }
 
-func (o *Orchestrator) monitorRelease(releaseID string) {
-	health, exists := o.healthMetrics[releaseID]
-	if !exists {
-		health = &ReleaseHealth{
-			ReleaseID:      releaseID,
-			CurrentStage:   "canary",
-			LastUpdateTime: time.Now(),
+// dispatchCanary selects the canary wave from devices and sends it the
+// release, returning how many devices were sent an update.
+func (o *Orchestrator) dispatchCanary(releaseID string, devices []database.Device, target *database.Firmware,
+	catalog map[string]*database.Firmware) int {
+	dispatched := 0
+	for _, device := range SelectCanaryDevices(releaseID, devices, target, catalog, o.canary, o.clock.Now()) {
+		if o.dispatchUpdate(releaseID, rollout.StageCanary, device, nil, target, catalog) {
+			dispatched++
 		}
-		o.healthMetrics[releaseID] = health
 	}
+	return dispatched
+}
 
-	health.SuccessCount++
-	health.TotalDevices++
+func (o *Orchestrator) monitorRelease(release database.Release) {
+	target, catalog, err := o.loadTarget(release)
+	if err != nil {
+		log.Printf("Error loading firmware for release %s: %v", release.ID, err)
+		return
+	}
 
-	successRate := float64(health.SuccessCount) / float64(health.TotalDevices)
+	o.expireUpdates(release.ID)
+	o.verifyRelease(release)
+	if release.Stage == rollout.StageProduction {
+		o.dispatchRemaining(release.ID, target, catalog)
+	}
+	o.advancePendingDevices(release.ID, target, catalog)
 
-	if successRate < 0.8 && health.TotalDevices > 5 {
-		log.Printf("Release %s failed health check (success rate: %.2f), rolling back", releaseID, successRate)
-		o.rollbackRelease(releaseID)
+	health, err := o.refreshHealth(release)
+	if err != nil {
+		log.Printf("Error computing health of release %s: %v", release.ID, err)
 		return
 	}
 
-	if health.TotalDevices > 10 && health.CurrentStage == "canary" {
-		log.Printf("Release %s passed canary stage, promoting to production", releaseID)
-		o.promoteRelease(releaseID, "production")
+	finished := health.SuccessCount + health.FailureCount
+	if finished == 0 {
+		if health.PendingCount == 0 {
+			o.finishIdleStage(release, target, catalog)
+		}
+		return
 	}
+	successRate := float64(health.SuccessCount) / float64(finished)
 
-	if health.TotalDevices > 50 && health.CurrentStage == "production" {
-		log.Printf("Release %s completed successfully", releaseID)
-		o.completeRelease(releaseID)
+	if successRate < minSuccessRate && (finished >= minHealthSample || health.PendingCount == 0) {
+		log.Printf("Release %s failed health check (success rate: %.2f), rolling back", release.ID, successRate)
+		o.rollbackRelease(release.ID, fmt.Sprintf("success rate %.2f below %.2f", successRate, minSuccessRate))
+		return
 	}
-}
 
-func (o *Orchestrator) promoteRelease(releaseID, stage string) {
-	err := o.db.UpdateReleaseStatus(releaseID, "in_progress", stage)
-	if err != nil {
-		log.Printf("Error promoting release %s: %v", releaseID, err)
+	if health.PendingCount > 0 {
 		return
 	}
 
-	if health, exists := o.healthMetrics[releaseID]; exists {
-		health.CurrentStage = stage
+	switch release.Stage {
+	case rollout.StageCanary:
+		log.Printf("Release %s passed canary stage, promoting to production", release.ID)
+		o.promoteRelease(release.ID)
+	case rollout.StageProduction:
+		log.Printf("Release %s completed successfully", release.ID)
+		o.completeRelease(release.ID)
 	}
-
-	log.Printf("Release %s promoted to %s stage", releaseID, stage)
 }
 
-func (o *Orchestrator) rollbackRelease(releaseID string) {
-	err := o.db.UpdateReleaseStatus(releaseID, "rolled_back", "rollback")
-	if err != nil {
-		log.Printf("Error rolling back release %s: %v", releaseID, err)
+// finishIdleStage moves on a release whose current wave has nothing pending
+// and nothing finished because every device in it was skipped or deferred.
+// A production wave is complete. A canary is drawn again from devices that
+// have become eligible since; while none has and some devices are deferred,
+// the release waits for them. Otherwise it is promoted if no device needs the
+// update, or aborted rather than sent to production without a healthy canary.
+func (o *Orchestrator) finishIdleStage(release database.Release, target *database.Firmware,
+	catalog map[string]*database.Firmware) {
+	if release.Stage == rollout.StageProduction {
+		log.Printf("Release %s completed with no devices left to update", release.ID)
+		o.completeRelease(release.ID)
+		return
+	}
+	if release.Stage != rollout.StageCanary {
 		return
 	}
 
-	devices, err := o.db.ListDevices()
+	releaseDevices, err := o.db.ListReleaseDevices(release.ID)
 	if err != nil {
-		log.Printf("Error getting devices for rollback: %v", err)
+		log.Printf("Error listing devices for release %s: %v", release.ID, err)
 		return
 	}
+	known := make(map[string]bool, len(releaseDevices))
+	for _, rd := range releaseDevices {
+		known[rd.DeviceID] = true
+	}
+	all, err := o.db.ListDevices()
+	if err != nil {
+		log.Printf("Error getting devices for release %s: %v", release.ID, err)
+		return
+	}
+	var devices []database.Device
+	for _, device := range all {
+		if !known[device.ID] {
+			devices = append(devices, device)
+		}
+	}
 
+	if o.dispatchCanary(release.ID, devices, target, catalog) > 0 {
+		return
+	}
+
+	sendable, deferred := false, false
+	now := o.clock.Now()
 	for _, device := range devices {
-		if err := o.mqttClient.PublishRollbackCommand(device.ID); err != nil {
-			log.Printf("Error sending rollback command to device %s: %v", device.ID, err)
+		switch planDispatch(device, target, catalog, o.canary, false, now).Status {
+		case rollout.DeviceSent, rollout.DeviceStepping:
+			sendable = true
+		case rollout.DeviceDeferred:
+			deferred = true
+		}
+	}
+	for _, rd := range releaseDevices {
+		if rd.Status == rollout.DeviceDeferred {
+			deferred = true
 		}
 	}
 
-	delete(o.healthMetrics, releaseID)
-	log.Printf("Release %s rolled back, rollback commands sent to all devices", releaseID)
+	switch {
+	case deferred:
+		return
+	case sendable:
+		log.Printf("Release %s could not send its canary to any device, aborting", release.ID)
+		if o.applyAction(release.ID, rollout.ActionAbort, "no canary device could be sent the update") {
+			o.deleteHealth(release.ID)
+		}
+	default:
+		log.Printf("Release %s has no devices that need the update, promoting to production", release.ID)
+		o.promoteRelease(release.ID)
+	}
 }
 
-func (o *Orchestrator) completeRelease(releaseID string) {
-	err := o.db.UpdateReleaseStatus(releaseID, "completed", "completed")
+func (o *Orchestrator) refreshHealth(release database.Release) (*ReleaseHealth, error) {
+	releaseDevices, err := o.db.ListReleaseDevices(release.ID)
 	if err != nil {
-		log.Printf("Error completing release %s: %v", releaseID, err)
+		return nil, err
+	}
+
+	health := &ReleaseHealth{
+		ReleaseID:      release.ID,
+		CurrentStage:   release.Stage,
+		LastUpdateTime: o.clock.Now(),
+	}
+	for _, rd := range releaseDevices {
+		switch rd.Status {
+		case rollout.DeviceUpdated:
+			health.SuccessCount++
+		case rollout.DeviceFailed, rollout.DeviceTimedOut:
+			health.FailureCount++
+		case rollout.DeviceQueued, rollout.DeviceSent, rollout.DeviceStepping, rollout.DeviceDownloading,
+			rollout.DeviceInstalling, rollout.DeviceRebooting, rollout.DeviceVerifying, rollout.DeviceScheduled,
+			rollout.DeviceRetrying:
+			health.PendingCount++
+		default:
+			continue
+		}
+		health.TotalDevices++
+	}
+
+	o.setHealth(health)
+	return health, nil
+}
+
+func (o *Orchestrator) promoteRelease(releaseID string) {
+	if !o.applyAction(releaseID, rollout.ActionPromote, "canary wave healthy") {
 		return
 	}
 
-	delete(o.healthMetrics, releaseID)
+	o.mu.Lock()
+	if health, exists := o.healthMetrics[releaseID]; exists {
+		health.CurrentStage = rollout.StageProduction
+	}
+	o.mu.Unlock()
+
+	log.Printf("Release %s promoted to %s stage", releaseID, rollout.StageProduction)
+}
+
+func (o *Orchestrator) completeRelease(releaseID string) {
+	if !o.applyAction(releaseID, rollout.ActionComplete, "all devices finished") {
+		return
+	}
+
+	o.deleteHealth(releaseID)
 	log.Printf("Release %s completed", releaseID)
 }
 
+func (o *Orchestrator) setHealth(health *ReleaseHealth) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.healthMetrics[health.ReleaseID] = health
+}
+
+func (o *Orchestrator) deleteHealth(releaseID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.healthMetrics, releaseID)
+}
+
+// GetReleaseHealth returns a snapshot of the release's last computed health,
+// or nil if the orchestrator is not tracking it.
 func (o *Orchestrator) GetReleaseHealth(releaseID string) *ReleaseHealth {
-	return o.healthMetrics[releaseID]
-}
\ No newline at end of file
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	health, exists := o.healthMetrics[releaseID]
+	if !exists {
+		return nil
+	}
+	snapshot := *health
+	return &snapshot
+}
+
+func (o *Orchestrator) Status() Status {
+	status := Status{
+		ReplicaID: o.elector.ReplicaID(),
+		Leader:    o.elector.IsLeader(),
+	}
+	leaderID, err := o.elector.CurrentLeader()
+	if err != nil {
+		log.Printf("Error looking up orchestrator leader: %v", err)
+	}
+	status.LeaderID = leaderID
+	return status
+}
@@ -0,0 +1,846 @@
+package ota
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/mqtt"
+	"github.com/10xdev4u-alt/aura/pkg/pki"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
+
+type fixture struct {
+	clock     *fakeClock
+	store     *fakeStore
+	releaseID string
+	devices   []string
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	clock := newFakeClock()
+	store := newFakeStore(clock)
+	store.addFirmware("1.0.0")
+	target := store.addFirmware("2.0.0")
+
+	f := &fixture{clock: clock, store: store}
+	for _, model := range []string{"aura-s1", "aura-s2"} {
+		for _, region := range []string{"eu", "us"} {
+			for i := 0; i < 2; i++ {
+				f.devices = append(f.devices, store.addDevice(model, region, "1.0.0"))
+			}
+		}
+	}
+	f.releaseID = store.addRelease(target)
+	return f
+}
+
+func (f *fixture) orchestrator(replicaID string, publisher *fakePublisher) *Orchestrator {
+	o := NewOrchestrator(f.store, publisher, Config{
+		Canary: CanaryPolicy{
+			Size:            3,
+			MinBatteryLevel: 20,
+			OfflineAfter:    15 * time.Minute,
+		},
+		Timeouts: UpdateTimeouts{
+			Acknowledge: time.Minute,
+			Download:    10 * time.Minute,
+			Install:     10 * time.Minute,
+			Reboot:      5 * time.Minute,
+		},
+		Retry: RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Minute,
+			MaxBackoff:     10 * time.Minute,
+		},
+		DownloadBaseURL: "https://downloads.test/",
+		ReplicaID:       replicaID,
+		LeaseTTL:        time.Minute,
+		Clock:           f.clock,
+	})
+	o.subscribe()
+	return o
+}
+
+func (f *fixture) assertRelease(t *testing.T, status, stage string) {
+	t.Helper()
+	release := f.store.release(f.releaseID)
+	if release.Status != status || release.Stage != stage {
+		t.Fatalf("release is %s/%s, want %s/%s", release.Status, release.Stage, status, stage)
+	}
+}
+
+func report(publisher *fakePublisher, commands []mqtt.UpdateCommand, status string) {
+	for _, cmd := range commands {
+		publisher.reportUpdate(mqtt.UpdateStatus{DeviceID: cmd.DeviceID, CommandID: cmd.CommandID, Status: status})
+	}
+}
+
+func TestCanaryDispatchesStratifiedSample(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+
+	o.processReleases()
+
+	f.assertRelease(t, rollout.StatusInProgress, rollout.StageCanary)
+	commands := publisher.sentUpdates()
+	if len(commands) != 3 {
+		t.Fatalf("sent %d update commands, want 3", len(commands))
+	}
+
+	strata := make(map[string]bool)
+	firmwareURL := "https://downloads.test/firmware/" + f.store.release(f.releaseID).FirmwareID
+	for _, cmd := range commands {
+		if cmd.FirmwareURL != firmwareURL {
+			t.Errorf("command %s has firmware URL %q, want %q", cmd.CommandID, cmd.FirmwareURL, firmwareURL)
+		}
+		if cmd.Version != "2.0.0" || cmd.CommandID == "" || cmd.ReleaseID != f.releaseID {
+			t.Errorf("unexpected command %+v", cmd)
+		}
+		if !cmd.IssuedAt.Equal(f.clock.Now()) || !cmd.ExpiresAt.After(cmd.IssuedAt) || cmd.HashAlgorithm != mqtt.HashSHA256 {
+			t.Errorf("command %s has issued_at %s, expires_at %s, hash %q", cmd.CommandID, cmd.IssuedAt,
+				cmd.ExpiresAt, cmd.HashAlgorithm)
+		}
+		rd := f.store.row(f.releaseID, cmd.DeviceID)
+		if rd.Status != rollout.DeviceSent || rd.Wave == nil || *rd.Wave != rollout.StageCanary || rd.Attempts != 1 {
+			t.Errorf("device %s row is %s wave %v attempts %d", cmd.DeviceID, rd.Status, rd.Wave, rd.Attempts)
+		}
+		device, _ := f.store.GetDeviceByID(cmd.DeviceID)
+		strata[stratumKey(*device)] = true
+	}
+	if len(strata) != 3 {
+		t.Errorf("canary covers %d strata, want 3", len(strata))
+	}
+
+	o.processReleases()
+	if n := len(publisher.sentUpdates()); n != 3 {
+		t.Errorf("second cycle sent %d commands in total, want the canary to be sent once", n)
+	}
+}
+
+func TestCanaryIsDrawnFromCompatibleDevices(t *testing.T) {
+	f := newFixture(t)
+	f.store.restrictFirmware(f.store.release(f.releaseID).FirmwareID, "aura-s2")
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+
+	o.processReleases()
+
+	commands := publisher.sentUpdates()
+	if len(commands) != 3 {
+		t.Fatalf("sent %d update commands, want a full canary of 3", len(commands))
+	}
+	for _, cmd := range commands {
+		device, _ := f.store.GetDeviceByID(cmd.DeviceID)
+		if *device.HardwareModel != "aura-s2" {
+			t.Errorf("canary includes incompatible device %s (%s)", device.ID, *device.HardwareModel)
+		}
+	}
+}
+
+func TestPartialTelemetryKeepsReadings(t *testing.T) {
+	f := newFixture(t)
+	low := make(map[string]bool)
+	for _, deviceID := range f.devices[:6] {
+		f.store.setBattery(deviceID, 5)
+		low[deviceID] = true
+	}
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+
+	// A report without readings must not make low-battery devices eligible.
+	for _, deviceID := range f.devices {
+		o.handleTelemetry(&mqtt.DeviceTelemetry{DeviceID: deviceID, Status: "healthy"})
+	}
+	o.processReleases()
+
+	commands := publisher.sentUpdates()
+	if len(commands) == 0 {
+		t.Fatal("no update commands sent")
+	}
+	for _, cmd := range commands {
+		if low[cmd.DeviceID] {
+			t.Errorf("canary includes low-battery device %s", cmd.DeviceID)
+		}
+	}
+}
+
+func TestUpdateCommandCarriesBuildSignature(t *testing.T) {
+	f := newFixture(t)
+	release := f.store.release(f.releaseID)
+	f.store.signFirmware(release.FirmwareID, []byte("build-signature"), "ed25519ph", "0123456789abcdef")
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+
+	o.processReleases()
+
+	commands := publisher.sentUpdates()
+	if len(commands) == 0 {
+		t.Fatal("no update commands sent")
+	}
+	for _, cmd := range commands {
+		if string(cmd.Signature) != "build-signature" || cmd.SignatureAlgorithm != "ed25519ph" ||
+			cmd.SignatureKeyID != "0123456789abcdef" {
+			t.Errorf("command %s has signature %q, algorithm %q, key %q", cmd.CommandID, cmd.Signature,
+				cmd.SignatureAlgorithm, cmd.SignatureKeyID)
+		}
+	}
+}
+
+func TestFirmwareURLIsSignedForDevice(t *testing.T) {
+	f := newFixture(t)
+	signer, err := pki.NewURLSigner([]pki.URLKey{{ID: "k1", Secret: []byte(strings.Repeat("s", 32))}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+	o.urlSigner = signer
+
+	o.processReleases()
+
+	firmwareID := f.store.release(f.releaseID).FirmwareID
+	for _, cmd := range publisher.sentUpdates() {
+		u, err := url.Parse(cmd.FirmwareURL)
+		if err != nil {
+			t.Fatalf("command %s has invalid URL %q: %v", cmd.CommandID, cmd.FirmwareURL, err)
+		}
+		deviceID, err := signer.Verify(firmwareID, u.Query(), f.clock.Now())
+		if err != nil || deviceID != cmd.DeviceID {
+			t.Errorf("URL %q verifies for device %q (%v), want %s", cmd.FirmwareURL, deviceID, err, cmd.DeviceID)
+		}
+		if _, err := signer.Verify(firmwareID, u.Query(), cmd.ExpiresAt.Add(31*time.Minute)); err != pki.ErrURLExpired {
+			t.Errorf("URL %q after the download window: got %v, want %v", cmd.FirmwareURL, err, pki.ErrURLExpired)
+		}
+	}
+}
+
+func TestBundleCommandListsArtifacts(t *testing.T) {
+	f := newFixture(t)
+	signer, err := pki.NewURLSigner([]pki.URLKey{{ID: "k1", Secret: []byte(strings.Repeat("s", 32))}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	firmwareID := f.store.release(f.releaseID).FirmwareID
+	f.store.makeBundle(firmwareID, "bootloader", "app")
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+	o.urlSigner = signer
+
+	o.processReleases()
+
+	commands := publisher.sentUpdates()
+	if len(commands) == 0 {
+		t.Fatal("no update commands sent")
+	}
+	for _, cmd := range commands {
+		if cmd.Bundle == nil || len(cmd.Bundle.Artifacts) != 2 {
+			t.Fatalf("command %s has bundle %+v, want 2 artifacts", cmd.CommandID, cmd.Bundle)
+		}
+		for i, artifact := range cmd.Bundle.Artifacts {
+			if artifact.InstallOrder != i+1 || artifact.Checksum != "sha256-"+artifact.Name {
+				t.Errorf("unexpected artifact %+v", artifact)
+			}
+			prefix := "https://downloads.test/firmware/" + firmwareID + "/artifacts/" + artifact.Name + "?"
+			if !strings.HasPrefix(artifact.URL, prefix) {
+				t.Errorf("artifact URL %q, want prefix %q", artifact.URL, prefix)
+				continue
+			}
+			u, _ := url.Parse(artifact.URL)
+			if deviceID, err := signer.Verify(firmwareID, u.Query(), f.clock.Now()); err != nil || deviceID != cmd.DeviceID {
+				t.Errorf("artifact URL %q verifies for device %q (%v), want %s", artifact.URL, deviceID, err, cmd.DeviceID)
+			}
+		}
+	}
+}
+
+func TestFailedDeltaFallsBackToFullImage(t *testing.T) {
+	f := newFixture(t)
+	targetID := f.store.release(f.releaseID).FirmwareID
+	firmwares, _ := f.store.ListFirmware()
+	baseID := ""
+	for _, firmware := range firmwares {
+		if firmware.Version == "1.0.0" {
+			baseID = firmware.ID
+		}
+	}
+	deltaID := f.store.addDelta(baseID, targetID)
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+	o.deltaUpdates = true
+
+	o.processReleases()
+	canary := publisher.sentUpdates()
+	if len(canary) == 0 {
+		t.Fatal("no update commands sent")
+	}
+	for _, cmd := range canary {
+		if cmd.Delta == nil {
+			t.Fatalf("command %s has no delta", cmd.CommandID)
+		}
+		if cmd.Delta.URL != "https://downloads.test/deltas/"+deltaID || cmd.Delta.BaseVersion != "1.0.0" ||
+			cmd.Delta.BaseChecksum != "sha256-1.0.0" || cmd.Delta.ResultChecksum != cmd.Checksum {
+			t.Errorf("command %s has delta %+v", cmd.CommandID, *cmd.Delta)
+		}
+		if cmd.FirmwareURL == "" {
+			t.Errorf("command %s has no full image URL to fall back to", cmd.CommandID)
+		}
+	}
+
+	failed := canary[0]
+	report(publisher, canary[:1], "failed")
+	if rd := f.store.row(f.releaseID, failed.DeviceID); rd.Status != rollout.DeviceRetrying || !rd.FullImage {
+		t.Fatalf("device with failed delta is %s (full image %v), want a full image retry", rd.Status, rd.FullImage)
+	}
+
+	o.processReleases()
+	resent := publisher.sentUpdates()[len(canary):]
+	if len(resent) != 1 || resent[0].DeviceID != failed.DeviceID {
+		t.Fatalf("resent %d commands, want the full image to the failed device", len(resent))
+	}
+	if resent[0].Delta != nil || resent[0].CommandID == failed.CommandID {
+		t.Errorf("resend has delta %+v and command ID %s, want a new full image command", resent[0].Delta,
+			resent[0].CommandID)
+	}
+
+	report(publisher, resent, "failed")
+	if rd := f.store.row(f.releaseID, failed.DeviceID); rd.Status != rollout.DeviceFailed {
+		t.Errorf("device that failed the full image is %s, want %s", rd.Status, rollout.DeviceFailed)
+	}
+}
+
+func TestFailedPublishIsRetriedWithoutCountingAttempt(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{publishErr: errors.New("broker unavailable")}
+	o := f.orchestrator("replica-a", publisher)
+
+	o.processReleases()
+	releaseDevices, err := f.store.ListReleaseDevices(f.releaseID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unsent []string
+	for _, rd := range releaseDevices {
+		if rd.Status != rollout.DeviceRetrying {
+			t.Errorf("device %s is %s, want %s", rd.DeviceID, rd.Status, rollout.DeviceRetrying)
+			continue
+		}
+		deviceID := rd.DeviceID
+		unsent = append(unsent, deviceID)
+		if rd.Attempts != 0 || rd.TimeoutAt != nil || rd.NextAttemptAt == nil {
+			t.Errorf("device %s has %d attempts, deadline %v and retry at %v, want an uncounted retry",
+				deviceID, rd.Attempts, rd.TimeoutAt, rd.NextAttemptAt)
+		}
+	}
+	if len(unsent) != 3 {
+		t.Fatalf("%d devices retrying, want the canary of 3", len(unsent))
+	}
+
+	publisher.mu.Lock()
+	publisher.publishErr = nil
+	publisher.mu.Unlock()
+	f.clock.Advance(time.Minute)
+	o.processReleases()
+	if n := len(publisher.sentUpdates()); n != len(unsent) {
+		t.Fatalf("sent %d update commands, want %d", n, len(unsent))
+	}
+	for _, deviceID := range unsent {
+		if rd := f.store.row(f.releaseID, deviceID); rd.Status != rollout.DeviceSent || rd.Attempts != 1 {
+			t.Errorf("device %s is %s with %d attempts, want %s with 1", deviceID, rd.Status, rd.Attempts,
+				rollout.DeviceSent)
+		}
+	}
+}
+
+func TestHealthyCanaryPromotesAndCompletes(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+
+	o.processReleases()
+	canary := publisher.sentUpdates()
+	report(publisher, canary, "downloading")
+	report(publisher, canary, "completed")
+
+	o.processReleases()
+	f.assertRelease(t, rollout.StatusInProgress, rollout.StageProduction)
+	if health := o.GetReleaseHealth(f.releaseID); health == nil || health.SuccessCount != 3 {
+		t.Fatalf("canary health is %+v, want 3 successes", health)
+	}
+
+	o.processReleases()
+	production := publisher.sentUpdates()[len(canary):]
+	if len(production) != len(f.devices)-len(canary) {
+		t.Fatalf("production wave sent %d commands, want %d", len(production), len(f.devices)-len(canary))
+	}
+	for _, cmd := range production {
+		if rd := f.store.row(f.releaseID, cmd.DeviceID); *rd.Wave != rollout.StageProduction {
+			t.Errorf("device %s recorded in wave %s", cmd.DeviceID, *rd.Wave)
+		}
+	}
+
+	report(publisher, production, "completed")
+	o.processReleases()
+	f.assertRelease(t, rollout.StatusCompleted, rollout.StageCompleted)
+	if health := o.GetReleaseHealth(f.releaseID); health != nil {
+		t.Errorf("completed release still tracked: %+v", health)
+	}
+}
+
+func TestIdleCanaryMovesOn(t *testing.T) {
+	t.Run("no device needs the update", func(t *testing.T) {
+		f := newFixture(t)
+		f.store.restrictFirmware(f.store.release(f.releaseID).FirmwareID, "aura-s9")
+		publisher := &fakePublisher{}
+		o := f.orchestrator("replica-a", publisher)
+
+		o.processReleases()
+		o.processReleases()
+		f.assertRelease(t, rollout.StatusInProgress, rollout.StageProduction)
+		o.processReleases()
+		f.assertRelease(t, rollout.StatusCompleted, rollout.StageCompleted)
+		if n := len(publisher.sentUpdates()); n != 0 {
+			t.Errorf("sent %d update commands, want none", n)
+		}
+	})
+
+	t.Run("canary waits for offline devices", func(t *testing.T) {
+		f := newFixture(t)
+		f.clock.Advance(time.Hour)
+		publisher := &fakePublisher{}
+		o := f.orchestrator("replica-a", publisher)
+
+		o.processReleases()
+		o.processReleases()
+		f.assertRelease(t, rollout.StatusInProgress, rollout.StageCanary)
+		if n := len(publisher.sentUpdates()); n != 0 {
+			t.Fatalf("sent %d update commands to offline devices", n)
+		}
+
+		for _, device := range f.devices {
+			publisher.reportTelemetry(mqtt.DeviceTelemetry{DeviceID: device, Status: "healthy"})
+		}
+		o.processReleases()
+		f.assertRelease(t, rollout.StatusInProgress, rollout.StageCanary)
+		if n := len(publisher.sentUpdates()); n != 3 {
+			t.Errorf("sent %d update commands once devices came online, want a canary of 3", n)
+		}
+	})
+
+	t.Run("no device can be a canary", func(t *testing.T) {
+		f := newFixture(t)
+		for _, device := range f.devices {
+			f.store.setBattery(device, 5)
+		}
+		publisher := &fakePublisher{}
+		o := f.orchestrator("replica-a", publisher)
+
+		o.processReleases()
+		o.processReleases()
+		f.assertRelease(t, rollout.StatusAborted, rollout.StageCanary)
+		if n := len(publisher.sentUpdates()); n != 0 {
+			t.Errorf("sent %d update commands, want none", n)
+		}
+	})
+}
+
+func TestFailedCanaryRollsBack(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+
+	o.processReleases()
+	canary := publisher.sentUpdates()
+	report(publisher, canary, "failed")
+
+	o.processReleases()
+	f.assertRelease(t, rollout.StatusRollingBack, rollout.StageRollback)
+
+	rollbacks := publisher.sentRollbacks()
+	if len(rollbacks) != len(canary) {
+		t.Fatalf("sent %d rollback commands, want %d", len(rollbacks), len(canary))
+	}
+	for _, cmd := range rollbacks {
+		if cmd.ReleaseID != f.releaseID || cmd.Version != "1.0.0" || cmd.FirmwareURL == "" {
+			t.Errorf("unexpected rollback command %+v", cmd)
+		}
+	}
+	if n := len(publisher.sentUpdates()); n != len(canary) {
+		t.Errorf("sent %d update commands, want only the canary", n)
+	}
+
+	o.processReleases()
+	if n := len(publisher.sentRollbacks()); n != len(canary) {
+		t.Errorf("rollback resent: %d commands", n)
+	}
+
+	for _, cmd := range rollbacks {
+		publisher.reportRollback(mqtt.RollbackStatus{DeviceID: cmd.DeviceID, ReleaseID: f.releaseID, Status: "completed"})
+	}
+	o.processReleases()
+	f.assertRelease(t, rollout.StatusRolledBack, rollout.StageRollback)
+}
+
+func TestUnacknowledgedRollbackIsResentThenFails(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+
+	o.processReleases()
+	canary := publisher.sentUpdates()
+	report(publisher, canary, "failed")
+	o.processReleases()
+	f.assertRelease(t, rollout.StatusRollingBack, rollout.StageRollback)
+
+	silent := canary[0].DeviceID
+	for _, cmd := range canary[1:] {
+		publisher.reportRollback(mqtt.RollbackStatus{DeviceID: cmd.DeviceID, ReleaseID: f.releaseID, Status: "completed"})
+	}
+
+	// The deadline is the whole update's budget of 26 minutes; the backoff
+	// doubles from one minute.
+	f.clock.Advance(25 * time.Minute)
+	o.processReleases()
+	if n := len(publisher.sentRollbacks()); n != len(canary) {
+		t.Fatalf("rollback resent before the deadline: %d commands", n)
+	}
+
+	for attempt := 2; attempt <= 3; attempt++ {
+		f.clock.Advance(time.Minute)
+		o.processReleases()
+		if rd := f.store.row(f.releaseID, silent); rd.Status != rollout.DeviceRollbackSent || rd.NextAttemptAt == nil {
+			t.Fatalf("attempt %d: silent device is %s with no retry scheduled", attempt, rd.Status)
+		}
+		f.clock.Advance(time.Duration(attempt-1) * time.Minute)
+		o.processReleases()
+		rollbacks := publisher.sentRollbacks()
+		if n := len(rollbacks); n != len(canary)+attempt-1 {
+			t.Fatalf("attempt %d: sent %d rollback commands, want %d", attempt, n, len(canary)+attempt-1)
+		}
+		if last := rollbacks[len(rollbacks)-1]; last.DeviceID != silent {
+			t.Errorf("attempt %d: resent rollback to %s, want %s", attempt, last.DeviceID, silent)
+		}
+		if rd := f.store.row(f.releaseID, silent); rd.Attempts != attempt {
+			t.Errorf("silent device has %d attempts, want %d", rd.Attempts, attempt)
+		}
+		f.clock.Advance(25 * time.Minute)
+	}
+
+	f.clock.Advance(time.Minute)
+	o.processReleases()
+	if rd := f.store.row(f.releaseID, silent); rd.Status != rollout.DeviceRollbackFailed {
+		t.Errorf("silent device is %s, want %s", rd.Status, rollout.DeviceRollbackFailed)
+	}
+	if n := len(publisher.sentRollbacks()); n != len(canary)+2 {
+		t.Errorf("sent %d rollback commands, want %d", n, len(canary)+2)
+	}
+	f.assertRelease(t, rollout.StatusRolledBack, rollout.StageRollback)
+}
+
+func TestRestartRecoversInFlightRelease(t *testing.T) {
+	f := newFixture(t)
+	first := &fakePublisher{}
+	f.orchestrator("replica-a", first).processReleases()
+	canary := first.sentUpdates()
+
+	// The first replica goes away; a new one picks up from the store alone.
+	second := &fakePublisher{}
+	o := f.orchestrator("replica-b", second)
+	report(second, canary[:2], "completed")
+
+	f.clock.Advance(2 * time.Minute)
+	o.processReleases()
+	silent := canary[2]
+	if rd := f.store.row(f.releaseID, silent.DeviceID); rd.Status != rollout.DeviceRetrying {
+		t.Fatalf("silent device is %s, want %s", rd.Status, rollout.DeviceRetrying)
+	}
+	if n := len(second.sentUpdates()); n != 0 {
+		t.Fatalf("resent %d commands before backoff elapsed", n)
+	}
+
+	f.clock.Advance(time.Minute)
+	o.processReleases()
+	resent := second.sentUpdates()
+	if len(resent) != 1 || resent[0].DeviceID != silent.DeviceID {
+		t.Fatalf("resent %+v, want only device %s", resent, silent.DeviceID)
+	}
+	if resent[0].CommandID != silent.CommandID {
+		t.Errorf("retry used command ID %s, want %s", resent[0].CommandID, silent.CommandID)
+	}
+	if rd := f.store.row(f.releaseID, silent.DeviceID); rd.Attempts != 2 {
+		t.Errorf("silent device has %d attempts, want 2", rd.Attempts)
+	}
+
+	report(second, resent, "completed")
+	o.processReleases()
+	f.assertRelease(t, rollout.StatusInProgress, rollout.StageProduction)
+}
+
+func TestTimedOutDevicesCountAsFailures(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+
+	o.processReleases()
+	for cycle := 0; cycle < 10 && f.store.release(f.releaseID).Status == rollout.StatusInProgress; cycle++ {
+		f.clock.Advance(time.Hour)
+		o.processReleases()
+	}
+	if n := len(publisher.sentUpdates()); n != 9 {
+		t.Errorf("sent %d update commands, want 3 attempts to each canary device", n)
+	}
+
+	f.assertRelease(t, rollout.StatusRollingBack, rollout.StageRollback)
+	for _, cmd := range publisher.sentUpdates()[:3] {
+		if rd := f.store.row(f.releaseID, cmd.DeviceID); rd.Status != rollout.DeviceRollbackSent {
+			t.Errorf("device %s is %s, want %s", cmd.DeviceID, rd.Status, rollout.DeviceRollbackSent)
+		}
+	}
+}
+
+func TestConcurrentReportsWhileProcessing(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+
+	o.processReleases()
+	canary := publisher.sentUpdates()
+
+	battery := 80.0
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			o.processReleases()
+		}
+	}()
+	for _, cmd := range canary {
+		wg.Add(1)
+		go func(cmd mqtt.UpdateCommand) {
+			defer wg.Done()
+			publisher.reportTelemetry(mqtt.DeviceTelemetry{DeviceID: cmd.DeviceID, Status: "healthy",
+				BatteryLevel: &battery})
+			report(publisher, []mqtt.UpdateCommand{cmd}, "downloading")
+			report(publisher, []mqtt.UpdateCommand{cmd}, "installing")
+			report(publisher, []mqtt.UpdateCommand{cmd}, "completed")
+			publisher.reportTelemetry(mqtt.DeviceTelemetry{DeviceID: cmd.DeviceID, Status: "healthy",
+				FirmwareVersion: cmd.Version})
+		}(cmd)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			o.GetReleaseHealth(f.releaseID)
+			o.Status()
+		}
+	}()
+	wg.Wait()
+
+	o.processReleases()
+	o.processReleases()
+	release := f.store.release(f.releaseID)
+	if release.Stage != rollout.StageProduction {
+		t.Errorf("release is %s/%s after a healthy canary, want production", release.Status, release.Stage)
+	}
+}
+
+func TestStartProcessesReleaseChangesAndStopResigns(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{}
+	o := NewOrchestrator(f.store, publisher, Config{
+		PollInterval: time.Hour,
+		Canary:       CanaryPolicy{Size: 3},
+		ReplicaID:    "replica-a",
+		LeaseTTL:     time.Minute,
+		Clock:        f.clock,
+	})
+
+	go o.Start()
+
+	deadline := time.After(5 * time.Second)
+	for f.store.release(f.releaseID).Status == rollout.StatusPending {
+		select {
+		case f.store.changes <- f.releaseID:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			o.Stop()
+			t.Fatal("release was not started after a change notification")
+		}
+	}
+
+	o.Stop()
+	if lease, _ := f.store.GetLease(leaderLeaseName); lease != nil {
+		t.Errorf("lease still held by %s after stop", lease.Holder)
+	}
+	if !o.stopping() {
+		t.Error("orchestrator does not report stopping")
+	}
+}
+
+func TestOverlappingReleaseQueuesBehindEarlierRelease(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+	later := f.store.addRelease(f.store.addFirmware("3.0.0"))
+
+	o.processReleases()
+	f.assertRelease(t, rollout.StatusInProgress, rollout.StageCanary)
+	if release := f.store.release(later); release.Status != rollout.StatusPending {
+		t.Fatalf("overlapping release is %s, want it queued as pending", release.Status)
+	}
+	for _, cmd := range publisher.sentUpdates() {
+		if cmd.Version != "2.0.0" {
+			t.Errorf("sent %s to device %s while the earlier release is open", cmd.Version, cmd.DeviceID)
+		}
+	}
+
+	f.store.setReleaseState(f.releaseID, rollout.StatusAborted, rollout.StageCanary)
+	o.processReleases()
+	if release := f.store.release(later); release.Status != rollout.StatusInProgress {
+		t.Errorf("queued release is %s after the earlier release ended, want in_progress", release.Status)
+	}
+}
+
+func TestOverlappingReleaseRejected(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+	o.conflictPolicy = ConflictReject
+	later := f.store.addRelease(f.store.addFirmware("3.0.0"))
+
+	o.processReleases()
+	f.assertRelease(t, rollout.StatusInProgress, rollout.StageCanary)
+	if release := f.store.release(later); release.Status != rollout.StatusAborted {
+		t.Errorf("overlapping release is %s, want aborted", release.Status)
+	}
+}
+
+func TestDeviceHeldByAnotherReleaseIsDeferred(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+
+	o.processReleases()
+	canary := publisher.sentUpdates()
+
+	// A release that started without the conflict check, such as one started
+	// by an older replica, must still not reach devices the first one holds.
+	later := f.store.addRelease(f.store.addFirmware("3.0.0"))
+	f.store.setReleaseState(later, rollout.StatusInProgress, rollout.StageProduction)
+	o.processReleases()
+
+	held := make(map[string]bool)
+	for _, cmd := range canary {
+		held[cmd.DeviceID] = true
+		rd := f.store.row(later, cmd.DeviceID)
+		if rd.Status != rollout.DeviceDeferred || rd.Reason == nil || *rd.Reason != "held by release "+f.releaseID {
+			t.Errorf("held device %s is %s in the later release, want deferred", cmd.DeviceID, rd.Status)
+		}
+	}
+	for _, cmd := range publisher.sentUpdates()[len(canary):] {
+		if held[cmd.DeviceID] {
+			t.Errorf("device %s sent a second update while held", cmd.DeviceID)
+		}
+	}
+
+	report(publisher, canary, "completed")
+	o.processReleases()
+	for _, cmd := range canary {
+		if rd := f.store.row(later, cmd.DeviceID); rd.Status != rollout.DeviceSent {
+			t.Errorf("released device %s is %s in the later release, want sent", cmd.DeviceID, rd.Status)
+		}
+	}
+}
+
+func TestYankedFirmwareRaisesAlerts(t *testing.T) {
+	f := newFixture(t)
+	publisher := &fakePublisher{}
+	o := f.orchestrator("replica-a", publisher)
+	yanked := f.store.addFirmware("0.9.0")
+	device := f.store.addDevice("aura-s1", "eu", "0.9.0")
+
+	o.alertYankedFirmware()
+	if alerts := publisher.sentAlerts(); len(alerts) != 0 {
+		t.Fatalf("sent %d alerts before the firmware was yanked", len(alerts))
+	}
+
+	f.store.setFirmwareState(yanked, database.FirmwareYanked, "bricks on boot")
+	f.clock.Advance(time.Hour)
+	o.alertYankedFirmware()
+	alerts := publisher.sentAlerts()
+	if len(alerts) != 1 {
+		t.Fatalf("sent %d alerts, want 1", len(alerts))
+	}
+	alert := alerts[0]
+	if alert.Type != mqtt.AlertYankedFirmware || alert.FirmwareID != yanked || alert.Reason != "bricks on boot" ||
+		len(alert.DeviceIDs) != 1 || alert.DeviceIDs[0] != device {
+		t.Errorf("unexpected alert %+v", alert)
+	}
+
+	f.clock.Advance(time.Minute)
+	o.alertYankedFirmware()
+	if n := len(publisher.sentAlerts()); n != 1 {
+		t.Errorf("sent %d alerts within the alert interval, want 1", n)
+	}
+
+	publisher.reportTelemetry(mqtt.DeviceTelemetry{DeviceID: device, FirmwareVersion: "2.0.0", Status: "healthy"})
+	f.clock.Advance(time.Hour)
+	o.alertYankedFirmware()
+	if n := len(publisher.sentAlerts()); n != 1 {
+		t.Errorf("sent %d alerts after the device moved off the firmware, want 1", n)
+	}
+}
+
+func TestYankedFirmwareIsNotSent(t *testing.T) {
+	t.Run("yanked target", func(t *testing.T) {
+		f := newFixture(t)
+		f.store.setFirmwareState(f.store.release(f.releaseID).FirmwareID, database.FirmwareYanked, "bricks on boot")
+		publisher := &fakePublisher{}
+		o := f.orchestrator("replica-a", publisher)
+
+		o.processReleases()
+		f.assertRelease(t, rollout.StatusAborted, rollout.StageCanary)
+		if n := len(publisher.sentUpdates()); n != 0 {
+			t.Errorf("sent %d update commands for yanked firmware", n)
+		}
+	})
+
+	t.Run("yanked upgrade step", func(t *testing.T) {
+		f := newFixture(t)
+		step := f.store.addFirmware("1.5.0")
+		f.store.setUpgradePath(f.store.release(f.releaseID).FirmwareID, "1.5.0")
+		f.store.setFirmwareState(step, database.FirmwareYanked, "bricks on boot")
+		publisher := &fakePublisher{}
+		o := f.orchestrator("replica-a", publisher)
+
+		o.processReleases()
+		o.processReleases()
+		f.assertRelease(t, rollout.StatusInProgress, rollout.StageCanary)
+		if n := len(publisher.sentUpdates()); n != 0 {
+			t.Fatalf("sent %d update commands through a yanked step", n)
+		}
+		device, _ := f.store.GetDeviceByID(f.devices[0])
+		target, _ := f.store.GetFirmwareByID(f.store.release(f.releaseID).FirmwareID)
+		firmwares, _ := f.store.ListFirmware()
+		plan := PlanUpdate(*device, target, Catalog(firmwares))
+		if plan.Status != rollout.DeviceDeferred || plan.Reason != "intermediate version 1.5.0 is yanked" {
+			t.Errorf("plan is %s (%s), want deferred for the yanked step", plan.Status, plan.Reason)
+		}
+
+		f.store.setFirmwareState(step, database.FirmwareActive, "")
+		o.processReleases()
+		commands := publisher.sentUpdates()
+		if len(commands) != 3 || commands[0].Version != "1.5.0" {
+			t.Errorf("sent %+v after restoring the step, want a canary of 3 stepping through 1.5.0", commands)
+		}
+	})
+}
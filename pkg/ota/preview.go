@@ -0,0 +1,101 @@
+package ota
+
+import (
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+	"github.com/10xdev4u-alt/aura/pkg/version"
+)
+
+type PreviewDevice struct {
+	DeviceID       string  `json:"device_id"`
+	HardwareModel  *string `json:"hardware_model,omitempty"`
+	Region         *string `json:"region,omitempty"`
+	CurrentVersion *string `json:"current_version,omitempty"`
+	NextVersion    string  `json:"next_version,omitempty"`
+	Status         string  `json:"status"`
+	Reason         string  `json:"reason,omitempty"`
+	DownloadBytes  int64   `json:"download_bytes,omitempty"`
+}
+
+type PreviewWave struct {
+	Wave          string          `json:"wave"`
+	Devices       []PreviewDevice `json:"devices"`
+	DownloadBytes int64           `json:"download_bytes"`
+}
+
+// ReleasePreview is what a release would do if it started now. Excluded
+// devices would not be sent an update: they are incompatible, already on the
+// target version, or deferred until they come online or meet a requirement.
+type ReleasePreview struct {
+	Waves         []PreviewWave   `json:"waves"`
+	Excluded      []PreviewDevice `json:"excluded"`
+	TargetDevices int             `json:"target_devices"`
+	DownloadBytes int64           `json:"download_bytes"`
+}
+
+// PreviewRelease resolves the devices each wave of a release would target
+// using the same canary selection and update planning as the orchestrator.
+// Canary ranking depends on the release ID, so a preview made before the
+// release exists shows a representative canary rather than the exact one.
+func PreviewRelease(releaseID string, target *database.Firmware, catalog map[string]*database.Firmware,
+	devices []database.Device, policy CanaryPolicy, now time.Time) ReleasePreview {
+	preview := ReleasePreview{Excluded: []PreviewDevice{}}
+	canary := PreviewWave{Wave: rollout.StageCanary, Devices: []PreviewDevice{}}
+	production := PreviewWave{Wave: rollout.StageProduction, Devices: []PreviewDevice{}}
+
+	inCanary := make(map[string]bool)
+	for _, device := range SelectCanaryDevices(releaseID, devices, target, catalog, policy, now) {
+		inCanary[device.ID] = true
+	}
+
+	for _, device := range devices {
+		plan := planDispatch(device, target, catalog, policy, false, now)
+		entry := PreviewDevice{
+			DeviceID:       device.ID,
+			HardwareModel:  device.HardwareModel,
+			Region:         device.Region,
+			CurrentVersion: device.FirmwareVersion,
+			Status:         plan.Status,
+			Reason:         plan.Reason,
+		}
+		if plan.Firmware == nil {
+			preview.Excluded = append(preview.Excluded, entry)
+			continue
+		}
+		entry.NextVersion = plan.Firmware.Version
+		entry.DownloadBytes = downloadBytes(device, target, catalog)
+
+		wave := &production
+		if inCanary[device.ID] {
+			wave = &canary
+		}
+		wave.Devices = append(wave.Devices, entry)
+		wave.DownloadBytes += entry.DownloadBytes
+	}
+
+	preview.Waves = []PreviewWave{canary, production}
+	preview.TargetDevices = len(canary.Devices) + len(production.Devices)
+	preview.DownloadBytes = canary.DownloadBytes + production.DownloadBytes
+	return preview
+}
+
+// downloadBytes estimates what a device downloads to reach the target: every
+// step of the upgrade path newer than its current version, then the target.
+func downloadBytes(device database.Device, target *database.Firmware, catalog map[string]*database.Firmware) int64 {
+	total := target.FileSize
+	if device.FirmwareVersion == nil {
+		return total
+	}
+	for _, step := range target.UpgradePath {
+		cmp, err := version.Compare(*device.FirmwareVersion, step)
+		if err != nil || cmp >= 0 {
+			continue
+		}
+		if stepFirmware, ok := catalog[step]; ok {
+			total += stepFirmware.FileSize
+		}
+	}
+	return total
+}
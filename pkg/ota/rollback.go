@@ -0,0 +1,208 @@
+package ota
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/mqtt"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
+
+func (o *Orchestrator) rollbackRelease(releaseID, reason string) {
+	if !o.applyAction(releaseID, rollout.ActionRollback, reason) {
+		return
+	}
+
+	o.deleteHealth(releaseID)
+	o.continueRollback(releaseID)
+}
+
+// continueRollback sends rollback commands to every device that was sent
+// this release and has not been told to roll back yet, resends them to
+// devices that have not acknowledged in time, and marks the release rolled
+// back once every device has acknowledged or given up.
+func (o *Orchestrator) continueRollback(releaseID string) {
+	releaseDevices, err := o.db.ListReleaseDevices(releaseID)
+	if err != nil {
+		log.Printf("Error getting devices for rollback of release %s: %v", releaseID, err)
+		return
+	}
+
+	catalog, err := o.firmwareCatalog()
+	if err != nil {
+		log.Printf("Error loading firmware catalog for rollback of release %s: %v", releaseID, err)
+		return
+	}
+
+	now := o.clock.Now()
+	sent, outstanding := 0, 0
+	for _, rd := range releaseDevices {
+		switch rd.Status {
+		case rollout.DeviceSent, rollout.DeviceStepping, rollout.DeviceDownloading, rollout.DeviceInstalling,
+			rollout.DeviceRebooting, rollout.DeviceVerifying, rollout.DeviceRetrying, rollout.DeviceUpdated,
+			rollout.DeviceFailed, rollout.DeviceTimedOut:
+			if !o.stopping() && o.sendRollback(releaseID, rd, catalog) {
+				sent++
+			}
+			outstanding++
+		case rollout.DeviceRollbackSent:
+			if rd.TimeoutAt != nil && !now.Before(*rd.TimeoutAt) {
+				if o.expireRollback(releaseID, rd, now) {
+					outstanding++
+				}
+				continue
+			}
+			if rd.TimeoutAt == nil && rd.NextAttemptAt != nil && !now.Before(*rd.NextAttemptAt) &&
+				!o.stopping() && o.sendRollback(releaseID, rd, catalog) {
+				sent++
+			}
+			outstanding++
+		}
+	}
+
+	if sent > 0 {
+		log.Printf("Release %s rolling back, rollback commands sent to %d devices", releaseID, sent)
+	}
+	if outstanding > 0 {
+		return
+	}
+
+	if !o.applyAction(releaseID, rollout.ActionRollbackEnd, "all devices reported or timed out") {
+		return
+	}
+	log.Printf("Release %s rolled back", releaseID)
+}
+
+func (o *Orchestrator) sendRollback(releaseID string, rd database.ReleaseDevice, catalog map[string]*database.Firmware) bool {
+	now := o.clock.Now()
+	cmd := &mqtt.RollbackCommand{
+		DeviceID:  rd.DeviceID,
+		CommandID: rand.Text(),
+		ReleaseID: releaseID,
+		Action:    "rollback",
+		IssuedAt:  now,
+		ExpiresAt: now.Add(o.commandTTL),
+	}
+	if rd.PreviousVersion != nil {
+		cmd.Version = *rd.PreviousVersion
+		if previous, ok := catalog[*rd.PreviousVersion]; ok {
+			cmd.FirmwareURL = o.firmwareURL(previous, rd.DeviceID, now)
+			cmd.FileSize = previous.FileSize
+			cmd.HashAlgorithm = mqtt.HashSHA256
+			cmd.Checksum = previous.Checksum
+			signature := previous.BuildSignature()
+			cmd.Signature = signature.Signature
+			cmd.SignatureAlgorithm = signature.Algorithm
+			cmd.SignatureKeyID = signature.KeyID
+			if previous.Bundle {
+				bundle, err := o.bundleUpdate(previous, rd.DeviceID, now)
+				if err != nil {
+					log.Printf("Error listing artifacts of firmware %s: %v", previous.Version, err)
+					return false
+				}
+				cmd.Bundle = bundle
+			}
+		} else {
+			log.Printf("Previous firmware %s for device %s is not in the catalog, sending rollback without image",
+				*rd.PreviousVersion, rd.DeviceID)
+		}
+	}
+
+	attempts := 1
+	if rd.Status == rollout.DeviceRollbackSent {
+		attempts = rd.Attempts + 1
+	}
+	err := o.db.HoldReleaseDevice(releaseID, rd.DeviceID, rollout.DeviceRollbackSent, "", attempts,
+		o.deadline(rollout.DeviceRollbackSent))
+	if err != nil {
+		log.Printf("Error recording rollback of device %s: %v", rd.DeviceID, err)
+		return false
+	}
+
+	o.dispatcher.Wait()
+	if err := o.mqttClient.PublishRollbackCommand(rd.DeviceID, cmd); err != nil {
+		log.Printf("Error sending rollback command to device %s: %v", rd.DeviceID, err)
+		if err := o.db.RestoreReleaseDevice(rd); err != nil {
+			log.Printf("Error recording rollback of device %s: %v", rd.DeviceID, err)
+		}
+		return false
+	}
+	return true
+}
+
+// expireRollback handles a device that has not reported the outcome of its
+// rollback in time. The command is resent after a backoff until the retry
+// policy's attempts are used up, then the device is marked rollback_failed.
+// It reports whether the device is still outstanding.
+func (o *Orchestrator) expireRollback(releaseID string, rd database.ReleaseDevice, now time.Time) bool {
+	reason := fmt.Sprintf("no rollback report within %s (attempt %d)", o.timeouts.For(rd.Status), rd.Attempts)
+	status := rollout.DeviceRollbackFailed
+	var retryAt *time.Time
+	if rd.Attempts < o.retry.MaxAttempts {
+		status = rollout.DeviceRollbackSent
+		at := now.Add(o.retry.Backoff(rd.Attempts))
+		retryAt = &at
+	}
+
+	expired, err := o.db.ExpireReleaseDevice(releaseID, rd.DeviceID, rd.Status, status, reason, retryAt, now)
+	if err != nil {
+		log.Printf("Error expiring rollback of device %s: %v", rd.DeviceID, err)
+		return true
+	}
+	if !expired {
+		// The device reported in the meantime; the next cycle counts it.
+		return true
+	}
+	if status == rollout.DeviceRollbackFailed {
+		log.Printf("Release %s rollback failed for device %s: %s", releaseID, rd.DeviceID, reason)
+		return false
+	}
+	log.Printf("Release %s rollback of device %s will be resent: %s", releaseID, rd.DeviceID, reason)
+	return true
+}
+
+// firmwareURL is where a device downloads the image from the apiserver's
+// download server. When URL signing is configured the URL is valid only for
+// this device, until the command expires plus the time allowed to download.
+func (o *Orchestrator) firmwareURL(firmware *database.Firmware, deviceID string, now time.Time) string {
+	return o.downloadURL + "/firmware/" + firmware.ID + o.firmwareQuery(firmware, deviceID, now)
+}
+
+// firmwareQuery is the query string that signs firmware and artifact URLs,
+// or empty if URL signing is not configured.
+func (o *Orchestrator) firmwareQuery(firmware *database.Firmware, deviceID string, now time.Time) string {
+	if !o.urlSigner.Enabled() {
+		return ""
+	}
+	expires := now.Add(o.commandTTL + o.timeouts.Download)
+	return "?" + o.urlSigner.Sign(firmware.ID, deviceID, expires).Encode()
+}
+
+// bundleUpdate lists a bundle's artifacts with URLs signed like the
+// firmware's own.
+func (o *Orchestrator) bundleUpdate(firmware *database.Firmware, deviceID string, now time.Time) (*mqtt.BundleUpdate, error) {
+	artifacts, err := o.db.ListFirmwareArtifacts(firmware.ID)
+	if err != nil {
+		return nil, err
+	}
+	query := o.firmwareQuery(firmware, deviceID, now)
+	bundle := &mqtt.BundleUpdate{}
+	for _, artifact := range artifacts {
+		update := mqtt.ArtifactUpdate{
+			Name:          artifact.Name,
+			InstallOrder:  artifact.InstallOrder,
+			URL:           o.downloadURL + "/firmware/" + firmware.ID + "/artifacts/" + artifact.Name + query,
+			FileSize:      artifact.FileSize,
+			HashAlgorithm: mqtt.HashSHA256,
+			Checksum:      artifact.Checksum,
+		}
+		if artifact.Type != nil {
+			update.Type = *artifact.Type
+		}
+		bundle.Artifacts = append(bundle.Artifacts, update)
+	}
+	return bundle, nil
+}
@@ -0,0 +1,67 @@
+package ota
+
+import (
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/mqtt"
+)
+
+// LeaseStore holds the lease used for leader election.
+type LeaseStore interface {
+	AcquireLease(name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(name, holder string) error
+	GetLease(name string) (*database.Lease, error)
+}
+
+// Store is the persistent state the orchestrator reads and writes.
+// *database.DB implements it.
+type Store interface {
+	LeaseStore
+
+	GetDeviceByID(deviceID string) (*database.Device, error)
+	ListDevices() ([]database.Device, error)
+	UpdateDeviceTelemetry(deviceID string, update database.TelemetryUpdate) error
+
+	GetFirmwareByID(firmwareID string) (*database.Firmware, error)
+	ListFirmware() ([]database.Firmware, error)
+	GetFirmwareDelta(baseFirmwareID, targetFirmwareID string) (*database.FirmwareDelta, error)
+	ListFirmwareArtifacts(firmwareID string) ([]database.FirmwareArtifact, error)
+
+	GetReleaseByID(releaseID string) (*database.Release, error)
+	ListReleasesByStatus(statuses []string) ([]database.Release, error)
+	ApplyReleaseAction(releaseID, action, actor, reason string) (*database.Release, error)
+	WatchReleases(stop <-chan struct{}) (<-chan string, error)
+
+	ListReleaseDevices(releaseID string) ([]database.ReleaseDevice, error)
+	ListActiveReleaseDevices(deviceID, status string) ([]database.ReleaseDevice, error)
+	CountActiveReleaseDevices(statuses []string) ([]database.StatusCount, error)
+	UpsertReleaseDevice(update database.ReleaseDeviceUpdate) error
+	UpdateReleaseDeviceStatus(releaseID, deviceID, status, reason string) error
+	HoldReleaseDevice(releaseID, deviceID, status, reason string, attempts int, timeoutAt *time.Time) error
+	RestoreReleaseDevice(rd database.ReleaseDevice) error
+	UpdateActiveReleaseDeviceStatus(deviceID, commandID string, from []string, status, reason string,
+		timeoutAt *time.Time) error
+	FallBackToFullImage(deviceID, commandID, reason string, retryAt time.Time) (bool, error)
+	ResolveReleaseDevice(releaseID, deviceID, from, status, reason string) (bool, error)
+	ExpireReleaseDevice(releaseID, deviceID, from, status, reason string, nextAttemptAt *time.Time,
+		now time.Time) (bool, error)
+
+	ListMaintenanceWindows() ([]database.MaintenanceWindow, error)
+}
+
+// Publisher carries commands to devices and their reports back.
+// *mqtt.Client implements it.
+type Publisher interface {
+	SubscribeToTelemetry(handler mqtt.TelemetryHandler) error
+	SubscribeToUpdateStatus(handler mqtt.UpdateStatusHandler) error
+	SubscribeToRollbackStatus(handler mqtt.RollbackStatusHandler) error
+	PublishUpdateCommand(deviceID string, cmd *mqtt.UpdateCommand) error
+	PublishRollbackCommand(deviceID string, cmd *mqtt.RollbackCommand) error
+	PublishFirmwareAlert(alert *mqtt.FirmwareAlert) error
+}
+
+var (
+	_ Store     = (*database.DB)(nil)
+	_ Publisher = (*mqtt.Client)(nil)
+)
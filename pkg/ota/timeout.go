@@ -0,0 +1,136 @@
+package ota
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/rollout"
+)
+
+// UpdateTimeouts bound how long a device may stay in each stage of an update
+// without reporting progress. A zero value disables the timeout for that
+// stage.
+type UpdateTimeouts struct {
+	Acknowledge time.Duration
+	Download    time.Duration
+	Install     time.Duration
+	Reboot      time.Duration
+}
+
+// For returns the timeout for a device in the given status. Devices being
+// stepped through an intermediate version only report the version they end
+// up on, and devices rolling back only report the outcome, so both get the
+// whole update's budget.
+func (t UpdateTimeouts) For(status string) time.Duration {
+	switch status {
+	case rollout.DeviceSent:
+		return t.Acknowledge
+	case rollout.DeviceDownloading:
+		return t.Download
+	case rollout.DeviceInstalling:
+		return t.Install
+	case rollout.DeviceRebooting:
+		return t.Reboot
+	case rollout.DeviceStepping, rollout.DeviceRollbackSent:
+		if t.Acknowledge == 0 || t.Download == 0 || t.Install == 0 || t.Reboot == 0 {
+			return 0
+		}
+		return t.Acknowledge + t.Download + t.Install + t.Reboot
+	}
+	return 0
+}
+
+// RetryPolicy controls how often an update that timed out is resent.
+// MaxAttempts counts the first send; a value of one or less disables retries.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Backoff returns how long to wait before resending after the given number
+// of attempts, doubling from InitialBackoff up to MaxBackoff.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			break
+		}
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+var stageNames = map[string]string{
+	rollout.DeviceSent:        "acknowledge",
+	rollout.DeviceDownloading: "download",
+	rollout.DeviceInstalling:  "install",
+	rollout.DeviceRebooting:   "reboot",
+	rollout.DeviceStepping:    "upgrade step",
+}
+
+func (o *Orchestrator) deadline(status string) *time.Time {
+	timeout := o.timeouts.For(status)
+	if timeout <= 0 {
+		return nil
+	}
+	deadline := o.clock.Now().Add(timeout)
+	return &deadline
+}
+
+// expireUpdates finds devices that have not reported progress within the
+// current stage's timeout and either schedules a retry with backoff or gives
+// up on them. Devices that give up count as failures in the release's health.
+func (o *Orchestrator) expireUpdates(releaseID string) {
+	releaseDevices, err := o.db.ListReleaseDevices(releaseID)
+	if err != nil {
+		log.Printf("Error listing devices for release %s: %v", releaseID, err)
+		return
+	}
+
+	now := o.clock.Now()
+	for _, rd := range releaseDevices {
+		if rd.TimeoutAt == nil || now.Before(*rd.TimeoutAt) {
+			continue
+		}
+		stage, ok := stageNames[rd.Status]
+		if !ok {
+			continue
+		}
+
+		reason := fmt.Sprintf("no %s report within %s (attempt %d)", stage, o.timeouts.For(rd.Status), rd.Attempts)
+		status := rollout.DeviceTimedOut
+		var retryAt *time.Time
+		if rd.Attempts < o.retry.MaxAttempts {
+			status = rollout.DeviceRetrying
+			at := now.Add(o.retry.Backoff(rd.Attempts))
+			retryAt = &at
+		}
+
+		expired, err := o.db.ExpireReleaseDevice(releaseID, rd.DeviceID, rd.Status, status, reason, retryAt, now)
+		if err != nil {
+			log.Printf("Error expiring update of device %s: %v", rd.DeviceID, err)
+			continue
+		}
+		if !expired {
+			continue
+		}
+		o.dispatcher.Release(releaseID)
+		log.Printf("Release %s %s for device %s: %s", releaseID, status, rd.DeviceID, reason)
+	}
+}
+
+// commandIDFor returns the command ID to send to a device. Resending the same
+// image reuses the previous ID so the device can recognise a duplicate.
+func commandIDFor(prior *database.ReleaseDevice, firmware *database.Firmware) string {
+	if prior != nil && prior.CommandID != nil && prior.FirmwareID != nil && *prior.FirmwareID == firmware.ID {
+		return *prior.CommandID
+	}
+	return rand.Text()
+}
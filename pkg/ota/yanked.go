@@ -0,0 +1,71 @@
+package ota
+
+import (
+	"log"
+	"slices"
+
+	"github.com/10xdev4u-alt/aura/pkg/database"
+	"github.com/10xdev4u-alt/aura/pkg/mqtt"
+)
+
+// alertYankedFirmware raises an alert for each yanked firmware that devices
+// still report running. It runs on the main loop at most once per alert
+// interval, so an alert repeats for as long as any device stays on the
+// firmware.
+func (o *Orchestrator) alertYankedFirmware() {
+	now := o.clock.Now()
+	if !o.lastYanked.IsZero() && now.Sub(o.lastYanked) < o.yankedAlerts {
+		return
+	}
+	o.lastYanked = now
+
+	firmwares, err := o.db.ListFirmware()
+	if err != nil {
+		log.Printf("Error listing firmware for yanked firmware alerts: %v", err)
+		return
+	}
+	yanked := make(map[string]*database.Firmware)
+	for i := range firmwares {
+		if firmwares[i].State == database.FirmwareYanked {
+			yanked[firmwares[i].Version] = &firmwares[i]
+		}
+	}
+	if len(yanked) == 0 {
+		return
+	}
+
+	devices, err := o.db.ListDevices()
+	if err != nil {
+		log.Printf("Error listing devices for yanked firmware alerts: %v", err)
+		return
+	}
+	running := make(map[string][]string)
+	for _, device := range devices {
+		if device.FirmwareVersion != nil && yanked[*device.FirmwareVersion] != nil {
+			running[*device.FirmwareVersion] = append(running[*device.FirmwareVersion], device.ID)
+		}
+	}
+
+	versions := make([]string, 0, len(running))
+	for version := range running {
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	for _, version := range versions {
+		firmware := yanked[version]
+		alert := &mqtt.FirmwareAlert{
+			Type:       mqtt.AlertYankedFirmware,
+			FirmwareID: firmware.ID,
+			Version:    version,
+			DeviceIDs:  running[version],
+			Timestamp:  now,
+		}
+		if firmware.StateReason != nil {
+			alert.Reason = *firmware.StateReason
+		}
+		log.Printf("ALERT: %d devices still run yanked firmware %s", len(alert.DeviceIDs), version)
+		if err := o.mqttClient.PublishFirmwareAlert(alert); err != nil {
+			log.Printf("Error publishing yanked firmware alert for %s: %v", version, err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CertificateAuthority issues the client certificates devices receive at
+// provisioning. It is kept on disk so certificates stay valid across
+// restarts and the download server can trust the same CA.
+type CertificateAuthority struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+// LoadCertificateAuthority reads the PEM CA certificate at certPath and its
+// PKCS #1 PEM key at keyPath. If no key exists yet, a CA is generated and
+// written to both paths.
+func LoadCertificateAuthority(certPath, keyPath string) (*CertificateAuthority, error) {
+	keyData, err := os.ReadFile(keyPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createCertificateAuthority(certPath, keyPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %w", err)
+	}
+	certData, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	return parseCertificateAuthority(certData, keyData)
+}
+
+func createCertificateAuthority(certPath, keyPath string) (*CertificateAuthority, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"Aura IoT Platform"},
+			CommonName:   "Aura Root CA",
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate directory: %w", err)
+	}
+	file, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		// Another service created the CA first.
+		return LoadCertificateAuthority(certPath, keyPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA key: %w", err)
+	}
+	defer file.Close()
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := pem.Encode(file, block); err != nil {
+		return nil, fmt.Errorf("failed to write CA key: %w", err)
+	}
+
+	// The certificate appears in a single rename, so a reader never sees it
+	// half written.
+	tmp, err := os.CreateTemp(filepath.Dir(certPath), "."+filepath.Base(certPath)+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	err = pem.Encode(tmp, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), certPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to write CA certificate: %w", err)
+	}
+
+	return &CertificateAuthority{cert: cert, key: key}, nil
+}
+
+func parseCertificateAuthority(certData, keyData []byte) (*CertificateAuthority, error) {
+	block, _ := pem.Decode(certData)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("CA certificate is not a PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, errors.New("CA certificate is not a CA")
+	}
+
+	block, _ = pem.Decode(keyData)
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		return nil, errors.New("CA key is not a PEM RSA private key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, errors.New("CA key does not match the CA certificate")
+	}
+
+	return &CertificateAuthority{cert: cert, key: key}, nil
+}
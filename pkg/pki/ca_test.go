@@ -0,0 +1,83 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCertificateAuthority(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "certs", "ca.pem")
+	keyPath := filepath.Join(dir, "keys", "ca-key.pem")
+
+	created, err := LoadCertificateAuthority(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(keyPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("CA key stat = %v, %v, want mode 0600", info, err)
+	}
+
+	loaded, err := LoadCertificateAuthority(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.cert.Raw, created.cert.Raw) || !loaded.key.Equal(created.key) {
+		t.Error("second load returned a different CA")
+	}
+
+	certData, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeyData := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(otherKey)})
+
+	tests := []struct {
+		name string
+		cert []byte
+		key  []byte
+	}{
+		{name: "corrupt key", cert: certData, key: []byte("not a key")},
+		{name: "truncated key", cert: certData, key: keyData[:len(keyData)/2]},
+		{name: "mismatched key", cert: certData, key: otherKeyData},
+		{name: "corrupt certificate", cert: []byte("not a certificate"), key: keyData},
+		{name: "missing certificate", key: keyData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			certPath := filepath.Join(dir, "ca.pem")
+			keyPath := filepath.Join(dir, "ca-key.pem")
+			if tt.cert != nil {
+				if err := os.WriteFile(certPath, tt.cert, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(keyPath, tt.key, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := LoadCertificateAuthority(certPath, keyPath); err == nil {
+				t.Fatal("LoadCertificateAuthority accepted an invalid CA")
+			}
+			// The files must be left alone rather than replaced by a new CA.
+			if data, err := os.ReadFile(keyPath); err != nil || !bytes.Equal(data, tt.key) {
+				t.Error("CA key was overwritten")
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Firmware signature algorithms. Ed25519 signatures use the prehashed
+// Ed25519ph variant (RFC 8032) so images never have to be held in memory;
+// ECDSA signatures are over the SHA-256 digest of the image, as produced by
+// cosign sign-blob.
+const (
+	AlgorithmEd25519ph       = "ed25519ph"
+	AlgorithmECDSAP256SHA256 = "ecdsa-p256-sha256"
+)
+
+var (
+	ErrNoBuildKeys        = errors.New("no trusted build keys configured")
+	ErrSignatureMismatch  = errors.New("signature does not match any trusted build key")
+	errUnsupportedKeyType = errors.New("unsupported build key type")
+)
+
+// FirmwareDigests are the digests of a firmware image that signatures are
+// verified against.
+type FirmwareDigests struct {
+	SHA256 []byte
+	SHA512 []byte
+}
+
+// FirmwareSignature is a verified signature and the build key that made it.
+type FirmwareSignature struct {
+	Signature []byte
+	Algorithm string
+	KeyID     string
+}
+
+type buildKey struct {
+	id  string
+	der []byte
+	key crypto.PublicKey
+}
+
+// FirmwareVerifier checks firmware signatures against the public keys of
+// trusted build systems.
+type FirmwareVerifier struct {
+	keys []buildKey
+}
+
+// LoadFirmwareVerifier reads the PEM public keys in the given files. Each file
+// may hold several keys.
+func LoadFirmwareVerifier(paths []string) (*FirmwareVerifier, error) {
+	verifier := &FirmwareVerifier{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read build key: %w", err)
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "PUBLIC KEY" {
+				continue
+			}
+			key, err := parseBuildKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid build key in %s: %w", path, err)
+			}
+			verifier.keys = append(verifier.keys, key)
+		}
+	}
+	return verifier, nil
+}
+
+func parseBuildKey(der []byte) (buildKey, error) {
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return buildKey{}, err
+	}
+	switch key := parsed.(type) {
+	case ed25519.PublicKey:
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return buildKey{}, fmt.Errorf("%w: ECDSA keys must use P-256", errUnsupportedKeyType)
+		}
+	default:
+		return buildKey{}, fmt.Errorf("%w: %T", errUnsupportedKeyType, parsed)
+	}
+	sum := sha256.Sum256(der)
+	return buildKey{id: hex.EncodeToString(sum[:8]), der: der, key: parsed}, nil
+}
+
+// Enabled reports whether any build keys are trusted. Uploads must be signed
+// when they are.
+func (v *FirmwareVerifier) Enabled() bool {
+	return v != nil && len(v.keys) > 0
+}
+
+// Verify checks a signature against every trusted build key and returns the
+// one that made it.
+func (v *FirmwareVerifier) Verify(signature []byte, digests FirmwareDigests) (*FirmwareSignature, error) {
+	if !v.Enabled() {
+		return nil, ErrNoBuildKeys
+	}
+	for _, key := range v.keys {
+		switch pub := key.key.(type) {
+		case ed25519.PublicKey:
+			opts := &ed25519.Options{Hash: crypto.SHA512}
+			if ed25519.VerifyWithOptions(pub, digests.SHA512, signature, opts) == nil {
+				return &FirmwareSignature{Signature: signature, Algorithm: AlgorithmEd25519ph, KeyID: key.id}, nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(pub, digests.SHA256, signature) {
+				return &FirmwareSignature{Signature: signature, Algorithm: AlgorithmECDSAP256SHA256, KeyID: key.id}, nil
+			}
+		}
+	}
+	return nil, ErrSignatureMismatch
+}
+
+// PublicKeysPEM returns the trusted build keys for devices to verify firmware
+// with.
+func (v *FirmwareVerifier) PublicKeysPEM() []string {
+	if v == nil {
+		return nil
+	}
+	keys := make([]string, len(v.keys))
+	for i, key := range v.keys {
+		keys[i] = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: key.der}))
+	}
+	return keys
+}
+
+// cosignBundle is the part of a cosign bundle that carries the signature.
+// The transparency log entry is kept with the firmware but not checked here.
+type cosignBundle struct {
+	Base64Signature string `json:"base64Signature"`
+}
+
+// ParseSignature decodes an uploaded detached signature, which may be raw
+// bytes, base64 text, or a cosign-style JSON bundle. It reports whether the
+// upload was a bundle.
+func ParseSignature(data []byte) (signature []byte, bundle bool, err error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		var b cosignBundle
+		if err := json.Unmarshal([]byte(trimmed), &b); err != nil {
+			return nil, false, fmt.Errorf("invalid signature bundle: %w", err)
+		}
+		if b.Base64Signature == "" {
+			return nil, false, errors.New("signature bundle has no base64Signature")
+		}
+		signature, err := base64.StdEncoding.DecodeString(b.Base64Signature)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid signature in bundle: %w", err)
+		}
+		return signature, true, nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(trimmed); err == nil && len(decoded) > 0 {
+		return decoded, false, nil
+	}
+	return data, false, nil
+}
@@ -0,0 +1,204 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePublicKeys(t *testing.T, keys ...crypto.PublicKey) string {
+	t.Helper()
+	var data []byte
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	path := filepath.Join(t.TempDir(), "build-keys.pem")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func digestsOf(image []byte) FirmwareDigests {
+	sha256Sum := sha256.Sum256(image)
+	sha512Sum := sha512.Sum512(image)
+	return FirmwareDigests{SHA256: sha256Sum[:], SHA512: sha512Sum[:]}
+}
+
+func TestFirmwareVerifier(t *testing.T) {
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherEdKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherECKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := LoadFirmwareVerifier([]string{writePublicKeys(t, edPub, &ecKey.PublicKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(verifier.PublicKeysPEM()); n != 2 {
+		t.Fatalf("loaded %d build keys, want 2", n)
+	}
+
+	image := []byte("firmware image contents")
+	digests := digestsOf(image)
+	flipped := bytes.Clone(image)
+	flipped[0] ^= 1
+
+	signEd25519ph := func(key ed25519.PrivateKey, digests FirmwareDigests) []byte {
+		signature, err := key.Sign(nil, digests.SHA512, &ed25519.Options{Hash: crypto.SHA512})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+	signECDSA := func(key *ecdsa.PrivateKey, digests FirmwareDigests) []byte {
+		signature, err := ecdsa.SignASN1(rand.Reader, key, digests.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+	rsaSignature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digests.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		signature []byte
+		digests   FirmwareDigests
+		algorithm string
+	}{
+		{name: "ed25519ph", signature: signEd25519ph(edKey, digests), digests: digests,
+			algorithm: AlgorithmEd25519ph},
+		{name: "ecdsa p-256", signature: signECDSA(ecKey, digests), digests: digests,
+			algorithm: AlgorithmECDSAP256SHA256},
+		{name: "ed25519ph wrong key", signature: signEd25519ph(otherEdKey, digests), digests: digests},
+		{name: "ecdsa wrong key", signature: signECDSA(otherECKey, digests), digests: digests},
+		{name: "ed25519ph flipped image byte", signature: signEd25519ph(edKey, digests), digests: digestsOf(flipped)},
+		{name: "ecdsa flipped image byte", signature: signECDSA(ecKey, digests), digests: digestsOf(flipped)},
+		{name: "pure ed25519", signature: ed25519.Sign(edKey, image), digests: digests},
+		{name: "unknown algorithm", signature: rsaSignature, digests: digests},
+		{name: "malformed", signature: []byte("not a signature"), digests: digests},
+		{name: "empty", digests: digests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verified, err := verifier.Verify(tt.signature, tt.digests)
+			if tt.algorithm == "" {
+				if !errors.Is(err, ErrSignatureMismatch) {
+					t.Fatalf("Verify error = %v, want %v", err, ErrSignatureMismatch)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verified.Algorithm != tt.algorithm || verified.KeyID == "" || !bytes.Equal(verified.Signature, tt.signature) {
+				t.Errorf("verified %+v, want algorithm %s", verified, tt.algorithm)
+			}
+		})
+	}
+
+	t.Run("no keys", func(t *testing.T) {
+		empty, err := LoadFirmwareVerifier(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if empty.Enabled() {
+			t.Error("verifier without keys is enabled")
+		}
+		if _, err := empty.Verify(signEd25519ph(edKey, digests), digests); !errors.Is(err, ErrNoBuildKeys) {
+			t.Errorf("Verify error = %v, want %v", err, ErrNoBuildKeys)
+		}
+	})
+}
+
+func TestLoadFirmwareVerifierRejectsUnsupportedKeys(t *testing.T) {
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, key := range map[string]crypto.PublicKey{"ecdsa p-384": &p384.PublicKey, "rsa": &rsaKey.PublicKey} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadFirmwareVerifier([]string{writePublicKeys(t, key)}); !errors.Is(err, errUnsupportedKeyType) {
+				t.Errorf("LoadFirmwareVerifier error = %v, want %v", err, errUnsupportedKeyType)
+			}
+		})
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	raw := []byte{0x30, 0x45, 0x02, 0x20, 0xff, 0x00}
+	encoded := base64.StdEncoding.EncodeToString(raw)
+
+	tests := []struct {
+		name      string
+		data      string
+		signature []byte
+		bundle    bool
+		invalid   bool
+	}{
+		{name: "raw", data: string(raw), signature: raw},
+		{name: "base64", data: encoded + "\n", signature: raw},
+		{name: "cosign bundle", data: `{"base64Signature": "` + encoded + `", "cert": "", "rekorBundle": {}}`,
+			signature: raw, bundle: true},
+		{name: "bundle without signature", data: `{"rekorBundle": {}}`, invalid: true},
+		{name: "bundle with bad base64", data: `{"base64Signature": "%%%"}`, invalid: true},
+		{name: "malformed bundle", data: `{"base64Signature": `, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature, bundle, err := ParseSignature([]byte(tt.data))
+			if tt.invalid {
+				if err == nil {
+					t.Fatal("ParseSignature accepted an invalid signature")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(signature, tt.signature) || bundle != tt.bundle {
+				t.Errorf("ParseSignature = %x, %v, want %x, %v", signature, bundle, tt.signature, tt.bundle)
+			}
+		})
+	}
+}
@@ -12,43 +12,19 @@ import (
 )
 
 type PKIService struct {
-	caCert *x509.Certificate
-	caKey  *rsa.PrivateKey
+	caCert       *x509.Certificate
+	caKey        *rsa.PrivateKey
+	updateSigner *UpdateSigner
+	buildKeys    *FirmwareVerifier
 }
 
-func NewPKIService() (*PKIService, error) {
-	caKey, err := rsa.GenerateKey(rand.Reader, 4096)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate CA key: %w", err)
-	}
-
-	caCert := &x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject: pkix.Name{
-			Organization: []string{"Aura IoT Platform"},
-			CommonName:   "Aura Root CA",
-		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(10, 0, 0),
-		IsCA:                  true,
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-		BasicConstraintsValid: true,
-	}
-
-	caCertBytes, err := x509.CreateCertificate(rand.Reader, caCert, caCert, &caKey.PublicKey, caKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
-	}
-
-	caCert, err = x509.ParseCertificate(caCertBytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
-	}
-
+func NewPKIService(ca *CertificateAuthority, updateSigner *UpdateSigner, buildKeys *FirmwareVerifier) *PKIService {
 	return &PKIService{
-		caCert: caCert,
-		caKey:  caKey,
-	}, nil
+		caCert:       ca.cert,
+		caKey:        ca.key,
+		updateSigner: updateSigner,
+		buildKeys:    buildKeys,
+	}
 }
 
 func (p *PKIService) IssueCertificate(deviceID string) (certPEM, keyPEM string, err error) {
@@ -98,3 +74,13 @@ func (p *PKIService) GetCACertPEM() string {
 		Bytes: p.caCert.Raw,
 	}))
 }
+
+// UpdateSigner returns the key that signs commands sent to devices.
+func (p *PKIService) UpdateSigner() *UpdateSigner {
+	return p.updateSigner
+}
+
+// BuildKeys returns the trusted keys that firmware images are signed with.
+func (p *PKIService) BuildKeys() *FirmwareVerifier {
+	return p.buildKeys
+}
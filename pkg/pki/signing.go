@@ -0,0 +1,134 @@
+package pki
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// UpdateSigningAlgorithm is the signature algorithm of the update-signing key.
+const UpdateSigningAlgorithm = "ed25519"
+
+// UpdateSigner signs the commands sent to devices. Its key is separate from
+// the CA key: devices receive the public half at provisioning and reject
+// commands that it did not sign.
+type UpdateSigner struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// LoadUpdateSigner reads the PKCS #8 PEM update-signing key at path. If no
+// key exists yet, one is generated and written there, so every service that
+// shares the path uses the same key.
+func LoadUpdateSigner(path string) (*UpdateSigner, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createUpdateSigner(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read update signing key: %w", err)
+	}
+	return parseUpdateSigner(data)
+}
+
+func createUpdateSigner(path string) (*UpdateSigner, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate update signing key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode update signing key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		// Another service created the key first.
+		return LoadUpdateSigner(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create update signing key: %w", err)
+	}
+	defer file.Close()
+
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return nil, fmt.Errorf("failed to write update signing key: %w", err)
+	}
+	return newUpdateSigner(key), nil
+}
+
+func parseUpdateSigner(data []byte) (*UpdateSigner, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("update signing key is not a PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse update signing key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("update signing key is %T, want an Ed25519 key", parsed)
+	}
+	return newUpdateSigner(key), nil
+}
+
+func newUpdateSigner(key ed25519.PrivateKey) *UpdateSigner {
+	return &UpdateSigner{key: key, keyID: KeyID(key.Public().(ed25519.PublicKey))}
+}
+
+// KeyID identifies a public key by the first 8 bytes of its SHA-256 digest,
+// so devices can hold several keys while one is being rotated.
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *UpdateSigner) KeyID() string {
+	return s.keyID
+}
+
+func (s *UpdateSigner) Algorithm() string {
+	return UpdateSigningAlgorithm
+}
+
+// Sign returns a detached signature over message.
+func (s *UpdateSigner) Sign(message []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, message), nil
+}
+
+// Verify checks a signature made by Sign, as a device holding the public key
+// does.
+func (s *UpdateSigner) Verify(keyID, algorithm string, message, signature []byte) error {
+	if keyID != s.keyID {
+		return fmt.Errorf("unknown key ID %q", keyID)
+	}
+	if algorithm != UpdateSigningAlgorithm {
+		return fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	if !ed25519.Verify(s.key.Public().(ed25519.PublicKey), message, signature) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// PublicKeyPEM returns the PKIX PEM encoding of the key devices verify
+// commands with.
+func (s *UpdateSigner) PublicKeyPEM() string {
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		return ""
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
@@ -0,0 +1,155 @@
+package pki
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters of a signed download URL.
+const (
+	URLParamDevice    = "device"
+	URLParamExpires   = "expires"
+	URLParamKeyID     = "kid"
+	URLParamSignature = "sig"
+)
+
+var (
+	ErrURLNotSigned = errors.New("download URL is not signed")
+	ErrURLExpired   = errors.New("download URL has expired")
+	ErrURLSignature = errors.New("download URL signature is invalid")
+)
+
+// URLKey is a named HMAC key for download URLs.
+type URLKey struct {
+	ID     string
+	Secret []byte
+}
+
+// URLSigner signs download URLs so that each is valid for one firmware
+// image, one device and a limited time. The first key signs; every key
+// verifies, so a key can be rotated out by adding its successor first and
+// dropping it once the URLs it signed have expired.
+type URLSigner struct {
+	keys []URLKey
+}
+
+func NewURLSigner(keys []URLKey) (*URLSigner, error) {
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key.ID == "" || len(key.Secret) < 32 {
+			return nil, fmt.Errorf("URL key %q must have an ID and at least 32 bytes of secret", key.ID)
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("duplicate URL key %q", key.ID)
+		}
+		seen[key.ID] = true
+	}
+	return &URLSigner{keys: keys}, nil
+}
+
+// LoadURLKey reads the secret of a URL key from path. If no secret exists
+// yet, one is generated and written there, so every service that shares the
+// path uses the same key.
+func LoadURLKey(id, path string) (URLKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createURLKey(id, path)
+	}
+	if err != nil {
+		return URLKey{}, fmt.Errorf("failed to read URL key: %w", err)
+	}
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return URLKey{}, fmt.Errorf("failed to decode URL key %s: %w", id, err)
+	}
+	return URLKey{ID: id, Secret: secret}, nil
+}
+
+func createURLKey(id, path string) (URLKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return URLKey{}, fmt.Errorf("failed to generate URL key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return URLKey{}, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		// Another service created the key first.
+		return LoadURLKey(id, path)
+	}
+	if err != nil {
+		return URLKey{}, fmt.Errorf("failed to create URL key: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(base64.StdEncoding.EncodeToString(secret) + "\n"); err != nil {
+		return URLKey{}, fmt.Errorf("failed to write URL key: %w", err)
+	}
+	return URLKey{ID: id, Secret: secret}, nil
+}
+
+// Enabled reports whether URLs are signed.
+func (s *URLSigner) Enabled() bool {
+	return s != nil && len(s.keys) > 0
+}
+
+// Sign returns the query parameters that authorize deviceID to download
+// firmwareID until expires.
+func (s *URLSigner) Sign(firmwareID, deviceID string, expires time.Time) url.Values {
+	key := s.keys[0]
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return url.Values{
+		URLParamDevice:    {deviceID},
+		URLParamExpires:   {exp},
+		URLParamKeyID:     {key.ID},
+		URLParamSignature: {urlSignature(key.Secret, firmwareID, deviceID, exp)},
+	}
+}
+
+// Verify checks the signed query parameters of a download URL for firmwareID
+// and returns the device it was issued to.
+func (s *URLSigner) Verify(firmwareID string, query url.Values, now time.Time) (string, error) {
+	deviceID, exp := query.Get(URLParamDevice), query.Get(URLParamExpires)
+	keyID, sig := query.Get(URLParamKeyID), query.Get(URLParamSignature)
+	if !s.Enabled() || sig == "" {
+		return "", ErrURLNotSigned
+	}
+
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", ErrURLSignature
+	}
+	for _, key := range s.keys {
+		if key.ID != keyID {
+			continue
+		}
+		want := urlSignature(key.Secret, firmwareID, deviceID, exp)
+		if !hmac.Equal([]byte(sig), []byte(want)) {
+			return "", ErrURLSignature
+		}
+		if !now.Before(time.Unix(expires, 0)) {
+			return "", ErrURLExpired
+		}
+		return deviceID, nil
+	}
+	return "", ErrURLSignature
+}
+
+func urlSignature(secret []byte, firmwareID, deviceID, expires string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(firmwareID + "\n" + deviceID + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
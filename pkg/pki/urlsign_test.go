@@ -0,0 +1,112 @@
+package pki
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	keyA := URLKey{ID: "a", Secret: bytes.Repeat([]byte{'a'}, 32)}
+	keyB := URLKey{ID: "b", Secret: bytes.Repeat([]byte{'b'}, 32)}
+	newSigner := func(keys ...URLKey) *URLSigner {
+		t.Helper()
+		signer, err := NewURLSigner(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	signed := newSigner(keyA).Sign("fw-1", "device-1", expires)
+
+	with := func(param, value string) url.Values {
+		query := url.Values{}
+		for k, v := range signed {
+			query[k] = append([]string(nil), v...)
+		}
+		query.Set(param, value)
+		return query
+	}
+
+	tests := []struct {
+		name       string
+		verifier   *URLSigner
+		firmwareID string
+		query      url.Values
+		now        time.Time
+		err        error
+	}{
+		{name: "valid", verifier: newSigner(keyA), firmwareID: "fw-1", query: signed, now: now},
+		{name: "after rotation", verifier: newSigner(keyB, keyA), firmwareID: "fw-1", query: signed, now: now},
+		{name: "key dropped", verifier: newSigner(keyB), firmwareID: "fw-1", query: signed, now: now,
+			err: ErrURLSignature},
+		{name: "expired", verifier: newSigner(keyA), firmwareID: "fw-1", query: signed, now: expires,
+			err: ErrURLExpired},
+		{name: "tampered firmware", verifier: newSigner(keyA), firmwareID: "fw-2", query: signed, now: now,
+			err: ErrURLSignature},
+		{name: "tampered device", verifier: newSigner(keyA), firmwareID: "fw-1",
+			query: with(URLParamDevice, "device-2"), now: now, err: ErrURLSignature},
+		{name: "tampered expiry", verifier: newSigner(keyA), firmwareID: "fw-1",
+			query: with(URLParamExpires, "9999999999"), now: now, err: ErrURLSignature},
+		{name: "malformed expiry", verifier: newSigner(keyA), firmwareID: "fw-1",
+			query: with(URLParamExpires, "soon"), now: now, err: ErrURLSignature},
+		{name: "unknown kid", verifier: newSigner(keyA), firmwareID: "fw-1", query: with(URLParamKeyID, "c"),
+			now: now, err: ErrURLSignature},
+		{name: "kid of another key", verifier: newSigner(keyA, keyB), firmwareID: "fw-1",
+			query: with(URLParamKeyID, "b"), now: now, err: ErrURLSignature},
+		{name: "unsigned", verifier: newSigner(keyA), firmwareID: "fw-1", query: url.Values{}, now: now,
+			err: ErrURLNotSigned},
+		{name: "no keys", verifier: newSigner(), firmwareID: "fw-1", query: signed, now: now, err: ErrURLNotSigned},
+		{name: "nil signer", firmwareID: "fw-1", query: signed, now: now, err: ErrURLNotSigned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deviceID, err := tt.verifier.Verify(tt.firmwareID, tt.query, tt.now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && deviceID != "device-1" {
+				t.Errorf("Verify device = %q, want device-1", deviceID)
+			}
+		})
+	}
+
+	t.Run("signs with first key", func(t *testing.T) {
+		if kid := newSigner(keyB, keyA).Sign("fw-1", "device-1", expires).Get(URLParamKeyID); kid != "b" {
+			t.Errorf("signed with key %q, want b", kid)
+		}
+	})
+
+	t.Run("disabled without keys", func(t *testing.T) {
+		var nilSigner *URLSigner
+		if newSigner().Enabled() || nilSigner.Enabled() {
+			t.Error("signer without keys is enabled")
+		}
+		if !newSigner(keyA).Enabled() {
+			t.Error("signer with a key is disabled")
+		}
+	})
+}
+
+func TestNewURLSignerRejectsInvalidKeys(t *testing.T) {
+	secret := bytes.Repeat([]byte{'a'}, 32)
+	tests := []struct {
+		name string
+		keys []URLKey
+	}{
+		{name: "missing ID", keys: []URLKey{{Secret: secret}}},
+		{name: "short secret", keys: []URLKey{{ID: "a", Secret: secret[:31]}}},
+		{name: "duplicate ID", keys: []URLKey{{ID: "a", Secret: secret}, {ID: "a", Secret: secret}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewURLSigner(tt.keys); err == nil {
+				t.Error("NewURLSigner accepted invalid keys")
+			}
+		})
+	}
+}
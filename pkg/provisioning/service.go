@@ -91,14 +91,18 @@ func (s *ProvisioningService) Provision(ctx context.Context, req *pb.ProvisionRe
 	}
 
 	caCert := s.pkiService.GetCACertPEM()
+	updateSigner := s.pkiService.UpdateSigner()
 
 	return &pb.ProvisionResponse{
-		DeviceId:          deviceID,
-		ClientCertificate: clientCert,
-		ClientKey:         clientKey,
-		CaCertificate:     caCert,
-		MqttHost:          "mqtt.aura.example.com",
-		MqttPort:          8883,
+		DeviceId:            deviceID,
+		ClientCertificate:   clientCert,
+		ClientKey:           clientKey,
+		CaCertificate:       caCert,
+		MqttHost:            "mqtt.aura.example.com",
+		MqttPort:            8883,
+		UpdateSigningKey:    updateSigner.PublicKeyPEM(),
+		UpdateSigningKeyId:  updateSigner.KeyID(),
+		FirmwareSigningKeys: s.pkiService.BuildKeys().PublicKeysPEM(),
 	}, nil
 }
 
@@ -0,0 +1,57 @@
+package rollout
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultHealthyStatuses are the telemetry statuses accepted as healthy when
+// a release's guards do not list their own.
+var DefaultHealthyStatuses = []string{"healthy"}
+
+// HealthGuards are telemetry checks a device must pass after installing a
+// release before it counts as updated. Battery and temperature limits are
+// relative to the readings the device reported before it was sent the
+// update. A zero limit disables that check.
+type HealthGuards struct {
+	ReportWithinMinutes int      `json:"report_within_minutes"`
+	HealthyStatuses     []string `json:"healthy_statuses,omitempty"`
+	MinUptimeSeconds    int64    `json:"min_uptime_seconds,omitempty"`
+	MaxBatteryDrop      float64  `json:"max_battery_drop,omitempty"`
+	MaxTemperatureRise  float64  `json:"max_temperature_rise,omitempty"`
+}
+
+func (g HealthGuards) Validate() error {
+	if g.ReportWithinMinutes <= 0 {
+		return errors.New("report_within_minutes must be positive")
+	}
+	if g.MinUptimeSeconds < 0 {
+		return errors.New("min_uptime_seconds must not be negative")
+	}
+	if g.MaxBatteryDrop < 0 {
+		return errors.New("max_battery_drop must not be negative")
+	}
+	if g.MaxTemperatureRise < 0 {
+		return errors.New("max_temperature_rise must not be negative")
+	}
+	return nil
+}
+
+// ReportWithin is how long after installing a device has to pass every
+// guard.
+func (g HealthGuards) ReportWithin() time.Duration {
+	return time.Duration(g.ReportWithinMinutes) * time.Minute
+}
+
+func (g HealthGuards) IsHealthyStatus(status string) bool {
+	healthy := g.HealthyStatuses
+	if len(healthy) == 0 {
+		healthy = DefaultHealthyStatuses
+	}
+	for _, s := range healthy {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package rollout
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	StatusPending     = "pending"
+	StatusInProgress  = "in_progress"
+	StatusPaused      = "paused"
+	StatusAborted     = "aborted"
+	StatusCompleted   = "completed"
+	StatusRollingBack = "rolling_back"
+	StatusRolledBack  = "rolled_back"
+)
+
+const (
+	StageCanary     = "canary"
+	StageProduction = "production"
+	StageCompleted  = "completed"
+	StageRollback   = "rollback"
+)
+
+const (
+	ActionStart       = "start"
+	ActionPause       = "pause"
+	ActionResume      = "resume"
+	ActionAbort       = "abort"
+	ActionPromote     = "promote"
+	ActionRetryFailed = "retry-failed"
+	ActionRollback    = "rollback"
+	ActionRollbackEnd = "rollback-complete"
+	ActionComplete    = "complete"
+)
+
+const (
+	DeviceQueued         = "queued"
+	DeviceSent           = "sent"
+	DeviceDownloading    = "downloading"
+	DeviceInstalling     = "installing"
+	DeviceRebooting      = "rebooting"
+	DeviceVerifying      = "verifying"
+	DeviceStepping       = "stepping"
+	DeviceSkipped        = "skipped"
+	DeviceDeferred       = "deferred"
+	DeviceScheduled      = "scheduled"
+	DeviceRetrying       = "retrying"
+	DeviceUpdated        = "updated"
+	DeviceFailed         = "failed"
+	DeviceTimedOut       = "timed_out"
+	DeviceRollbackSent   = "rollback_sent"
+	DeviceRolledBack     = "rolled_back"
+	DeviceRollbackFailed = "rollback_failed"
+)
+
+// InFlightStatuses are the device statuses that occupy a dispatch slot: a
+// command has been sent and the device has not yet reported an outcome.
+var InFlightStatuses = []string{DeviceSent, DeviceStepping, DeviceDownloading, DeviceInstalling, DeviceRebooting}
+
+// HoldingStatuses are the device statuses in which a release holds a device:
+// an update or rollback command is outstanding or an installed update is
+// being verified. A device is held by at most one release at a time.
+var HoldingStatuses = []string{DeviceSent, DeviceStepping, DeviceDownloading, DeviceInstalling, DeviceRebooting,
+	DeviceVerifying, DeviceRollbackSent}
+
+// OpenStatuses are the release statuses in which a release may still send
+// commands to devices.
+var OpenStatuses = []string{StatusPending, StatusInProgress, StatusPaused, StatusRollingBack}
+
+var ErrIllegalTransition = errors.New("illegal release transition")
+
+type State struct {
+	Status string
+	Stage  string
+}
+
+// Transition returns the state a release moves to when the action is
+// applied, or ErrIllegalTransition if the action is not allowed from the
+// current state.
+func Transition(current State, action string) (State, error) {
+	next, ok := transition(current, action)
+	if !ok {
+		return State{}, fmt.Errorf("%w: cannot %s a release that is %s/%s",
+			ErrIllegalTransition, action, current.Status, current.Stage)
+	}
+	return next, nil
+}
+
+func transition(current State, action string) (State, bool) {
+	switch action {
+	case ActionStart:
+		if current.Status == StatusPending {
+			return State{StatusInProgress, StageCanary}, true
+		}
+	case ActionPause:
+		if current.Status == StatusInProgress {
+			return State{StatusPaused, current.Stage}, true
+		}
+	case ActionResume:
+		if current.Status == StatusPaused {
+			return State{StatusInProgress, current.Stage}, true
+		}
+	case ActionAbort:
+		switch current.Status {
+		case StatusPending, StatusInProgress, StatusPaused:
+			return State{StatusAborted, current.Stage}, true
+		}
+	case ActionPromote:
+		if (current.Status == StatusInProgress || current.Status == StatusPaused) && current.Stage == StageCanary {
+			return State{current.Status, StageProduction}, true
+		}
+	case ActionRetryFailed:
+		if current.Status == StatusInProgress || current.Status == StatusPaused {
+			return current, true
+		}
+	case ActionComplete:
+		if current.Status == StatusInProgress && current.Stage == StageProduction {
+			return State{StatusCompleted, StageCompleted}, true
+		}
+	case ActionRollback:
+		switch current.Status {
+		case StatusInProgress, StatusPaused, StatusCompleted, StatusAborted:
+			return State{StatusRollingBack, StageRollback}, true
+		}
+	case ActionRollbackEnd:
+		if current.Status == StatusRollingBack {
+			return State{StatusRolledBack, StageRollback}, true
+		}
+	}
+	return State{}, false
+}
@@ -0,0 +1,146 @@
+package rollout
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window is a recurring period during which update commands may be sent.
+// A window with an empty Group applies to devices whose group has no windows
+// of its own. A nil Location means the window is evaluated in each device's
+// local timezone.
+type Window struct {
+	Group       string
+	Days        []time.Weekday
+	StartMinute int
+	Duration    time.Duration
+	Location    *time.Location
+}
+
+// ParseWindow builds a window from its stored form: weekdays as 0 (Sunday)
+// to 6, a start time as "HH:MM", a duration in minutes and an optional IANA
+// timezone.
+func ParseWindow(group string, days []int64, start string, durationMinutes int, timezone string) (Window, error) {
+	w := Window{Group: group, Duration: time.Duration(durationMinutes) * time.Minute}
+
+	for _, d := range days {
+		if d < 0 || d > 6 {
+			return Window{}, fmt.Errorf("invalid weekday %d", d)
+		}
+		w.Days = append(w.Days, time.Weekday(d))
+	}
+
+	startTime, err := time.Parse("15:04", start)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid start time %q: expected HH:MM", start)
+	}
+	w.StartMinute = startTime.Hour()*60 + startTime.Minute()
+
+	if durationMinutes <= 0 || durationMinutes > 7*24*60 {
+		return Window{}, fmt.Errorf("invalid duration %d minutes", durationMinutes)
+	}
+
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return Window{}, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+		}
+		w.Location = loc
+	}
+
+	return w, nil
+}
+
+// next reports whether the window is open at t and, if it is not, when it
+// next opens.
+func (w Window) next(t time.Time, deviceLoc *time.Location) (bool, time.Time) {
+	loc := w.Location
+	if loc == nil {
+		loc = deviceLoc
+	}
+	local := t.In(loc)
+
+	var earliest time.Time
+	for offset := -7; offset <= 7; offset++ {
+		start := time.Date(local.Year(), local.Month(), local.Day()+offset,
+			w.StartMinute/60, w.StartMinute%60, 0, 0, loc)
+		if !w.runsOn(start.Weekday()) {
+			continue
+		}
+		end := start.Add(w.Duration)
+		if !t.Before(start) && t.Before(end) {
+			return true, t
+		}
+		if start.After(t) && (earliest.IsZero() || start.Before(earliest)) {
+			earliest = start
+		}
+	}
+	return false, earliest
+}
+
+func (w Window) runsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// NextDispatch reports whether a command may be sent now to a device in the
+// given group and timezone, and if not, when the earliest applicable window
+// opens. Devices with no applicable windows may be updated at any time.
+func NextDispatch(windows []Window, group string, deviceLoc *time.Location, now time.Time) (bool, time.Time) {
+	if deviceLoc == nil {
+		deviceLoc = time.UTC
+	}
+
+	applicable := windowsFor(windows, group)
+	if len(applicable) == 0 {
+		return true, now
+	}
+
+	var earliest time.Time
+	for _, w := range applicable {
+		open, at := w.next(now, deviceLoc)
+		if open {
+			return true, now
+		}
+		if !at.IsZero() && (earliest.IsZero() || at.Before(earliest)) {
+			earliest = at
+		}
+	}
+	return false, earliest
+}
+
+func windowsFor(windows []Window, group string) []Window {
+	var own, global []Window
+	for _, w := range windows {
+		switch {
+		case group != "" && w.Group == group:
+			own = append(own, w)
+		case w.Group == "":
+			global = append(global, w)
+		}
+	}
+	if len(own) > 0 {
+		return own
+	}
+	return global
+}
+
+// DeviceLocation returns the location for a device's configured timezone,
+// falling back to UTC when it is unset or unknown.
+func DeviceLocation(timezone *string) *time.Location {
+	if timezone == nil || *timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,96 @@
+package rollout
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestNextDispatch(t *testing.T) {
+	window := func(group string, days []int64, start string, minutes int, timezone string) Window {
+		t.Helper()
+		w, err := ParseWindow(group, days, start, minutes, timezone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	utc := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, time.UTC)
+	}
+	monday := []int64{int64(time.Monday)}
+
+	tests := []struct {
+		name     string
+		windows  []Window
+		group    string
+		timezone string
+		now      time.Time
+		open     bool
+		next     time.Time
+	}{
+		{name: "no windows", now: utc(3, 2, 12, 0), open: true},
+
+		// 2026-03-02 is a Monday.
+		{name: "before midnight", windows: []Window{window("", monday, "22:00", 240, "")},
+			now: utc(3, 2, 23, 0), open: true},
+		{name: "after midnight", windows: []Window{window("", monday, "22:00", 240, "")},
+			now: utc(3, 3, 1, 30), open: true},
+		{name: "closed after midnight", windows: []Window{window("", monday, "22:00", 240, "")},
+			now: utc(3, 3, 2, 0), next: utc(3, 9, 22, 0)},
+		{name: "closed before start", windows: []Window{window("", monday, "22:00", 240, "")},
+			now: utc(3, 2, 21, 0), next: utc(3, 2, 22, 0)},
+
+		// 2026-01-15 07:30 UTC is 02:30 in New York and 16:30 in Tokyo.
+		{name: "device timezone open", windows: []Window{window("", nil, "02:00", 120, "")},
+			timezone: "America/New_York", now: utc(1, 15, 7, 30), open: true},
+		{name: "device timezone closed", windows: []Window{window("", nil, "02:00", 120, "")},
+			timezone: "Asia/Tokyo", now: utc(1, 15, 7, 30), next: utc(1, 15, 17, 0)},
+		{name: "window timezone overrides device", windows: []Window{window("", nil, "02:00", 120, "America/New_York")},
+			timezone: "Asia/Tokyo", now: utc(1, 15, 7, 30), open: true},
+		{name: "unset device timezone is utc", windows: []Window{window("", nil, "02:00", 120, "")},
+			now: utc(1, 15, 7, 30), next: utc(1, 16, 2, 0)},
+
+		// New York moves to EDT at 02:00 on 2026-03-08 and back to EST at
+		// 02:00 on 2026-11-01.
+		{name: "start keeps wall clock into dst", windows: []Window{window("", nil, "03:00", 60, "America/New_York")},
+			now: utc(3, 7, 12, 0), next: utc(3, 8, 7, 0)},
+		{name: "start keeps wall clock out of dst", windows: []Window{window("", nil, "03:00", 60, "America/New_York")},
+			now: utc(10, 31, 12, 0), next: utc(11, 1, 8, 0)},
+		{name: "duration is elapsed time across dst", windows: []Window{window("", nil, "00:00", 240, "America/New_York")},
+			now: utc(3, 8, 8, 30), open: true},
+		{name: "start skipped by dst still opens", windows: []Window{window("", nil, "02:30", 60, "America/New_York")},
+			now: utc(3, 8, 5, 0), next: utc(3, 8, 6, 30)},
+		{name: "start repeated by dst opens once", windows: []Window{window("", nil, "01:30", 30, "America/New_York")},
+			now: utc(11, 1, 6, 45), next: utc(11, 2, 6, 30)},
+
+		{name: "group windows replace global", group: "lab", windows: []Window{
+			window("", nil, "00:00", 24*60, ""),
+			window("lab", nil, "22:00", 60, ""),
+		}, now: utc(3, 2, 12, 0), next: utc(3, 2, 22, 0)},
+		{name: "earliest of several windows", windows: []Window{
+			window("", nil, "22:00", 60, ""),
+			window("", nil, "14:00", 60, ""),
+		}, now: utc(3, 2, 12, 0), next: utc(3, 2, 14, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var timezone *string
+			if tt.timezone != "" {
+				timezone = &tt.timezone
+			}
+
+			open, next := NextDispatch(tt.windows, tt.group, DeviceLocation(timezone), tt.now)
+			if open != tt.open {
+				t.Fatalf("open = %v, want %v", open, tt.open)
+			}
+			want := tt.next
+			if tt.open {
+				want = tt.now
+			}
+			if !next.Equal(want) {
+				t.Errorf("next = %s, want %s", next.UTC(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// Package sbom reads the software bills of materials attached to firmware.
+// SPDX 2.x and CycloneDX 1.x documents are accepted in their JSON encodings,
+// and the packages or components they list are extracted so firmware can be
+// searched by what it contains.
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	FormatSPDX      = "spdx"
+	FormatCycloneDX = "cyclonedx"
+)
+
+// MaxSize bounds an SBOM document.
+const MaxSize = 8 << 20
+
+// ErrInvalidSBOM is returned for documents that are not a valid SBOM.
+var ErrInvalidSBOM = errors.New("invalid SBOM")
+
+var (
+	spdxVersion      = regexp.MustCompile(`^SPDX-2\.[0-9]+$`)
+	cycloneDXVersion = regexp.MustCompile(`^1\.[0-9]+$`)
+)
+
+// Document is a parsed SBOM.
+type Document struct {
+	Format      string
+	SpecVersion string
+	// Components are the software the firmware contains, each listed once.
+	Components []Component
+}
+
+// Component is a package or component listed in an SBOM. Version and PURL
+// are empty when the document does not give them.
+type Component struct {
+	Name    string
+	Version string
+	PURL    string
+}
+
+type spdxDocument struct {
+	SPDXVersion string `json:"spdxVersion"`
+	SPDXID      string `json:"SPDXID"`
+	Packages    []struct {
+		Name         string `json:"name"`
+		VersionInfo  string `json:"versionInfo"`
+		ExternalRefs []struct {
+			ReferenceType    string `json:"referenceType"`
+			ReferenceLocator string `json:"referenceLocator"`
+		} `json:"externalRefs"`
+	} `json:"packages"`
+}
+
+type cycloneDXDocument struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	Name       string               `json:"name"`
+	Version    string               `json:"version"`
+	PURL       string               `json:"purl"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+// Parse detects the format of a JSON SBOM, checks it is well formed and
+// extracts its components.
+func Parse(data []byte) (*Document, error) {
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalidSBOM, MaxSize)
+	}
+	var probe struct {
+		SPDXVersion *string `json:"spdxVersion"`
+		BOMFormat   *string `json:"bomFormat"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("%w: only SPDX and CycloneDX JSON documents are supported: %v", ErrInvalidSBOM, err)
+	}
+
+	switch {
+	case probe.SPDXVersion != nil:
+		return parseSPDX(data)
+	case probe.BOMFormat != nil:
+		return parseCycloneDX(data)
+	}
+	return nil, fmt.Errorf("%w: document is neither SPDX nor CycloneDX", ErrInvalidSBOM)
+}
+
+func parseSPDX(data []byte) (*Document, error) {
+	var spdx spdxDocument
+	if err := decode(data, &spdx); err != nil {
+		return nil, err
+	}
+	if !spdxVersion.MatchString(spdx.SPDXVersion) {
+		return nil, fmt.Errorf("%w: unsupported spdxVersion %q", ErrInvalidSBOM, spdx.SPDXVersion)
+	}
+	if spdx.SPDXID != "SPDXRef-DOCUMENT" {
+		return nil, fmt.Errorf("%w: SPDXID must be SPDXRef-DOCUMENT", ErrInvalidSBOM)
+	}
+
+	doc := &Document{Format: FormatSPDX, SpecVersion: strings.TrimPrefix(spdx.SPDXVersion, "SPDX-")}
+	seen := make(map[Component]bool)
+	for i, pkg := range spdx.Packages {
+		component := Component{Name: strings.TrimSpace(pkg.Name), Version: strings.TrimSpace(pkg.VersionInfo)}
+		if component.Name == "" {
+			return nil, fmt.Errorf("%w: package %d has no name", ErrInvalidSBOM, i)
+		}
+		for _, ref := range pkg.ExternalRefs {
+			if ref.ReferenceType == "purl" {
+				component.PURL = strings.TrimSpace(ref.ReferenceLocator)
+				break
+			}
+		}
+		doc.add(component, seen)
+	}
+	return doc, nil
+}
+
+func parseCycloneDX(data []byte) (*Document, error) {
+	var cdx cycloneDXDocument
+	if err := decode(data, &cdx); err != nil {
+		return nil, err
+	}
+	if cdx.BOMFormat != "CycloneDX" {
+		return nil, fmt.Errorf("%w: bomFormat must be CycloneDX", ErrInvalidSBOM)
+	}
+	if !cycloneDXVersion.MatchString(cdx.SpecVersion) {
+		return nil, fmt.Errorf("%w: unsupported specVersion %q", ErrInvalidSBOM, cdx.SpecVersion)
+	}
+
+	doc := &Document{Format: FormatCycloneDX, SpecVersion: cdx.SpecVersion}
+	seen := make(map[Component]bool)
+	var walk func(components []cycloneDXComponent) error
+	walk = func(components []cycloneDXComponent) error {
+		for _, c := range components {
+			component := Component{
+				Name:    strings.TrimSpace(c.Name),
+				Version: strings.TrimSpace(c.Version),
+				PURL:    strings.TrimSpace(c.PURL),
+			}
+			if component.Name == "" {
+				return fmt.Errorf("%w: component has no name", ErrInvalidSBOM)
+			}
+			doc.add(component, seen)
+			if err := walk(c.Components); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(cdx.Components); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func decode(data []byte, v any) error {
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSBOM, err)
+	}
+	return nil
+}
+
+func (d *Document) add(component Component, seen map[Component]bool) {
+	if seen[component] {
+		return
+	}
+	seen[component] = true
+	d.Components = append(d.Components, component)
+}
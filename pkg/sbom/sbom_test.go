@@ -0,0 +1,77 @@
+package sbom
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		format     string
+		components []Component
+	}{
+		{
+			name: "spdx",
+			data: `{"spdxVersion": "SPDX-2.3", "SPDXID": "SPDXRef-DOCUMENT", "name": "aura-firmware",
+				"packages": [
+					{"name": "mbedtls", "versionInfo": "3.5.2", "externalRefs": [
+						{"referenceCategory": "PACKAGE-MANAGER", "referenceType": "purl",
+						 "referenceLocator": "pkg:github/Mbed-TLS/mbedtls@3.5.2"}]},
+					{"name": "lwip"},
+					{"name": "lwip"}
+				]}`,
+			format: FormatSPDX,
+			components: []Component{
+				{Name: "mbedtls", Version: "3.5.2", PURL: "pkg:github/Mbed-TLS/mbedtls@3.5.2"},
+				{Name: "lwip"},
+			},
+		},
+		{
+			name: "cyclonedx",
+			data: `{"bomFormat": "CycloneDX", "specVersion": "1.5", "version": 1,
+				"metadata": {"component": {"type": "firmware", "name": "aura"}},
+				"components": [
+					{"type": "library", "name": "freertos", "version": "10.5.1", "components": [
+						{"type": "library", "name": "freertos-plus-tcp", "version": "4.0.0"}]}
+				]}`,
+			format: FormatCycloneDX,
+			components: []Component{
+				{Name: "freertos", Version: "10.5.1"},
+				{Name: "freertos-plus-tcp", Version: "4.0.0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.Format != tt.format {
+				t.Errorf("format = %s, want %s", doc.Format, tt.format)
+			}
+			if !slices.Equal(doc.Components, tt.components) {
+				t.Errorf("components = %+v, want %+v", doc.Components, tt.components)
+			}
+		})
+	}
+
+	invalid := map[string]string{
+		"not json":          `<bom xmlns="http://cyclonedx.org/schema/bom/1.5"/>`,
+		"unknown format":    `{"name": "aura"}`,
+		"spdx 3":            `{"spdxVersion": "SPDX-3.0", "SPDXID": "SPDXRef-DOCUMENT"}`,
+		"unnamed package":   `{"spdxVersion": "SPDX-2.3", "SPDXID": "SPDXRef-DOCUMENT", "packages": [{"versionInfo": "1"}]}`,
+		"wrong bom format":  `{"bomFormat": "cyclonedx", "specVersion": "1.5"}`,
+		"unnamed component": `{"bomFormat": "CycloneDX", "specVersion": "1.5", "components": [{"version": "1"}]}`,
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(data)); !errors.Is(err, ErrInvalidSBOM) {
+				t.Errorf("got %v, want ErrInvalidSBOM", err)
+			}
+		})
+	}
+}
@@ -1,10 +1,18 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+)
+
+var (
+	_ Storage       = (*LocalStorage)(nil)
+	_ LegacyStorage = (*LocalStorage)(nil)
 )
 
 type LocalStorage struct {
@@ -18,42 +26,179 @@ func NewLocalStorage(basePath string) (*LocalStorage, error) {
 	return &LocalStorage{basePath: basePath}, nil
 }
 
-func (s *LocalStorage) SaveFirmware(firmwareID string, data io.Reader) (string, int64, error) {
-	filePath := filepath.Join(s.basePath, firmwareID+".bin")
+func (s *LocalStorage) path(name string) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.basePath, name+".bin"), nil
+}
 
-	file, err := os.Create(filePath)
+// SaveFirmware moves the upload into place. When the upload is on another
+// filesystem it is copied next to its destination first, so the image still
+// appears in a single rename.
+func (s *LocalStorage) SaveFirmware(name string, upload *Upload) (string, error) {
+	filePath, err := s.path(name)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to create firmware file: %w", err)
+		return "", err
+	}
+	if _, err := os.Stat(filePath); err == nil {
+		upload.Discard()
+		return filePath, nil
+	}
+
+	if err := upload.file.Chmod(0644); err != nil {
+		return "", fmt.Errorf("failed to set firmware permissions: %w", err)
+	}
+	if err := os.Rename(upload.file.Name(), filePath); err == nil {
+		upload.file.Close()
+		upload.file = nil
+		return filePath, nil
 	}
-	defer file.Close()
 
-	size, err := io.Copy(file, data)
+	data, err := upload.reader()
+	if err != nil {
+		return "", fmt.Errorf("failed to read upload: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.basePath, "."+name+"-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create firmware file: %w", err)
+	}
+	_, err = io.Copy(tmp, data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filePath)
+	}
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to write firmware: %w", err)
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write firmware: %w", err)
 	}
 
-	return filePath, size, nil
+	upload.Discard()
+	return filePath, nil
+}
+
+func (s *LocalStorage) GetFirmware(name string) (io.ReadCloser, error) {
+	return s.open(name)
+}
+
+func (s *LocalStorage) GetFirmwareRange(name string, offset, length int64) (io.ReadCloser, error) {
+	file, err := s.open(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek firmware file: %w", err)
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
 }
 
-func (s *LocalStorage) GetFirmware(firmwareID string) (io.ReadCloser, error) {
-	filePath := filepath.Join(s.basePath, firmwareID+".bin")
+func (s *LocalStorage) open(name string) (*os.File, error) {
+	filePath, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
 	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open firmware file: %w", err)
+	}
+	return file, nil
+}
+
+func (s *LocalStorage) DeleteFirmware(name string) error {
+	filePath, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete firmware: %w", err)
+	}
+	return nil
+}
+
+// GetLegacyFirmware opens an image stored as <version>.bin, in a
+// subdirectory when the version contains a slash.
+func (s *LocalStorage) GetLegacyFirmware(version string) (io.ReadCloser, error) {
+	if err := checkLegacyName(version); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(s.basePath, filepath.FromSlash(version)+".bin"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to open firmware file: %w", err)
 	}
 	return file, nil
 }
 
-func (s *LocalStorage) DeleteFirmware(firmwareID string) error {
-	filePath := filepath.Join(s.basePath, firmwareID+".bin")
-	if err := os.Remove(filePath); err != nil {
+func (s *LocalStorage) DeleteLegacyFirmware(version string) error {
+	if err := checkLegacyName(version); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(s.basePath, filepath.FromSlash(version)+".bin"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return fmt.Errorf("failed to delete firmware: %w", err)
 	}
 	return nil
 }
 
-func (s *LocalStorage) FirmwareExists(firmwareID string) bool {
-	filePath := filepath.Join(s.basePath, firmwareID+".bin")
-	_, err := os.Stat(filePath)
-	return err == nil
+func (s *LocalStorage) FirmwareExists(name string) (bool, error) {
+	_, err := s.StatFirmware(name)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LocalStorage) StatFirmware(name string) (*ObjectInfo, error) {
+	filePath, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat firmware file: %w", err)
+	}
+	return &ObjectInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+func (s *LocalStorage) ListFirmware() ([]ObjectInfo, error) {
+	entries, err := os.ReadDir(s.basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firmware files: %w", err)
+	}
+
+	var objects []ObjectInfo
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".bin")
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat firmware file: %w", err)
+		}
+		objects = append(objects, ObjectInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return objects, nil
 }
@@ -0,0 +1,468 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	_ Storage       = (*S3Storage)(nil)
+	_ Presigner     = (*S3Storage)(nil)
+	_ LegacyStorage = (*S3Storage)(nil)
+)
+
+const (
+	s3Service       = "s3"
+	s3Algorithm     = "AWS4-HMAC-SHA256"
+	s3DateFormat    = "20060102T150405Z"
+	s3UnsignedBody  = "UNSIGNED-PAYLOAD"
+	s3EmptyBodyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	defaultPresignTTL = 15 * time.Minute
+)
+
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PresignTTL      time.Duration
+}
+
+// S3Storage keeps firmware in an S3-compatible bucket such as MinIO. Requests
+// are signed with AWS Signature Version 4 and use path-style addressing.
+type S3Storage struct {
+	endpoint   *url.URL
+	region     string
+	bucket     string
+	prefix     string
+	accessKey  string
+	secretKey  string
+	presignTTL time.Duration
+	client     *http.Client
+}
+
+// NewS3Storage connects to the bucket, creating it if it does not exist.
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3 bucket is required")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	presignTTL := cfg.PresignTTL
+	if presignTTL <= 0 {
+		presignTTL = defaultPresignTTL
+	}
+	s := &S3Storage{
+		endpoint:   endpoint,
+		region:     region,
+		bucket:     cfg.Bucket,
+		prefix:     cfg.Prefix,
+		accessKey:  cfg.AccessKeyID,
+		secretKey:  cfg.SecretAccessKey,
+		presignTTL: presignTTL,
+		client:     &http.Client{},
+	}
+	if err := s.ensureBucket(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *S3Storage) key(name string) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	return s.prefix + name + ".bin", nil
+}
+
+func (s *S3Storage) ensureBucket() error {
+	resp, err := s.do(http.MethodHead, "", nil, nil, -1, nil)
+	if err != nil {
+		return fmt.Errorf("failed to check S3 bucket: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to check S3 bucket: %s", resp.Status)
+	}
+
+	resp, err = s.do(http.MethodPut, "", nil, nil, 0, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create S3 bucket: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return fmt.Errorf("failed to create S3 bucket: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) SaveFirmware(name string, upload *Upload) (string, error) {
+	key, err := s.key(name)
+	if err != nil {
+		return "", err
+	}
+	location := "s3://" + s.bucket + "/" + key
+
+	exists, err := s.FirmwareExists(name)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		upload.Discard()
+		return location, nil
+	}
+
+	data, err := upload.reader()
+	if err != nil {
+		return "", fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	resp, err := s.do(http.MethodPut, key, nil, data, upload.Size(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to write firmware: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return "", fmt.Errorf("failed to write firmware: %w", err)
+	}
+	return location, nil
+}
+
+func (s *S3Storage) GetFirmware(name string) (io.ReadCloser, error) {
+	return s.GetFirmwareRange(name, 0, -1)
+}
+
+func (s *S3Storage) GetFirmwareRange(name string, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if offset > 0 || length >= 0 {
+		if length >= 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+		} else {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+	}
+	key, err := s.key(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(http.MethodGet, key, nil, nil, -1, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read firmware: %w", err)
+	}
+	if err := s3Error(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) DeleteFirmware(name string) error {
+	key, err := s.key(name)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodDelete, key, nil, nil, -1, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete firmware: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return fmt.Errorf("failed to delete firmware: %w", err)
+	}
+	return nil
+}
+
+// GetLegacyFirmware reads an image stored under <prefix><version>.bin.
+func (s *S3Storage) GetLegacyFirmware(version string) (io.ReadCloser, error) {
+	if err := checkLegacyName(version); err != nil {
+		return nil, err
+	}
+	resp, err := s.do(http.MethodGet, s.prefix+version+".bin", nil, nil, -1, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read firmware: %w", err)
+	}
+	if err := s3Error(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) DeleteLegacyFirmware(version string) error {
+	if err := checkLegacyName(version); err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodDelete, s.prefix+version+".bin", nil, nil, -1, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete firmware: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return fmt.Errorf("failed to delete firmware: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) FirmwareExists(name string) (bool, error) {
+	_, err := s.StatFirmware(name)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *S3Storage) StatFirmware(name string) (*ObjectInfo, error) {
+	key, err := s.key(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(http.MethodHead, key, nil, nil, -1, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat firmware: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return nil, err
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &ObjectInfo{Name: name, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Storage) ListFirmware() ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, "", query, nil, -1, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list firmware: %w", err)
+		}
+		var result listBucketResult
+		err = s3Error(resp)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list firmware: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			name, ok := strings.CutSuffix(strings.TrimPrefix(object.Key, s.prefix), ".bin")
+			if !ok || strings.Contains(name, "/") {
+				continue
+			}
+			objects = append(objects, ObjectInfo{Name: name, Size: object.Size, ModTime: object.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// PresignFirmware returns a URL that downloads the image without credentials
+// until the configured presign TTL elapses.
+func (s *S3Storage) PresignFirmware(name string) (string, error) {
+	now := time.Now().UTC()
+	key, err := s.key(name)
+	if err != nil {
+		return "", err
+	}
+	u := s.objectURL(key)
+	query := url.Values{
+		"X-Amz-Algorithm":     {s3Algorithm},
+		"X-Amz-Credential":    {s.accessKey + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format(s3DateFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(s.presignTTL.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u.RawQuery = canonicalQuery(query)
+	header := http.Header{"Host": {u.Host}}
+	signature := s.signature(now, http.MethodGet, u, header, []string{"host"}, s3UnsignedBody)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+func (s *S3Storage) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.Path = u.Path + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	return &u
+}
+
+// do sends a signed request for key, or for the bucket if key is empty.
+// size is the body length, or -1 when there is no body.
+func (s *S3Storage) do(method, key string, query url.Values, body io.Reader, size int64,
+	header http.Header) (*http.Response, error) {
+	u := s.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if size >= 0 {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	payloadHash := s3EmptyBodyHash
+	if body != nil {
+		payloadHash = s3UnsignedBody
+	}
+	now := time.Now().UTC()
+	req.Header.Set("Host", u.Host)
+	req.Header.Set("X-Amz-Date", now.Format(s3DateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	signature := s.signature(now, method, u, req.Header, signed, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, s.scope(now), strings.Join(signed, ";"), signature))
+	req.Header.Del("Host")
+
+	return s.client.Do(req)
+}
+
+func (s *S3Storage) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/" + s3Service + "/aws4_request"
+}
+
+// signature computes an AWS Signature Version 4 over the request.
+func (s *S3Storage) signature(now time.Time, method string, u *url.URL, header http.Header, signed []string,
+	payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signed {
+		headers.WriteString(name + ":" + strings.TrimSpace(header.Get(name)) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		headers.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		now.Format(s3DateFormat),
+		s.scope(now),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters and, unless
+// encodeSlash is set, the path separator.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+type s3ErrorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// s3Error turns a failed response into an error, mapping missing objects to
+// ErrNotFound.
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	var body s3ErrorResponse
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil && body.Code != "" {
+		return fmt.Errorf("S3 %s: %s", body.Code, body.Message)
+	}
+	return fmt.Errorf("S3 request failed: %s", resp.Status)
+}
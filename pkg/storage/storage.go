@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a firmware image is not in storage.
+	ErrNotFound = errors.New("firmware not found in storage")
+	// ErrInvalidName is returned for names that could address something
+	// outside the storage location.
+	ErrInvalidName = errors.New("invalid storage name")
+)
+
+// Storage holds firmware images by name. Images are stored under BlobName
+// of their checksum, so identical images are kept once.
+type Storage interface {
+	// SaveFirmware stores a received image under name in a single step and
+	// returns its location. Names are content addresses: if an image is
+	// already stored under name it is kept and the upload is discarded.
+	SaveFirmware(name string, upload *Upload) (string, error)
+	GetFirmware(name string) (io.ReadCloser, error)
+	// GetFirmwareRange reads length bytes from offset, or to the end of the
+	// image if length is negative.
+	GetFirmwareRange(name string, offset, length int64) (io.ReadCloser, error)
+	// DeleteFirmware removes an image. Removing a missing image succeeds.
+	DeleteFirmware(name string) error
+	FirmwareExists(name string) (bool, error)
+	StatFirmware(name string) (*ObjectInfo, error)
+	ListFirmware() ([]ObjectInfo, error)
+}
+
+// Presigner is implemented by backends that can hand out time-limited URLs
+// that let a client download an image directly.
+type Presigner interface {
+	PresignFirmware(name string) (string, error)
+}
+
+// LegacyStorage is implemented by backends that can reach images stored
+// under their firmware version, as older releases did. Versions may contain
+// a path separator, so they are not valid storage names.
+type LegacyStorage interface {
+	GetLegacyFirmware(version string) (io.ReadCloser, error)
+	// DeleteLegacyFirmware removes an image. Removing a missing image
+	// succeeds.
+	DeleteLegacyFirmware(version string) error
+}
+
+// BlobName returns the storage name of the image with the given hex SHA-256
+// checksum.
+func BlobName(checksum string) string {
+	return "sha256-" + strings.ToLower(checksum)
+}
+
+// checkName rejects names that are empty, contain a path separator or are a
+// relative path element, so a name always refers to a single object.
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("%w %q", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// checkLegacyName rejects versions that could address something outside the
+// storage location: absolute paths and relative path elements.
+func checkLegacyName(version string) error {
+	if strings.ContainsAny(version, "\\\x00") {
+		return fmt.Errorf("%w %q", ErrInvalidName, version)
+	}
+	for _, element := range strings.Split(version, "/") {
+		if element == "" || element == "." || element == ".." {
+			return fmt.Errorf("%w %q", ErrInvalidName, version)
+		}
+	}
+	return nil
+}
+
+type ObjectInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// rangeReader reads a stored image from its current offset to the end,
+// fetching only what is read so that seeking to a range does not read the
+// bytes before it.
+type rangeReader struct {
+	storage Storage
+	name    string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+// NewReadSeeker returns a reader over a stored image of the given size that
+// supports seeking, as http.ServeContent needs for range requests.
+func NewReadSeeker(storage Storage, name string, size int64) io.ReadSeekCloser {
+	return &rangeReader{storage: storage, name: name, size: size}
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.storage.GetFirmwareRange(r.name, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
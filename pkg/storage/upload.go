@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrTooLarge is returned when an upload exceeds the maximum firmware size.
+var ErrTooLarge = errors.New("firmware exceeds the maximum size")
+
+// Upload is a received firmware image waiting in a temporary file to be
+// saved. It is hashed while it is written, so the image is read only once
+// and never held in memory.
+type Upload struct {
+	file   *os.File
+	size   int64
+	sha256 []byte
+	sha512 []byte
+}
+
+// NewUpload writes data to a temporary file in dir, or the system temporary
+// directory if dir is empty. Nothing is left behind if it fails, including
+// when data is larger than maxSize.
+func NewUpload(dir string, data io.Reader, maxSize int64) (*Upload, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create upload directory: %w", err)
+		}
+	}
+	file, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	upload := &Upload{file: file}
+
+	h256, h512 := sha256.New(), sha512.New()
+	size, err := io.Copy(file, io.TeeReader(io.LimitReader(data, maxSize+1), io.MultiWriter(h256, h512)))
+	if err == nil && size > maxSize {
+		err = ErrTooLarge
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		upload.Discard()
+		if errors.Is(err, ErrTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to write upload: %w", err)
+	}
+
+	upload.size = size
+	upload.sha256 = h256.Sum(nil)
+	upload.sha512 = h512.Sum(nil)
+	return upload, nil
+}
+
+// OpenUpload takes over a file that was received in pieces, hashing it in a
+// single pass. Saving or discarding the upload removes the file from path.
+func OpenUpload(path string, maxSize int64) (*Upload, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+	upload := &Upload{file: file}
+
+	h256, h512 := sha256.New(), sha512.New()
+	size, err := io.Copy(io.MultiWriter(h256, h512), io.LimitReader(file, maxSize+1))
+	if err == nil && size > maxSize {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		file.Close()
+		if errors.Is(err, ErrTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	upload.size = size
+	upload.sha256 = h256.Sum(nil)
+	upload.sha512 = h512.Sum(nil)
+	return upload, nil
+}
+
+func (u *Upload) Size() int64 {
+	return u.size
+}
+
+func (u *Upload) SHA256() []byte {
+	return u.sha256
+}
+
+func (u *Upload) SHA512() []byte {
+	return u.sha512
+}
+
+// Checksum is the hex SHA-256 digest recorded with the firmware.
+func (u *Upload) Checksum() string {
+	return hex.EncodeToString(u.sha256)
+}
+
+// reader returns the image from its start.
+func (u *Upload) reader() (io.Reader, error) {
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return u.file, nil
+}
+
+// ReaderAt reads the received file in place, such as to unpack an archive.
+func (u *Upload) ReaderAt() io.ReaderAt {
+	return u.file
+}
+
+// Release closes the upload but leaves its file in place, so it can be
+// opened again.
+func (u *Upload) Release() {
+	if u.file == nil {
+		return
+	}
+	u.file.Close()
+	u.file = nil
+}
+
+// Discard removes the temporary file. It is safe to call after the upload
+// has been saved.
+func (u *Upload) Discard() {
+	if u.file == nil {
+		return
+	}
+	u.file.Close()
+	os.Remove(u.file.Name())
+	u.file = nil
+}
@@ -0,0 +1,75 @@
+package version
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	valid := map[string]Version{
+		"1.2.3":              {Major: 1, Minor: 2, Patch: 3},
+		"v1.2.3":             {Major: 1, Minor: 2, Patch: 3},
+		"1.2.3-rc.1":         {Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1"},
+		"1.2.3-rc.1+build.5": {Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1"},
+		"1.2.3-x-y":          {Major: 1, Minor: 2, Patch: 3, Prerelease: "x-y"},
+	}
+	for s, want := range valid {
+		t.Run(s, func(t *testing.T) {
+			v, err := Parse(s)
+			if err != nil || v != want {
+				t.Errorf("Parse(%q) = %+v, %v, want %+v", s, v, err, want)
+			}
+		})
+	}
+
+	for _, s := range []string{"", "1.2", "1.2.3.4", "1.+2.3", "1.2.-3", "a.b.c", "1.2.3-", "1.2.3-rc..1",
+		"1.2.3-rc_1", "1. 2.3"} {
+		t.Run(s, func(t *testing.T) {
+			if v, err := Parse(s); err == nil {
+				t.Errorf("Parse(%q) = %+v, want an error", s, v)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// Each version sorts before the next, following SemVer 2.0 §11.
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0-rc.2",
+		"1.0.0-rc.10",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got, err := Compare(a, b); err != nil || got != want {
+				t.Errorf("Compare(%s, %s) = %d, %v, want %d", a, b, got, err, want)
+			}
+		}
+	}
+
+	equal := [][2]string{
+		{"1.0.0+build.1", "1.0.0+build.2"},
+		{"v1.0.0", "1.0.0"},
+		{"1.0.0-rc.01", "1.0.0-rc.1"},
+		{"1.0.0-rc.99999999999999999999", "1.0.0-rc.99999999999999999999"},
+	}
+	for _, pair := range equal {
+		if got, err := Compare(pair[0], pair[1]); err != nil || got != 0 {
+			t.Errorf("Compare(%s, %s) = %d, %v, want 0", pair[0], pair[1], got, err)
+		}
+	}
+}
@@ -29,7 +29,7 @@ export const releaseService = {
   getAll: () => api.get('/releases'),
   getById: (id) => api.get(`/releases/${id}`),
   create: (data) => api.post('/releases', data),
-  updateStatus: (id, data) => api.put(`/releases/${id}/status`, data),
+  action: (id, action, data) => api.post(`/releases/${id}/${action}`, data),
 }
 
 export default api